@@ -13,14 +13,36 @@ import (
 
 const (
 	referenceLocalSource = "local"
+	referenceOnchain     = "onchain" // compares a source with the inclusion block timestamps (InclusionTimes)
+
+	// Tie policies: how to count txs for which source and reference have equal timestamps
+	TiePolicyEqual = "equal" // neither was first (default)
+	TiePolicySrc   = "src"   // count as first by the source
+	TiePolicyRef   = "ref"   // count as first by the reference
+
+	// clock drift is only estimated for source pairs with at least this many txs seen by both
+	clockDriftMinSharedTxs = 100
 )
 
 var (
 	bucketsMS = []int64{1, 10, 50, 100, 250, 500, 1000, 5000} // note: 0 would be equal timestamps
 
+	// default source/reference pairs for the latency comparison
+	latencyComps = []sourceComp{
+		{common.BloxrouteTag, referenceLocalSource},
+		{common.ChainboundTag, referenceLocalSource},
+		{common.BloxrouteTag, common.ChainboundTag},
+		{common.ChainboundTag, common.BloxrouteTag},
+	}
+
 	printer = message.NewPrinter(language.English)
 )
 
+// sourceComp is a source/reference pair for the latency comparison
+type sourceComp struct {
+	src, ref string
+}
+
 func prettyInt(i int) string {
 	return printer.Sprintf("%d", i)
 }
@@ -29,14 +51,53 @@ func prettyInt64(i int64) string {
 	return printer.Sprintf("%d", i)
 }
 
+// prettyMS renders a duration of µs in ms, with the fraction only if there is one (i.e. not for ms timestamps)
+func prettyMS(us int64) string {
+	if us%1000 == 0 {
+		return prettyInt64(us / 1000)
+	}
+	return printer.Sprintf("%.3f", float64(us)/1000)
+}
+
+// usToMS converts a duration of µs to ms, keeping the fraction
+func usToMS(us int64) float64 {
+	return float64(us) / 1000
+}
+
+type AnalyzerOpts struct {
+	Transactions     map[string]map[string]int64 // [hash][src] = timestamp (µs, see common.LoadSourceLogFiles)
+	PrevKnownTxs     map[string]bool             // [hash] = true
+	BootstrapSamples int                         // number of bootstrap resamples for confidence intervals of the latency percentiles (0 = disabled, CPU-heavy)
+	TiePolicy        string                      // how to count equal timestamps (TiePolicyEqual, TiePolicySrc or TiePolicyRef)
+	AddedValueMS     int64                       // a source adds value for a tx if it was first by more than this many ms over all other sources
+	MinSharedTxs     int                         // comparisons of sources with fewer txs seen by both are skipped, as their stats are mostly noise (0 = report all)
+	TrimFraction     float64                     // drop this fraction of the lowest and highest latency deltas before computing the percentiles (0 = none, robust to outliers)
+	ClockDriftWarnMS int64                       // warn about source pairs with a larger median timestamp offset, which indicates clock drift (0 = disabled)
+	TxWhitelist      map[string]bool             // [hash] = true, only these txs are analyzed (empty = all)
+	TxBlacklist      map[string]bool             // [hash] = true, these txs are never analyzed
+	InclusionTimes   map[string]int64            // [hash] = block timestamp (ms) of the inclusion, enables the dwell time report (empty = disabled)
+	DwellPerSource   bool                        // also report the dwell time since the sighting by each source
+	CoverageTarget   float64                     // report the minimal set of sources (greedy) which sees this percentage of unique txs (0 = disabled)
+	CoverageTimes    []float64                   // report the time at which each source had seen these percentages of unique txs (empty = disabled)
+	CoverageBucket   time.Duration               // time resolution of CoverageTimes
+	SourceComps      []sourceComp                // source/reference pairs for the latency comparison (default: latencyComps)
+	Candidate        string                      // report the added coverage and latency of this source over the best of all others (empty = disabled)
+}
+
 type Analyzer struct {
-	txs          map[string]map[string]int64 // [hash][src] = timestamp
+	opts AnalyzerOpts
+
+	txs          map[string]map[string]int64 // [hash][src] = timestamp (µs)
 	prevKnownTxs map[string]bool             // [hash] = true
 
 	sources   []string // sorted alphabetically
 	nUniqueTx int
 	nAllTx    int
 
+	comps        []sourceComp // the valid latency comparisons
+	compsSkip    []string     // the skipped latency comparisons, with the reason
+	onchainComps []string     // the sources of the valid comparisons with the onchain reference
+
 	nTransactionsPerSource map[string]int64
 	nUniqueTxPerSource     map[string]int64
 
@@ -46,6 +107,10 @@ type Analyzer struct {
 
 	nTxSeenBySingleSource int64
 
+	nFirstWinsPerSource map[string]int64 // number of txs seen by multiple sources, which the source received strictly first
+
+	nTxPerMinute map[int64]int64 // [minute timestamp] = number of unique txs first seen in that minute
+
 	timestampFirst int64
 	timestampLast  int64
 	timeFirst      time.Time
@@ -53,25 +118,40 @@ type Analyzer struct {
 	duration       time.Duration
 }
 
-func NewAnalyzer(transactions map[string]map[string]int64, prevKnownTxs map[string]bool) *Analyzer {
+func NewAnalyzer(opts AnalyzerOpts) *Analyzer {
+	if opts.TiePolicy == "" {
+		opts.TiePolicy = TiePolicyEqual
+	}
+
 	a := &Analyzer{ //nolint:exhaustruct
-		txs:                    transactions,
-		prevKnownTxs:           prevKnownTxs,
+		opts:                   opts,
+		txs:                    opts.Transactions,
+		prevKnownTxs:           opts.PrevKnownTxs,
 		nTransactionsPerSource: make(map[string]int64),
 		nUniqueTxPerSource:     make(map[string]int64),
 		nNotSeenLocalPerSource: make(map[string]int64),
+		nFirstWinsPerSource:    make(map[string]int64),
+		nTxPerMinute:           make(map[int64]int64),
 	}
 
 	a.init()
 	return a
 }
 
+// skipTx returns whether a tx is excluded from the analysis: previously known, blacklisted or not whitelisted
+func (a *Analyzer) skipTx(txHashLower string) bool {
+	if a.prevKnownTxs[txHashLower] || a.opts.TxBlacklist[txHashLower] {
+		return true
+	}
+	return len(a.opts.TxWhitelist) > 0 && !a.opts.TxWhitelist[txHashLower]
+}
+
 // Init does some efficient initial data analysis and preparation for later use
 func (a *Analyzer) init() {
 	// iterate over tx to
 	for txHash, sources := range a.txs {
 		txHashLower := strings.ToLower(txHash)
-		if a.prevKnownTxs[txHashLower] {
+		if a.skipTx(txHashLower) {
 			continue
 		}
 
@@ -86,6 +166,22 @@ func (a *Analyzer) init() {
 			a.nOverallNotSeenLocal += 1
 		}
 
+		// count unique txs per minute of the first sighting
+		firstTS := int64(0)
+		for _, ts := range sources {
+			if firstTS == 0 || ts < firstTS {
+				firstTS = ts
+			}
+		}
+		a.nTxPerMinute[firstTS/60_000_000] += 1
+
+		// count the source which received the tx strictly first
+		if len(sources) > 1 {
+			if winner := firstSource(sources); winner != "" {
+				a.nFirstWinsPerSource[winner] += 1
+			}
+		}
+
 		// iterate over all sources for a given hash
 		for src, timestamp := range sources {
 			// get number of unique transactions by any single source
@@ -113,56 +209,277 @@ func (a *Analyzer) init() {
 	}
 
 	// convert timestamps to duration and UTC time
-	a.duration = time.Duration(a.timestampLast-a.timestampFirst) * time.Millisecond
-	a.timeFirst = time.Unix(a.timestampFirst/1000, 0).UTC()
-	a.timeLast = time.Unix(a.timestampLast/1000, 0).UTC()
+	a.duration = time.Duration(a.timestampLast-a.timestampFirst) * time.Microsecond
+	a.timeFirst = time.Unix(a.timestampFirst/1e6, 0).UTC()
+	a.timeLast = time.Unix(a.timestampLast/1e6, 0).UTC()
 
 	// get sorted list of sources
 	for src := range a.nTransactionsPerSource {
 		a.sources = append(a.sources, src)
 	}
 	sort.Strings(a.sources)
+
+	a.validateSourceComps()
 }
 
-func (a *Analyzer) benchmarkSourceVsLocal(src, ref string) (srcFirstBuckets map[int64]int64, totalFirstBySrc, totalSeenByBoth int) {
-	srcFirstBuckets = make(map[int64]int64) // [bucket_ms] = count
+// validateSourceComps keeps the latency comparisons of two different sources which are both in the data, as the
+// others would only produce meaningless tables (i.e. all deltas zero, or nothing seen by both)
+func (a *Analyzer) validateSourceComps() {
+	comps := a.opts.SourceComps
+	if comps == nil {
+		comps = latencyComps
+	}
+
+	for _, comp := range comps {
+		switch {
+		case comp.src == comp.ref:
+			a.compsSkip = append(a.compsSkip, fmt.Sprintf("%s vs %s (same source)", comp.src, comp.ref))
+		case a.nTransactionsPerSource[comp.src] == 0:
+			a.compsSkip = append(a.compsSkip, fmt.Sprintf("%s vs %s (%s not in the data)", comp.src, comp.ref, comp.src))
+		case comp.ref == referenceOnchain && len(a.opts.InclusionTimes) == 0:
+			a.compsSkip = append(a.compsSkip, fmt.Sprintf("%s vs %s (no inclusion times)", comp.src, comp.ref))
+		case comp.ref == referenceOnchain:
+			a.onchainComps = append(a.onchainComps, comp.src)
+		case a.nTransactionsPerSource[comp.ref] == 0:
+			a.compsSkip = append(a.compsSkip, fmt.Sprintf("%s vs %s (%s not in the data)", comp.src, comp.ref, comp.ref))
+		default:
+			a.comps = append(a.comps, comp)
+		}
+	}
+}
+
+// peakMinute returns the minute (as unix timestamp / 60) with the most unique txs first seen, and that number
+func (a *Analyzer) peakMinute() (minute, cnt int64) {
+	for m, c := range a.nTxPerMinute {
+		if c > cnt || (c == cnt && m < minute) {
+			minute, cnt = m, c
+		}
+	}
+	return minute, cnt
+}
+
+// firstSource returns the source with the earliest timestamp, or an empty string if it's shared by multiple sources
+func firstSource(sources map[string]int64) string {
+	first := ""
+	firstTS := int64(0)
+	isTie := false
+	for src, ts := range sources {
+		switch {
+		case first == "" || ts < firstTS:
+			first, firstTS, isTie = src, ts, false
+		case ts == firstTS:
+			isTie = true
+		}
+	}
+	if isTie {
+		return ""
+	}
+	return first
+}
+
+// neverFirstSources returns the sources (sorted) which saw txs also seen by others, but never received any tx first.
+// These are likely misconfigured or useless.
+func (a *Analyzer) neverFirstSources() []string {
+	res := []string{}
+	for _, src := range a.sources {
+		seenByOthers := a.nTransactionsPerSource[src] - a.nUniqueTxPerSource[src]
+		if seenByOthers > 0 && a.nFirstWinsPerSource[src] == 0 {
+			res = append(res, src)
+		}
+	}
+	return res
+}
+
+// comparisonResult holds the result of comparing the timestamps of txs seen by both a source and a reference source
+type comparisonResult struct {
+	src, ref        string
+	srcFirstBuckets map[int64]int64 // [bucket_ms] = count
+	totalFirstBySrc int
+	totalFirstByRef int
+	totalEqual      int // txs with equal timestamps (counted as first by src or ref depending on the tie policy)
+	totalSeenByBoth int
+	onlyBySrc       int     // txs seen by src but not by ref (coverage, regardless of latency)
+	onlyByRef       int     // txs seen by ref but not by src
+	deltas          []int64 // ref timestamp minus src timestamp (µs) for each tx seen by both, i.e. positive if src was first
+}
+
+// tooFewShared returns whether a comparison is skipped, because too few txs were seen by both sources
+func (a *Analyzer) tooFewShared(res *comparisonResult) bool {
+	return res.totalSeenByBoth < a.opts.MinSharedTxs
+}
+
+// srcWins decides whether src was first, given the timestamp difference (ref - src) and the tie policy for equal timestamps
+func srcWins(diff int64, tiePolicy string) bool {
+	if diff == 0 {
+		return tiePolicy == TiePolicySrc
+	}
+	return diff > 0
+}
+
+// refWins decides whether ref was first, given the timestamp difference (ref - src) and the tie policy for equal timestamps
+func refWins(diff int64, tiePolicy string) bool {
+	if diff == 0 {
+		return tiePolicy == TiePolicyRef
+	}
+	return diff < 0
+}
+
+func (a *Analyzer) benchmarkSourceVsLocal(src, ref string) *comparisonResult {
+	res := &comparisonResult{ //nolint:exhaustruct
+		src:             src,
+		ref:             ref,
+		srcFirstBuckets: make(map[int64]int64),
+	}
 
 	// How much earlier were transactions received by blx vs. the local node?
 	for txHash, sources := range a.txs {
 		txHashLower := strings.ToLower(txHash)
-		if a.prevKnownTxs[txHashLower] {
+		if a.skipTx(txHashLower) {
 			continue
 		}
 
-		if len(sources) == 1 {
-			continue
+		// ensure tx was seen by both source and reference nodes, otherwise it counts for the coverage difference
+		_, seenBySrc := sources[src]
+		_, seenByRef := sources[ref]
+		if seenBySrc && !seenByRef {
+			res.onlyBySrc += 1
 		}
-
-		// ensure tx was seen by both source and reference nodes
-		if _, seenBySrc := sources[src]; !seenBySrc {
-			continue
+		if seenByRef && !seenBySrc {
+			res.onlyByRef += 1
 		}
-		if _, seenByRef := sources[ref]; !seenByRef {
+		if !seenBySrc || !seenByRef {
 			continue
 		}
 
-		totalSeenByBoth += 1
+		res.totalSeenByBoth += 1
 
 		srcTS := sources[src]
 		localTS := sources[ref]
 		diff := localTS - srcTS
+		res.deltas = append(res.deltas, diff)
+
+		if diff == 0 {
+			res.totalEqual += 1
+		}
 
-		if diff > 0 {
-			totalFirstBySrc += 1
+		if srcWins(diff, a.opts.TiePolicy) {
+			res.totalFirstBySrc += 1
 			for _, thresholdMS := range bucketsMS {
-				if diff >= thresholdMS {
-					srcFirstBuckets[thresholdMS] += 1
+				if diff >= thresholdMS*1000 {
+					res.srcFirstBuckets[thresholdMS] += 1
+				}
+			}
+		}
+		if refWins(diff, a.opts.TiePolicy) {
+			res.totalFirstByRef += 1
+		}
+	}
+
+	return res
+}
+
+// addedValue returns, for each source, the number of txs seen by multiple sources where it was first by more than
+// thresholdMS over all others. This is the marginal contribution of a source, in addition to exclusive txs.
+func (a *Analyzer) addedValue(thresholdMS int64) map[string]int64 {
+	cnt := make(map[string]int64)
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) {
+			continue
+		}
+
+		if len(sources) == 1 {
+			continue
+		}
+
+		// find the first and second earliest timestamps
+		firstSrc := ""
+		firstTS, secondTS := int64(0), int64(0)
+		for src, ts := range sources {
+			if firstSrc == "" || ts < firstTS {
+				if firstSrc != "" {
+					secondTS = firstTS
 				}
+				firstSrc, firstTS = src, ts
+			} else if secondTS == 0 || ts < secondTS {
+				secondTS = ts
+			}
+		}
+
+		if secondTS-firstTS > thresholdMS*1000 {
+			cnt[firstSrc] += 1
+		}
+	}
+	return cnt
+}
+
+// propagationSpreads returns, for each tx seen by multiple sources, the time between the first and the last
+// sighting (µs). This is the propagation time of the tx across all sources.
+func (a *Analyzer) propagationSpreads() []int64 {
+	spreads := make([]int64, 0)
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) {
+			continue
+		}
+
+		if len(sources) == 1 {
+			continue
+		}
+
+		minTS, maxTS := int64(0), int64(0)
+		for _, ts := range sources {
+			if minTS == 0 || ts < minTS {
+				minTS = ts
+			}
+			if ts > maxTS {
+				maxTS = ts
+			}
+		}
+		spreads = append(spreads, maxTS-minTS)
+	}
+	return spreads
+}
+
+// clockOffset is the estimated systematic offset between the timestamps of two sources
+type clockOffset struct {
+	src, ref  string
+	medianUS  int64 // median of ref - src (µs) over the txs seen by both
+	sharedTxs int
+}
+
+// clockOffsets estimates the systematic timestamp offset of all source pairs as the median delta of the txs seen by
+// both. Assuming symmetric propagation, this is small for sources recorded with synchronized clocks. A large offset
+// points at clock drift of a collector host (i.e. NTP issues), which biases every comparison involving the source.
+// Returns the pairs with an offset larger than thresholdMS.
+func (a *Analyzer) clockOffsets(thresholdMS int64) []clockOffset {
+	offsets := make([]clockOffset, 0)
+	for i, src := range a.sources {
+		for _, ref := range a.sources[i+1:] {
+			res := a.benchmarkSourceVsLocal(src, ref)
+			if res.totalSeenByBoth < clockDriftMinSharedTxs {
+				continue
+			}
+
+			median := percentile(sortedCopy(res.deltas), 50)
+			if median > thresholdMS*1000 || median < -thresholdMS*1000 {
+				offsets = append(offsets, clockOffset{src, ref, median, res.totalSeenByBoth})
 			}
 		}
 	}
+	return offsets
+}
 
-	return srcFirstBuckets, totalFirstBySrc, totalSeenByBoth
+// clockDriftWarnings describes the source pairs with a median timestamp offset larger than ClockDriftWarnMS (none if disabled)
+func (a *Analyzer) clockDriftWarnings() []string {
+	if a.opts.ClockDriftWarnMS <= 0 {
+		return nil
+	}
+	warnings := make([]string, 0)
+	for _, offset := range a.clockOffsets(a.opts.ClockDriftWarnMS) {
+		warnings = append(warnings, fmt.Sprintf("possible clock drift, median timestamp offset of %s vs %s is %s ms (%s txs seen by both)", offset.src, offset.ref, prettyMS(offset.medianUS), prettyInt(offset.sharedTxs)))
+	}
+	return warnings
 }
 
 func (a *Analyzer) Print() {
@@ -187,6 +504,12 @@ func (a *Analyzer) Sprint() string {
 	out += fmt.Sprintln("-------------")
 	out += fmt.Sprintln("")
 	out += fmt.Sprintf("Unique transactions: %s \n", prettyInt(a.nUniqueTx))
+	if a.duration > 0 {
+		out += fmt.Sprintf("Average rate:        %s tx/sec \n", printer.Sprintf("%.2f", float64(a.nUniqueTx)/a.duration.Seconds()))
+	}
+	if peakMinute, peakCnt := a.peakMinute(); peakCnt > 0 {
+		out += fmt.Sprintf("Peak rate:           %s tx/min (%s) \n", prettyInt64(peakCnt), time.Unix(peakMinute*60, 0).UTC().Format("2006-01-02 15:04"))
+	}
 
 	out += fmt.Sprintln("")
 
@@ -216,30 +539,139 @@ func (a *Analyzer) Sprint() string {
 		}
 	}
 
+	out += fmt.Sprintln("")
+	out += "Received first (of txs seen by multiple sources): \n"
+	for _, src := range a.sources {
+		if a.nTransactionsPerSource[src] > 0 {
+			out += fmt.Sprintf("- %-10s %10s\n", src, prettyInt64(a.nFirstWinsPerSource[src]))
+		}
+	}
+	if neverFirst := a.neverFirstSources(); len(neverFirst) > 0 {
+		out += fmt.Sprintf("Warning: sources which never received a tx first: %s\n", strings.Join(neverFirst, ", "))
+	}
+
+	out += fmt.Sprintln("")
+	out += fmt.Sprintf("Added value (first by more than %d ms over all other sources): \n", a.opts.AddedValueMS)
+	addedValue := a.addedValue(a.opts.AddedValueMS)
+	for _, src := range a.sources {
+		if a.nTransactionsPerSource[src] > 0 {
+			cnt := addedValue[src]
+			out += fmt.Sprintf("- %-10s %10s   (%7s) \n", src, prettyInt64(cnt), common.Int64DiffPercentFmt(cnt, a.nTransactionsPerSource[src]))
+		}
+	}
+
+	if spreads := a.propagationSpreads(); len(spreads) > 0 {
+		sorted := sortedCopy(spreads)
+		out += fmt.Sprintln("")
+		out += "Propagation spread (last - first sighting of txs seen by multiple sources, ms): \n"
+		for _, p := range latencyPercentiles {
+			s := fmt.Sprintf("p%.0f", p)
+			out += fmt.Sprintf("- %-8s %10s\n", s, prettyMS(percentile(sorted, p)))
+		}
+	}
+
+	if a.opts.CoverageTarget > 0 && len(a.sources) > 1 {
+		out += fmt.Sprintln("")
+		out += a.sprintSourceRedundancy(a.opts.CoverageTarget)
+	}
+
+	if len(a.opts.CoverageTimes) > 0 {
+		out += fmt.Sprintln("")
+		out += a.sprintTimeToCoverage(a.opts.CoverageBucket, a.opts.CoverageTimes)
+	}
+
+	for _, warning := range a.clockDriftWarnings() {
+		out += fmt.Sprintln("")
+		out += fmt.Sprintf("Warning: %s\n", warning)
+	}
+
+	if len(a.opts.InclusionTimes) > 0 {
+		out += fmt.Sprintln("")
+		out += fmt.Sprintln("------------------")
+		out += fmt.Sprintln("Mempool dwell time")
+		out += fmt.Sprintln("------------------")
+		out += fmt.Sprintln("")
+		out += a.sprintDwellTimes()
+		out += fmt.Sprintln("")
+		out += a.sprintBlockCoverage()
+	}
+
+	if a.opts.Candidate != "" {
+		title := "Candidate source: " + a.opts.Candidate
+		out += fmt.Sprintln("")
+		out += fmt.Sprintln(strings.Repeat("-", len(title)))
+		out += fmt.Sprintln(title)
+		out += fmt.Sprintln(strings.Repeat("-", len(title)))
+		out += fmt.Sprintln("")
+		out += a.sprintCandidate(a.opts.Candidate)
+	}
+
 	// latency analysis for various sources:
 	out += fmt.Sprintln("")
 	out += fmt.Sprintln("------------------")
 	out += fmt.Sprintln("Latency comparison")
 	out += fmt.Sprintln("------------------")
-	latencyComps := []struct{ src, ref string }{
-		{common.BloxrouteTag, referenceLocalSource},
-		{common.ChainboundTag, referenceLocalSource},
-		{common.BloxrouteTag, common.ChainboundTag},
-		{common.ChainboundTag, common.BloxrouteTag},
+	if len(a.compsSkip) > 0 {
+		out += fmt.Sprintln("")
+		out += fmt.Sprintf("Skipped comparisons: %s\n", strings.Join(a.compsSkip, ", "))
 	}
-
-	for _, comp := range latencyComps {
-		srcFirstBuckets, totalFirstBySrc, totalSeenByBoth := a.benchmarkSourceVsLocal(comp.src, comp.ref)
+	for _, comp := range a.comps {
+		res := a.benchmarkSourceVsLocal(comp.src, comp.ref)
 
 		out += fmt.Sprintln("")
+		out += fmt.Sprintf("Seen only by %s: %s, only by %s: %s\n", comp.src, prettyInt(res.onlyBySrc), comp.ref, prettyInt(res.onlyByRef))
 		// out += fmt.Sprintf("%s transactions received before %s: %s / %s (%s) \n", comp.src, comp.ref, prettyInt64(int64(totalFirstBySrc)), prettyInt64(int64(totalSeenByBoth)), common.Int64DiffPercentFmt(int64(totalFirstBySrc), int64(totalSeenByBoth)))
-		out += fmt.Sprintf("%s transactions received before %s: %s / %s (%s)\n", comp.src, comp.ref, prettyInt(totalFirstBySrc), prettyInt(totalSeenByBoth), common.Int64DiffPercentFmt(int64(totalFirstBySrc), int64(totalSeenByBoth)))
+		if a.tooFewShared(res) {
+			out += fmt.Sprintf("%s vs %s: skipped, only %s txs seen by both (minimum: %s)\n", comp.src, comp.ref, prettyInt(res.totalSeenByBoth), prettyInt(a.opts.MinSharedTxs))
+			continue
+		}
+		out += fmt.Sprintf("%s transactions received before %s: %s / %s (%s)\n", comp.src, comp.ref, prettyInt(res.totalFirstBySrc), prettyInt(res.totalSeenByBoth), common.Int64DiffPercentFmt(int64(res.totalFirstBySrc), int64(res.totalSeenByBoth)))
 		for _, bucketMS := range bucketsMS {
 			s := fmt.Sprintf("%d ms", bucketMS)
-			cnt := srcFirstBuckets[bucketMS]
-			out += fmt.Sprintf("- %-8s %10s   (%7s) \n", s, prettyInt64(cnt), common.Int64DiffPercentFmt(cnt, int64(totalFirstBySrc)))
+			cnt := res.srcFirstBuckets[bucketMS]
+			out += fmt.Sprintf("- %-8s %10s   (%7s) \n", s, prettyInt64(cnt), common.Int64DiffPercentFmt(cnt, int64(res.totalFirstBySrc)))
+		}
+		out += fmt.Sprintf("%s transactions received before %s: %s / %s (%s)\n", comp.ref, comp.src, prettyInt(res.totalFirstByRef), prettyInt(res.totalSeenByBoth), common.Int64DiffPercentFmt(int64(res.totalFirstByRef), int64(res.totalSeenByBoth)))
+		out += fmt.Sprintf("Equal timestamps: %s / %s (%s, tie policy: %s)\n", prettyInt(res.totalEqual), prettyInt(res.totalSeenByBoth), common.Int64DiffPercentFmt(int64(res.totalEqual), int64(res.totalSeenByBoth)), a.opts.TiePolicy)
+
+		if len(res.deltas) > 0 {
+			out += a.sprintLatencyPercentiles(comp.src, comp.ref, res.deltas)
 		}
 	}
+	for _, src := range a.onchainComps {
+		out += fmt.Sprintln("")
+		out += a.sprintOnchainComparison(src)
+	}
+
+	return out
+}
+
+// sprintLatencyPercentiles renders percentiles of the latency deltas, with bootstrap confidence intervals if enabled
+func (a *Analyzer) sprintLatencyPercentiles(src, ref string, deltas []int64) string {
+	all := sortedCopy(deltas)
+	sorted := trimmed(all, a.opts.TrimFraction)
+	var cis []confidenceInterval
+	if a.opts.BootstrapSamples > 0 {
+		cis = bootstrapPercentileCIs(sorted, latencyPercentiles, a.opts.BootstrapSamples)
+	}
 
+	out := fmt.Sprintf("Latency percentiles (%s - %s, ms, positive = %s first):\n", ref, src, src)
+	if a.opts.TrimFraction > 0 {
+		out = fmt.Sprintf("Latency percentiles (%s - %s, ms, positive = %s first, lowest and highest %.1f%% trimmed):\n", ref, src, src, a.opts.TrimFraction*100)
+	}
+	for i, p := range latencyPercentiles {
+		s := fmt.Sprintf("p%.0f", p)
+		out += fmt.Sprintf("- %-8s %10s", s, prettyMS(percentile(sorted, p)))
+		if cis != nil {
+			out += fmt.Sprintf("   (95%% CI: %s .. %s)", prettyMS(cis[i].Low), prettyMS(cis[i].High))
+		}
+		out += "\n"
+	}
+	// the MAD is robust to outliers by itself, and trimming would understate the spread
+	out += fmt.Sprintf("- %-8s %10s", "MAD", prettyMS(medianAbsoluteDeviation(all)))
+	if a.opts.TrimFraction > 0 {
+		out += "   (untrimmed)"
+	}
+	out += "\n"
 	return out
 }
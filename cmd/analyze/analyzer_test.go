@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiePolicy(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100, "b": 100}, // equal
+		"0x02": {"a": 100, "b": 105}, // a first
+		"0x03": {"a": 110, "b": 105}, // b first
+	}
+
+	for _, tt := range []struct {
+		tiePolicy       string
+		totalFirstBySrc int
+		totalFirstByRef int
+	}{
+		{TiePolicyEqual, 1, 1},
+		{TiePolicySrc, 2, 1},
+		{TiePolicyRef, 1, 2},
+	} {
+		a := NewAnalyzer(AnalyzerOpts{Transactions: txs, TiePolicy: tt.tiePolicy, SourceComps: []sourceComp{{"a", "b"}}}) //nolint:exhaustruct
+		res := a.benchmarkSourceVsLocal("a", "b")
+		require.Equal(t, 3, res.totalSeenByBoth, tt.tiePolicy)
+		require.Equal(t, 1, res.totalEqual, tt.tiePolicy)
+		require.Equal(t, tt.totalFirstBySrc, res.totalFirstBySrc, tt.tiePolicy)
+		require.Equal(t, tt.totalFirstByRef, res.totalFirstByRef, tt.tiePolicy)
+		require.Contains(t, a.Sprint(), fmt.Sprintf("b transactions received before a: %d / 3", tt.totalFirstByRef), tt.tiePolicy)
+		require.ElementsMatch(t, []int64{0, 5, -5}, res.deltas)
+	}
+}
+
+func TestSubMillisecondLatencies(t *testing.T) {
+	// µs timestamps, a is first by less than a millisecond (ms timestamps would be equal)
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 1_000_250},
+		"0x02": {"a": 2_000_000, "b": 2_000_750},
+		"0x03": {"a": 3_000_000, "b": 3_001_500},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, SourceComps: []sourceComp{{"a", "b"}}}) //nolint:exhaustruct
+	res := a.benchmarkSourceVsLocal("a", "b")
+	require.Equal(t, 0, res.totalEqual)
+	require.Equal(t, 3, res.totalFirstBySrc)
+	require.Equal(t, int64(1), res.srcFirstBuckets[1])
+	require.ElementsMatch(t, []int64{250, 750, 1_500}, res.deltas)
+	require.Equal(t, map[string]int64{"a": 1}, a.addedValue(1))
+
+	out := a.Sprint()
+	require.Contains(t, out, fmt.Sprintf("- %-8s %10s\n", "p50", "0.750"))
+	require.Equal(t, 0.75, a.Report().Comparisons[0].PercentilesMS["p50"])
+}
+
+func TestValidateSourceComps(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100, "b": 105},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, SourceComps: []sourceComp{{"a", "b"}, {"a", "a"}, {"a", "c"}}}) //nolint:exhaustruct
+	require.Equal(t, []sourceComp{{"a", "b"}}, a.comps)
+	require.Equal(t, []string{"a vs a (same source)", "a vs c (c not in the data)"}, a.compsSkip)
+	require.Contains(t, a.Sprint(), "Skipped comparisons: a vs a (same source), a vs c (c not in the data)")
+}
+
+func TestCoverageDiff(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100, "b": 105}, // both
+		"0x02": {"a": 100, "c": 105}, // only a
+		"0x03": {"a": 100},           // only a
+		"0x04": {"b": 100},           // only b
+		"0x05": {"c": 100},           // neither
+		"0x06": {"a": 100},           // only a, but blacklisted
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, TxBlacklist: map[string]bool{"0x06": true}}) //nolint:exhaustruct
+	res := a.benchmarkSourceVsLocal("a", "b")
+	require.Equal(t, 1, res.totalSeenByBoth)
+	require.Equal(t, 2, res.onlyBySrc)
+	require.Equal(t, 1, res.onlyByRef)
+}
+
+func TestCandidate(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100_000, "b": 300_000, "c": 150_000}, // a first by 50 ms over the rest
+		"0x02": {"a": 300_000, "b": 100_000},               // a last by 200 ms
+		"0x03": {"a": 100_000},                             // exclusive to a
+		"0x04": {"b": 100_000, "c": 100_000},               // not seen by a
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	res := a.benchmarkCandidate("a")
+	require.Equal(t, 3, res.seen)
+	require.Equal(t, 3, res.seenRest)
+	require.Equal(t, 1, res.exclusive)
+	require.Equal(t, 2, res.shared)
+	require.Equal(t, 1, res.firstWins)
+	require.Equal(t, 1, res.restWins)
+	require.ElementsMatch(t, []int64{50_000, -200_000}, res.deltas)
+}
+
+func TestAddedValue(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100_000, "b": 300_000, "c": 150_000}, // a first by 50 ms
+		"0x02": {"a": 300_000, "b": 100_000},               // b first by 200 ms
+		"0x03": {"a": 100_000, "b": 100_000},               // equal
+		"0x04": {"c": 100_000},                             // exclusive, not counted
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	require.Equal(t, map[string]int64{"a": 1, "b": 1}, a.addedValue(0))
+	require.Equal(t, map[string]int64{"b": 1}, a.addedValue(100))
+}
+
+func TestSprintSummaryDiff(t *testing.T) {
+	prev := Summary{
+		UniqueTxs:    100,
+		TxsPerSource: map[string]int64{"local": 90, "old": 10},
+		FirstWinPct:  map[string]float64{"bloxroute vs local": 50},
+	}
+	cur := Summary{
+		UniqueTxs:    120,
+		TxsPerSource: map[string]int64{"local": 100, "new": 20},
+		FirstWinPct:  map[string]float64{"bloxroute vs local": 60, "chainbound vs local": 40},
+	}
+
+	out := SprintSummaryDiff(prev, cur)
+	require.Contains(t, out, "Unique transactions: 100 -> 120 (+20)")
+	require.Contains(t, out, "(+10)")
+	require.Contains(t, out, "(new)")
+	require.Contains(t, out, "(gone)")
+	require.Contains(t, out, "50.00% ->  60.00% (+10.00)")
+	require.Contains(t, out, "->  40.00%")
+}
+
+func TestNeverFirstSources(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100, "b": 300, "c": 300},
+		"0x02": {"a": 100, "b": 100, "c": 200}, // tie, no winner
+		"0x03": {"d": 100},                     // exclusive
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	require.Equal(t, map[string]int64{"a": 1}, a.nFirstWinsPerSource)
+	require.Equal(t, []string{"b", "c"}, a.neverFirstSources())
+}
+
+func TestRates(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 60_000_000, "b": 60_500_000},
+		"0x02": {"a": 120_100_000},
+		"0x03": {"b": 119_000_000, "a": 121_000_000}, // first seen in minute 1
+		"0x04": {"a": 180_000_000},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	minute, cnt := a.peakMinute()
+	require.Equal(t, int64(1), minute)
+	require.Equal(t, int64(2), cnt)
+	require.Contains(t, a.Sprint(), "Average rate:        0.03 tx/sec")
+}
+
+func TestPropagationSpreads(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100_000, "b": 300_000, "c": 150_000},
+		"0x02": {"a": 300_000, "b": 290_000},
+		"0x03": {"c": 100_000}, // single source, no spread
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	require.ElementsMatch(t, []int64{200_000, 10_000}, a.propagationSpreads())
+	require.Contains(t, a.Sprint(), "Propagation spread")
+}
+
+func TestCoverageCSV(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 61_000_000},
+		"0x02": {"a": 125_000_000},
+		"0x03": {"b": 30_000_000},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	expected := "timestamp_ms,a,b,total\n" +
+		"60000,1,1,2\n" +
+		"120000,1,2,2\n" +
+		"180000,2,2,3\n"
+	require.Equal(t, expected, a.CoverageCSV(time.Minute))
+}
+
+func TestTimeToCoverage(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 61_000_000},
+		"0x02": {"a": 125_000_000},
+		"0x03": {"b": 30_000_000},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, CoverageTimes: []float64{30, 50, 90}, CoverageBucket: time.Minute}) //nolint:exhaustruct
+	require.Equal(t, map[string][]int64{
+		"a": {59_000_000, 179_000_000, -1},
+		"b": {59_000_000, 119_000_000, -1},
+	}, a.timeToCoverage(time.Minute, []float64{30, 50, 90}))
+	require.Contains(t, a.Sprint(), "- b                 59s      1m59s          -")
+}
+
+func TestMinSharedTxs(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"bloxroute": 100, "local": 110},
+		"0x02": {"bloxroute": 100, "local": 90},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, MinSharedTxs: 3}) //nolint:exhaustruct
+	require.Contains(t, a.Sprint(), "bloxroute vs local: skipped, only 2 txs seen by both (minimum: 3)")
+	require.Empty(t, a.Summary().FirstWinPct)
+
+	a = NewAnalyzer(AnalyzerOpts{Transactions: txs, MinSharedTxs: 2}) //nolint:exhaustruct
+	require.Contains(t, a.Sprint(), "bloxroute transactions received before local: 1 / 2")
+}
+
+func TestSprintHTML(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"local": 1693785600337, "bloxroute": 1693785600300},
+		"0x02": {"<script>": 1693785600400},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	html, err := a.SprintHTML()
+	require.NoError(t, err)
+	require.Contains(t, html, "<h3>bloxroute vs local</h3>")
+	require.Contains(t, html, "&lt;script&gt;")
+	require.NotContains(t, html, "<script>")
+
+	// the optional sections of the text report are rendered too
+	txs = map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 1_000_250}, // included at 5s
+		"0x02": {"a": 2_000_000, "b": 1_999_000},
+	}
+	a = NewAnalyzer(AnalyzerOpts{ //nolint:exhaustruct
+		Transactions:     txs,
+		InclusionTimes:   map[string]int64{"0x01": 5_000},
+		SourceComps:      []sourceComp{{"a", "b"}, {"a", "c"}, {"a", "onchain"}},
+		BootstrapSamples: 10,
+		CoverageTarget:   99,
+		CoverageTimes:    []float64{50},
+		CoverageBucket:   time.Second,
+		Candidate:        "a",
+	})
+	html, err = a.SprintHTML()
+	require.NoError(t, err)
+	for _, section := range []string{
+		"<h3>Propagation spread</h3>",
+		"<h3>Source redundancy</h3>",
+		"<h3>Time to coverage</h3>",
+		"<h2>Mempool dwell time</h2>",
+		"<h2>Candidate source: a</h2>",
+		"<p class=\"warning\">Skipped comparisons: a vs c (c not in the data)</p>",
+		"<th>95% CI</th>",
+		"<td class=\"num\">0.250</td>",
+		"<h3>a vs onchain</h3>",
+		"<p>Included txs seen by a: 1, before the inclusion block timestamp: 1 (100.00%)</p>",
+	} {
+		require.Contains(t, html, section)
+	}
+}
+
+func TestTxWhitelistBlacklist(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 100, "b": 110},
+		"0x02": {"a": 100, "b": 90},
+		"0x03": {"a": 100},
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, TxBlacklist: map[string]bool{"0x02": true}}) //nolint:exhaustruct
+	require.Equal(t, 2, a.nUniqueTx)
+	require.Equal(t, 1, a.benchmarkSourceVsLocal("a", "b").totalSeenByBoth)
+
+	a = NewAnalyzer(AnalyzerOpts{Transactions: txs, TxWhitelist: map[string]bool{"0x01": true, "0x02": true}}) //nolint:exhaustruct
+	require.Equal(t, 2, a.nUniqueTx)
+	require.Equal(t, 2, a.benchmarkSourceVsLocal("a", "b").totalSeenByBoth)
+
+	// the blacklist applies also to whitelisted txs
+	a = NewAnalyzer(AnalyzerOpts{Transactions: txs, TxWhitelist: map[string]bool{"0x01": true, "0x02": true}, TxBlacklist: map[string]bool{"0x02": true}}) //nolint:exhaustruct
+	require.Equal(t, 1, a.nUniqueTx)
+}
+
+func TestClockOffsets(t *testing.T) {
+	// b is always 1s after a, c is 10 ms before a for half of the txs and 10 ms after for the other half
+	txs := make(map[string]map[string]int64)
+	for i := 0; i < clockDriftMinSharedTxs; i++ {
+		ts := int64(1_000_000_000 + i*1000)
+		jitter := int64(10_000)
+		if i%2 == 0 {
+			jitter = -10_000
+		}
+		txs[fmt.Sprintf("0x%02x", i)] = map[string]int64{"a": ts, "b": ts + 1_000_000, "c": ts + jitter}
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, ClockDriftWarnMS: 250}) //nolint:exhaustruct
+	offsets := a.clockOffsets(250)
+	require.Equal(t, []clockOffset{
+		{"a", "b", 1_000_000, clockDriftMinSharedTxs},
+		{"b", "c", -1_010_000, clockDriftMinSharedTxs},
+	}, offsets)
+	require.Contains(t, a.Sprint(), "Warning: possible clock drift, median timestamp offset of a vs b is 1,000 ms (100 txs seen by both)")
+}
+
+func TestWriteLatenciesCSV(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000, "b": 1_050},
+		"0x02": {"a": 900, "c": 800},
+		"0x03": {"b": 500}, // single source, not written
+	}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	var out strings.Builder
+	require.NoError(t, a.WriteLatenciesCSV(&out))
+	expected := "hash,a,b,c\n" +
+		"0x02,900,,800\n" +
+		"0x01,1000,1050,\n"
+	require.Equal(t, expected, out.String())
+}
+
+func TestDwellTimes(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 3_000_000}, // included at 5s
+		"0x02": {"a": 8_000_000, "b": 6_000_000}, // included at 7s, a saw it only after the inclusion
+		"0x03": {"a": 9_000_000},                 // first seen after the inclusion at 8s
+		"0x04": {"b": 1_000_000},                 // not included
+	}
+	inclusionTimes := map[string]int64{"0x01": 5_000, "0x02": 7_000, "0x03": 8_000}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, InclusionTimes: inclusionTimes, DwellPerSource: true}) //nolint:exhaustruct
+	res := a.dwellTimes()
+	require.ElementsMatch(t, []int64{4_000_000, 1_000_000}, res.all)
+	require.ElementsMatch(t, []int64{4_000_000}, res.perSource["a"])
+	require.ElementsMatch(t, []int64{2_000_000, 1_000_000}, res.perSource["b"])
+	require.Equal(t, 1, res.cntSeenAfter)
+	require.Equal(t, 1, res.cntNotIncluded)
+	require.Contains(t, a.Sprint(), "Dwell time since sighting by b (2 txs, ms):")
+}
+
+func TestBlockCoverage(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 3_000_000}, // block at 5s
+		"0x02": {"a": 8_000_000, "b": 4_000_000}, // block at 5s, a saw it only after the inclusion
+		"0x03": {"b": 1_000_000},                 // block at 17s
+		"0x04": {"a": 1_000_000},                 // not included
+	}
+	inclusionTimes := map[string]int64{"0x01": 5_000, "0x02": 5_000, "0x03": 17_000, "0x05": 17_000} // 0x05 not seen by any source
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, InclusionTimes: inclusionTimes}) //nolint:exhaustruct
+	blocks := a.blockCoverages()
+	require.Len(t, blocks, 2)
+	require.Equal(t, blockCoverage{timestampMS: 5_000, txs: 2, seenBefore: map[string]int{"a": 1, "b": 2}, seenByAny: 2}, *blocks[0])
+	require.Equal(t, blockCoverage{timestampMS: 17_000, txs: 2, seenBefore: map[string]int{"b": 1}, seenByAny: 1}, *blocks[1])
+	require.Equal(t, "block_timestamp_ms,txs,a,b,any\n5000,2,1,2,2\n17000,2,0,1,1\n", a.BlockCoverageCSV())
+	require.Contains(t, a.Sprint(), "Blocks: 2 (4 included txs), seen by any source before the inclusion: 3 (75.00%)")
+}
+
+func TestOnchainComparison(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1_000_000, "b": 3_000_000}, // included at 5s
+		"0x02": {"a": 8_000_000, "b": 6_000_000}, // included at 7s, a saw it only after the inclusion
+		"0x03": {"b": 1_000_000},                 // not included
+	}
+	inclusionTimes := map[string]int64{"0x01": 5_000, "0x02": 7_000}
+
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs, InclusionTimes: inclusionTimes, SourceComps: []sourceComp{{"a", "onchain"}, {"c", "onchain"}}}) //nolint:exhaustruct
+	require.Equal(t, []string{"a"}, a.onchainComps)
+	require.Equal(t, []string{"c vs onchain (c not in the data)"}, a.compsSkip)
+	res := a.benchmarkSourceVsOnchain("a")
+	require.Equal(t, 2, res.included)
+	require.Equal(t, 1, res.seenBefore)
+	require.ElementsMatch(t, []int64{4_000_000, -1_000_000}, res.deltas)
+	require.Contains(t, a.Sprint(), "Included txs seen by a: 2, before the inclusion block timestamp: 1 (50.00%)")
+
+	// without inclusion times, the comparison is skipped
+	a = NewAnalyzer(AnalyzerOpts{Transactions: txs, SourceComps: []sourceComp{{"a", "onchain"}}}) //nolint:exhaustruct
+	require.Empty(t, a.onchainComps)
+	require.Equal(t, []string{"a vs onchain (no inclusion times)"}, a.compsSkip)
+}
+
+func TestGreedySourceCover(t *testing.T) {
+	txs := map[string]map[string]int64{
+		"0x01": {"a": 1, "b": 1, "c": 1},
+		"0x02": {"a": 1, "b": 1},
+		"0x03": {"b": 1, "c": 1},
+		"0x04": {"c": 1},
+		"0x05": {"d": 1},
+	}
+
+	// b and c see 3 txs each (b wins the tie by name), then c and d add 1 each (c wins), a adds nothing and is never selected
+	a := NewAnalyzer(AnalyzerOpts{Transactions: txs}) //nolint:exhaustruct
+	require.Equal(t, []coverageStep{
+		{"b", 3, 3},
+		{"c", 1, 4},
+		{"d", 1, 5},
+	}, a.greedySourceCover())
+	require.Contains(t, a.sprintSourceRedundancy(80), "Sources covering 80.0% of the unique txs: b, c \n")
+}
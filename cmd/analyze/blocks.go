@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flashbots/mempool-dumpster/common"
+)
+
+// blockCoverage is the mempool coverage of the txs of an included block: how many of them each source saw before the
+// inclusion. Blocks are identified by their timestamp (one block per slot), as the inclusion times have no block number.
+type blockCoverage struct {
+	timestampMS int64
+	txs         int            // included txs of the block (from the inclusion times, also those not seen by any source)
+	seenBefore  map[string]int // [src] = txs of the block seen by the source before the inclusion block timestamp
+	seenByAny   int            // txs of the block seen by any source before the inclusion block timestamp
+}
+
+// blockCoverages groups the included txs by block, sorted by the block timestamp
+func (a *Analyzer) blockCoverages() []*blockCoverage {
+	blocks := make(map[int64]*blockCoverage)
+	getBlock := func(timestampMS int64) *blockCoverage {
+		block, ok := blocks[timestampMS]
+		if !ok {
+			block = &blockCoverage{timestampMS: timestampMS, seenBefore: make(map[string]int)} //nolint:exhaustruct
+			blocks[timestampMS] = block
+		}
+		return block
+	}
+
+	for txHashLower, inclusionMS := range a.opts.InclusionTimes {
+		if !a.skipTx(txHashLower) {
+			getBlock(inclusionMS).txs += 1
+		}
+	}
+
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) {
+			continue
+		}
+
+		inclusionMS, ok := a.opts.InclusionTimes[txHashLower]
+		if !ok {
+			continue
+		}
+
+		block := getBlock(inclusionMS)
+		seenByAny := false
+		for src, ts := range sources {
+			if ts <= inclusionMS*1000 {
+				block.seenBefore[src] += 1
+				seenByAny = true
+			}
+		}
+		if seenByAny {
+			block.seenByAny += 1
+		}
+	}
+
+	res := make([]*blockCoverage, 0, len(blocks))
+	for _, block := range blocks {
+		res = append(res, block)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].timestampMS < res[j].timestampMS })
+	return res
+}
+
+// sprintBlockCoverage renders the number of blocks and included txs, and per source the median share of the txs of a
+// block it saw before the inclusion
+func (a *Analyzer) sprintBlockCoverage() string {
+	blocks := a.blockCoverages()
+	cntTxs, cntSeen := 0, 0
+	for _, block := range blocks {
+		cntTxs += block.txs
+		cntSeen += block.seenByAny
+	}
+	out := fmt.Sprintf("Blocks: %s (%s included txs), seen by any source before the inclusion: %s (%s) \n", prettyInt(len(blocks)), prettyInt(cntTxs), prettyInt(cntSeen), common.Int64DiffPercentFmt(int64(cntSeen), int64(cntTxs)))
+	if len(blocks) == 0 {
+		return out
+	}
+
+	// coverage of each block in basis points, for the median over the blocks
+	perSource := make(map[string][]int64)
+	byAny := make([]int64, 0, len(blocks))
+	for _, block := range blocks {
+		for _, src := range a.sources {
+			perSource[src] = append(perSource[src], int64(block.seenBefore[src]*10_000/block.txs))
+		}
+		byAny = append(byAny, int64(block.seenByAny*10_000/block.txs))
+	}
+
+	out += fmt.Sprintln("")
+	out += "Median coverage per block (txs of the block seen before the inclusion): \n"
+	for _, src := range a.sources {
+		out += fmt.Sprintf("- %-10s %10s\n", src, printer.Sprintf("%.2f%%", float64(percentile(sortedCopy(perSource[src]), 50))/100))
+	}
+	out += fmt.Sprintf("- %-10s %10s\n", "any", printer.Sprintf("%.2f%%", float64(percentile(sortedCopy(byAny), 50))/100))
+	return out
+}
+
+// BlockCoverageCSV returns the mempool coverage of each included block: the number of included txs, and how many of
+// them each source, and any source, saw before the inclusion.
+// Columns: block_timestamp_ms,txs,<sources...>,any
+func (a *Analyzer) BlockCoverageCSV() string {
+	out := fmt.Sprintf("block_timestamp_ms,txs,%s,any\n", strings.Join(a.sources, ","))
+	for _, block := range a.blockCoverages() {
+		row := []string{fmt.Sprint(block.timestampMS), fmt.Sprint(block.txs)}
+		for _, src := range a.sources {
+			row = append(row, fmt.Sprint(block.seenBefore[src]))
+		}
+		row = append(row, fmt.Sprint(block.seenByAny))
+		out += strings.Join(row, ",") + "\n"
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/flashbots/mempool-dumpster/common"
+)
+
+// candidateResult is the marginal value of a candidate source over all other sources together (the rest), i.e. to
+// decide whether to keep a new feed after a trial
+type candidateResult struct {
+	seen      int     // unique txs seen by the candidate
+	exclusive int     // txs seen only by the candidate, which the rest would miss
+	seenRest  int     // unique txs seen by the rest
+	shared    int     // txs seen by the candidate and the rest
+	firstWins int     // shared txs received by the candidate before the earliest of the rest (equal timestamps by tie policy)
+	restWins  int     // shared txs received by the rest before the candidate (equal timestamps by tie policy)
+	deltas    []int64 // earliest timestamp of the rest minus the candidate timestamp (µs) for each shared tx
+}
+
+// benchmarkCandidate compares a candidate source with the best of all other sources, for every tx
+func (a *Analyzer) benchmarkCandidate(candidate string) *candidateResult {
+	res := &candidateResult{} //nolint:exhaustruct
+	for txHash, sources := range a.txs {
+		if a.skipTx(strings.ToLower(txHash)) {
+			continue
+		}
+
+		candidateTS, seenByCandidate := sources[candidate]
+		restTS := int64(-1)
+		for src, ts := range sources {
+			if src != candidate && (restTS == -1 || ts < restTS) {
+				restTS = ts
+			}
+		}
+
+		if seenByCandidate {
+			res.seen += 1
+		}
+		if restTS != -1 {
+			res.seenRest += 1
+		}
+		if !seenByCandidate || restTS == -1 {
+			if seenByCandidate {
+				res.exclusive += 1
+			}
+			continue
+		}
+
+		res.shared += 1
+		diff := restTS - candidateTS
+		res.deltas = append(res.deltas, diff)
+		if srcWins(diff, a.opts.TiePolicy) {
+			res.firstWins += 1
+		}
+		if refWins(diff, a.opts.TiePolicy) {
+			res.restWins += 1
+		}
+	}
+	return res
+}
+
+// sprintCandidate renders the marginal coverage and the latency of the candidate source vs the best of the rest
+func (a *Analyzer) sprintCandidate(candidate string) string {
+	if !slices.Contains(a.sources, candidate) {
+		return fmt.Sprintf("Candidate %s: not in the data \n", candidate)
+	}
+
+	res := a.benchmarkCandidate(candidate)
+	out := fmt.Sprintf("Seen by %s: %s / %s (%s) \n", candidate, prettyInt(res.seen), prettyInt(a.nUniqueTx), common.Int64DiffPercentFmt(int64(res.seen), int64(a.nUniqueTx)))
+	out += fmt.Sprintf("Seen by the rest: %s / %s (%s) \n", prettyInt(res.seenRest), prettyInt(a.nUniqueTx), common.Int64DiffPercentFmt(int64(res.seenRest), int64(a.nUniqueTx)))
+	out += fmt.Sprintf("Added coverage: %s (+%s over the rest) \n", prettyInt(res.exclusive), common.Int64DiffPercentFmt(int64(res.exclusive), int64(res.seenRest)))
+	out += fmt.Sprintf("First vs the earliest of the rest: %s / %s (%s, tie policy: %s) \n", prettyInt(res.firstWins), prettyInt(res.shared), common.Int64DiffPercentFmt(int64(res.firstWins), int64(res.shared)), a.opts.TiePolicy)
+	out += fmt.Sprintf("Rest before %s: %s / %s (%s) \n", candidate, prettyInt(res.restWins), prettyInt(res.shared), common.Int64DiffPercentFmt(int64(res.restWins), int64(res.shared)))
+	if len(res.deltas) > 0 {
+		out += a.sprintLatencyPercentiles(candidate, "rest", res.deltas)
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CoverageCSV returns the growth of the coverage of each source over the collection period: the cumulative number of
+// txs seen by each source, and of unique txs seen by any source ("total"), at the end of each time bucket.
+// Columns: timestamp_ms (end of the bucket),<sources...>,total
+func (a *Analyzer) CoverageCSV(bucket time.Duration) string {
+	out := fmt.Sprintf("timestamp_ms,%s,total\n", strings.Join(a.sources, ","))
+	bucketUS := bucket.Microseconds()
+	if bucketUS <= 0 || a.nUniqueTx == 0 {
+		return out
+	}
+
+	cntPerSource, cntTotal := a.coverageCounts(bucketUS)
+
+	// accumulate over all buckets of the period, including empty ones
+	cumPerSource := make(map[string]int64)
+	cumTotal := int64(0)
+	for b := a.timestampFirst / bucketUS; b <= a.timestampLast/bucketUS; b++ {
+		row := []string{fmt.Sprint((b + 1) * bucketUS / 1000)}
+		for _, src := range a.sources {
+			cumPerSource[src] += cntPerSource[src][b]
+			row = append(row, fmt.Sprint(cumPerSource[src]))
+		}
+		cumTotal += cntTotal[b]
+		row = append(row, fmt.Sprint(cumTotal))
+		out += strings.Join(row, ",") + "\n"
+	}
+	return out
+}
+
+// coverageCounts returns the number of txs seen by each source per time bucket ([src][bucket]), and of txs first seen
+// by any source ([bucket]), with bucket = timestamp / bucketUS
+func (a *Analyzer) coverageCounts(bucketUS int64) (cntPerSource map[string]map[int64]int64, cntTotal map[int64]int64) {
+	cntPerSource = make(map[string]map[int64]int64)
+	cntTotal = make(map[int64]int64)
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) {
+			continue
+		}
+
+		firstTS := int64(0)
+		for src, ts := range sources {
+			if cntPerSource[src] == nil {
+				cntPerSource[src] = make(map[int64]int64)
+			}
+			cntPerSource[src][ts/bucketUS] += 1
+			if firstTS == 0 || ts < firstTS {
+				firstTS = ts
+			}
+		}
+		cntTotal[firstTS/bucketUS] += 1
+	}
+	return cntPerSource, cntTotal
+}
+
+// timeToCoverage returns for each source the time since the start of the collection period (end of the first time
+// bucket in which the cumulative coverage reached the threshold, in µs) at which it had seen each threshold percentage
+// of the unique txs of the whole period (-1 = never). A source which is fast early but plateaus reaches 50% early and
+// 90% late or never, a steady one in proportion to the time.
+func (a *Analyzer) timeToCoverage(bucket time.Duration, thresholds []float64) map[string][]int64 {
+	res := make(map[string][]int64)
+	bucketUS := bucket.Microseconds()
+	if bucketUS <= 0 || a.nUniqueTx == 0 {
+		return res
+	}
+
+	cntPerSource, _ := a.coverageCounts(bucketUS)
+	for _, src := range a.sources {
+		times := make([]int64, len(thresholds))
+		for i := range times {
+			times[i] = -1
+		}
+
+		cum := int64(0)
+		for b := a.timestampFirst / bucketUS; b <= a.timestampLast/bucketUS; b++ {
+			cum += cntPerSource[src][b]
+			for i, threshold := range thresholds {
+				if times[i] == -1 && float64(cum) >= threshold/100*float64(a.nUniqueTx) {
+					times[i] = (b+1)*bucketUS - a.timestampFirst
+				}
+			}
+		}
+		res[src] = times
+	}
+	return res
+}
+
+// sprintTimeToCoverage renders the time to coverage of each source as table, one column per threshold
+func (a *Analyzer) sprintTimeToCoverage(bucket time.Duration, thresholds []float64) string {
+	out := fmt.Sprintf("Time to coverage (since the first tx, of %s unique txs, in %s buckets): \n", prettyInt(a.nUniqueTx), bucket)
+	out += fmt.Sprintf("  %-10s", "")
+	for _, threshold := range thresholds {
+		out += fmt.Sprintf(" %10s", fmt.Sprintf("%g%%", threshold))
+	}
+	out += "\n"
+
+	timeToCoverage := a.timeToCoverage(bucket, thresholds)
+	for _, src := range a.sources {
+		out += fmt.Sprintf("- %-10s", src)
+		for _, us := range timeToCoverage[src] {
+			s := "-"
+			if us >= 0 {
+				s = (time.Duration(us) * time.Microsecond).String()
+			}
+			out += fmt.Sprintf(" %10s", s)
+		}
+		out += "\n"
+	}
+	return out
+}
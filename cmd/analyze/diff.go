@@ -0,0 +1,92 @@
+package main
+
+// Comparison of the reports of two analyzer runs (i.e. two days), to spot drifts in source performance
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Summary holds the key figures of an analyzer report
+type Summary struct {
+	UniqueTxs    int
+	TxsPerSource map[string]int64   // [src] = number of txs received
+	FirstWinPct  map[string]float64 // ["src vs ref"] = percentage of txs seen by both, which src received first
+}
+
+// Summary returns the key figures of the analysis
+func (a *Analyzer) Summary() Summary {
+	s := Summary{
+		UniqueTxs:    a.nUniqueTx,
+		TxsPerSource: make(map[string]int64),
+		FirstWinPct:  make(map[string]float64),
+	}
+
+	for src, cnt := range a.nTransactionsPerSource {
+		s.TxsPerSource[src] = cnt
+	}
+
+	for _, comp := range a.comps {
+		res := a.benchmarkSourceVsLocal(comp.src, comp.ref)
+		if res.totalSeenByBoth == 0 || a.tooFewShared(res) {
+			continue
+		}
+		key := fmt.Sprintf("%s vs %s", comp.src, comp.ref)
+		s.FirstWinPct[key] = float64(res.totalFirstBySrc) * 100 / float64(res.totalSeenByBoth)
+	}
+
+	return s
+}
+
+// SprintSummaryDiff renders the changes from prev to cur. Sources and comparisons which are only present in one of
+// the summaries are shown with "-" for the missing value.
+func SprintSummaryDiff(prev, cur Summary) string {
+	out := fmt.Sprintf("Unique transactions: %s -> %s (%+d)\n", prettyInt(prev.UniqueTxs), prettyInt(cur.UniqueTxs), cur.UniqueTxs-prev.UniqueTxs)
+
+	out += fmt.Sprintln("")
+	out += "Transactions received: \n"
+	for _, src := range sortedKeys(prev.TxsPerSource, cur.TxsPerSource) {
+		prevCnt, prevOk := prev.TxsPerSource[src]
+		curCnt, curOk := cur.TxsPerSource[src]
+		switch {
+		case !prevOk:
+			out += fmt.Sprintf("- %-10s %12s -> %12s (new)\n", src, "-", prettyInt64(curCnt))
+		case !curOk:
+			out += fmt.Sprintf("- %-10s %12s -> %12s (gone)\n", src, prettyInt64(prevCnt), "-")
+		default:
+			out += fmt.Sprintf("- %-10s %12s -> %12s (%+d)\n", src, prettyInt64(prevCnt), prettyInt64(curCnt), curCnt-prevCnt)
+		}
+	}
+
+	out += fmt.Sprintln("")
+	out += "Received first (of txs seen by both): \n"
+	for _, key := range sortedKeys(prev.FirstWinPct, cur.FirstWinPct) {
+		prevPct, prevOk := prev.FirstWinPct[key]
+		curPct, curOk := cur.FirstWinPct[key]
+		switch {
+		case !prevOk:
+			out += fmt.Sprintf("- %-24s %7s -> %6.2f%%\n", key, "-", curPct)
+		case !curOk:
+			out += fmt.Sprintf("- %-24s %6.2f%% -> %7s\n", key, prevPct, "-")
+		default:
+			out += fmt.Sprintf("- %-24s %6.2f%% -> %6.2f%% (%+.2f)\n", key, prevPct, curPct, curPct-prevPct)
+		}
+	}
+
+	return out
+}
+
+// sortedKeys returns the union of the keys of both maps, sorted
+func sortedKeys[V any](a, b map[string]V) []string {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flashbots/mempool-dumpster/common"
+)
+
+// dwellTimes is the time txs spent in the mempool before they were included in a block
+type dwellTimes struct {
+	all       []int64            // inclusion time - earliest sighting by any source (µs)
+	perSource map[string][]int64 // [src] = inclusion time - sighting by src (µs)
+
+	cntNotIncluded int // txs without a known inclusion time
+	cntSeenAfter   int // txs first seen only after the inclusion block timestamp (i.e. late sources, or private orderflow)
+}
+
+// dwellTimes computes the mempool dwell time of all txs with a known inclusion time. Block timestamps have a
+// resolution of seconds, so the values are only accurate to about a second. Txs first seen after their inclusion are
+// counted, but not included in the distribution.
+func (a *Analyzer) dwellTimes() *dwellTimes {
+	res := &dwellTimes{ //nolint:exhaustruct
+		all:       make([]int64, 0),
+		perSource: make(map[string][]int64),
+	}
+
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) {
+			continue
+		}
+
+		inclusionMS, ok := a.opts.InclusionTimes[txHashLower]
+		if !ok {
+			res.cntNotIncluded += 1
+			continue
+		}
+		inclusionTS := inclusionMS * 1000
+
+		firstTS := int64(0)
+		for src, ts := range sources {
+			if firstTS == 0 || ts < firstTS {
+				firstTS = ts
+			}
+			if ts <= inclusionTS {
+				res.perSource[src] = append(res.perSource[src], inclusionTS-ts)
+			}
+		}
+
+		if firstTS > inclusionTS {
+			res.cntSeenAfter += 1
+			continue
+		}
+		res.all = append(res.all, inclusionTS-firstTS)
+	}
+	return res
+}
+
+// sprintDwellTimes renders the percentiles of the mempool dwell time, optionally also for each source
+func (a *Analyzer) sprintDwellTimes() string {
+	res := a.dwellTimes()
+	cntTotal := len(res.all) + res.cntSeenAfter
+	out := fmt.Sprintf("Included txs: %s / %s (%s), first seen after inclusion: %s \n", prettyInt(cntTotal), prettyInt(cntTotal+res.cntNotIncluded), common.Int64DiffPercentFmt(int64(cntTotal), int64(cntTotal+res.cntNotIncluded)), prettyInt(res.cntSeenAfter))
+	if len(res.all) == 0 {
+		return out
+	}
+
+	out += fmt.Sprintln("")
+	out += "Dwell time (inclusion block timestamp - first sighting, ms): \n"
+	out += sprintDwellPercentiles(res.all)
+
+	if !a.opts.DwellPerSource {
+		return out
+	}
+	for _, src := range a.sources {
+		if len(res.perSource[src]) == 0 {
+			continue
+		}
+		out += fmt.Sprintln("")
+		out += fmt.Sprintf("Dwell time since sighting by %s (%s txs, ms): \n", src, prettyInt(len(res.perSource[src])))
+		out += sprintDwellPercentiles(res.perSource[src])
+	}
+	return out
+}
+
+func sprintDwellPercentiles(values []int64) string {
+	sorted := sortedCopy(values)
+	out := ""
+	for _, p := range latencyPercentiles {
+		s := fmt.Sprintf("p%.0f", p)
+		out += fmt.Sprintf("- %-8s %10s\n", s, prettyMS(percentile(sorted, p)))
+	}
+	return out
+}
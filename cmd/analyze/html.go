@@ -0,0 +1,268 @@
+package main
+
+// Self-contained HTML version of the analyzer report, with all sections of Sprint. The overall stats, the propagation
+// spread and the latency comparisons (with the confidence intervals, and the skipped comparisons) are rendered as
+// styled tables, as are the comparisons with the onchain reference. The redundancy, time to coverage, dwell time,
+// block coverage and candidate sections are included as preformatted text of the text report.
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/flashbots/mempool-dumpster/common"
+)
+
+type htmlReport struct {
+	From, To, Duration string
+	Sources            string
+	UniqueTxs          string
+	AvgRate, PeakRate  string
+	AddedValueMS       int64
+	SourceRows         []htmlSourceRow
+	NeverFirst         string
+	Spread             []htmlPercentile
+	ClockDrift         []string
+	Redundancy         string // preformatted sections of the text report (empty if disabled)
+	TimeToCoverage     string
+	Dwell              string
+	Candidate          string
+	CandidateTitle     string
+	LatencyHeader      string // unit, sign and trimming of the latency percentiles
+	Skipped            string
+	Comparisons        []htmlComparison
+	Onchain            []htmlOnchain
+}
+
+type htmlSourceRow struct {
+	Source, Received, Exclusive, NotSeenLocal, FirstWins, AddedValue string
+}
+
+type htmlComparison struct {
+	Title       string
+	Coverage    string // txs seen only by src, and only by ref
+	Skipped     string // set if there are too few txs seen by both sources
+	FirstBySrc  string
+	FirstByRef  string
+	Equal       string
+	Buckets     [][2]string // threshold, count (percent)
+	Percentiles []htmlPercentile
+	HasCIs      bool
+}
+
+type htmlPercentile struct {
+	Name, Value string // percentile, ms
+	CI          string // 95% confidence interval, if bootstrapping is enabled
+}
+
+type htmlOnchain struct {
+	Title       string
+	Summary     string
+	Percentiles []htmlPercentile
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Mempool Dumpster Report: {{.From}} - {{.To}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 960px; color: #222; }
+h1, h2, h3 { font-weight: 600; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; }
+th { background: #f4f4f4; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.warning { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Mempool Dumpster Report</h1>
+<table>
+<tr><th>From</th><td>{{.From}}</td></tr>
+<tr><th>To</th><td>{{.To}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Sources</th><td>{{.Sources}}</td></tr>
+<tr><th>Unique transactions</th><td class="num">{{.UniqueTxs}}</td></tr>
+{{if .AvgRate}}<tr><th>Average rate</th><td class="num">{{.AvgRate}}</td></tr>{{end}}
+{{if .PeakRate}}<tr><th>Peak rate</th><td class="num">{{.PeakRate}}</td></tr>{{end}}
+</table>
+
+<h2>Overall stats</h2>
+<table>
+<tr><th>Source</th><th>Received</th><th>Exclusive</th><th>Not seen by local</th><th>Received first</th><th>Added value (&gt; {{.AddedValueMS}} ms)</th></tr>
+{{range .SourceRows}}<tr><td>{{.Source}}</td><td class="num">{{.Received}}</td><td class="num">{{.Exclusive}}</td><td class="num">{{.NotSeenLocal}}</td><td class="num">{{.FirstWins}}</td><td class="num">{{.AddedValue}}</td></tr>
+{{end}}</table>
+{{if .NeverFirst}}<p class="warning">Sources which never received a tx first: {{.NeverFirst}}</p>{{end}}
+{{if .Spread}}<h3>Propagation spread</h3>
+<table>
+<tr><th>Percentile</th><th>Last - first sighting (ms)</th></tr>
+{{range .Spread}}<tr><td>{{.Name}}</td><td class="num">{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+{{range .ClockDrift}}<p class="warning">Warning: {{.}}</p>
+{{end}}
+{{if .Redundancy}}<h3>Source redundancy</h3>
+<pre>{{.Redundancy}}</pre>{{end}}
+{{if .TimeToCoverage}}<h3>Time to coverage</h3>
+<pre>{{.TimeToCoverage}}</pre>{{end}}
+{{if .Dwell}}<h2>Mempool dwell time</h2>
+<pre>{{.Dwell}}</pre>{{end}}
+{{if .CandidateTitle}}<h2>{{.CandidateTitle}}</h2>
+<pre>{{.Candidate}}</pre>{{end}}
+
+<h2>Latency comparison</h2>
+{{if .Skipped}}<p class="warning">Skipped comparisons: {{.Skipped}}</p>{{end}}
+{{$latencyHeader := .LatencyHeader}}{{range .Comparisons}}<h3>{{.Title}}</h3>
+<p>{{.Coverage}}</p>
+{{if .Skipped}}<p class="warning">{{.Skipped}}</p>{{else}}<p>Received first: {{.FirstBySrc}}<br>Received first by the reference: {{.FirstByRef}}<br>Equal timestamps: {{.Equal}}</p>
+<table>
+<tr><th>Ahead by at least</th><th>Transactions</th></tr>
+{{range .Buckets}}<tr><td>{{index . 0}}</td><td class="num">{{index . 1}}</td></tr>
+{{end}}</table>
+{{if .Percentiles}}<table>
+<tr><th>Percentile</th><th>{{$latencyHeader}}</th>{{if .HasCIs}}<th>95% CI</th>{{end}}</tr>
+{{$hasCIs := .HasCIs}}{{range .Percentiles}}<tr><td>{{.Name}}</td><td class="num">{{.Value}}</td>{{if $hasCIs}}<td class="num">{{.CI}}</td>{{end}}</tr>
+{{end}}</table>{{end}}{{end}}
+{{end}}
+{{range .Onchain}}<h3>{{.Title}}</h3>
+<p>{{.Summary}}</p>
+{{if .Percentiles}}<table>
+<tr><th>Percentile</th><th>Time before inclusion (ms)</th></tr>
+{{range .Percentiles}}<tr><td>{{.Name}}</td><td class="num">{{.Value}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// SprintHTML renders the report as a self-contained HTML page
+func (a *Analyzer) SprintHTML() (string, error) {
+	r := htmlReport{ //nolint:exhaustruct
+		From:          a.timeFirst.String(),
+		To:            a.timeLast.String(),
+		Duration:      a.duration.String(),
+		Sources:       strings.Join(a.sources, ", "),
+		UniqueTxs:     prettyInt(a.nUniqueTx),
+		AddedValueMS:  a.opts.AddedValueMS,
+		NeverFirst:    strings.Join(a.neverFirstSources(), ", "),
+		ClockDrift:    a.clockDriftWarnings(),
+		LatencyHeader: "Latency (ms, positive = first)",
+		Skipped:       strings.Join(a.compsSkip, ", "),
+	}
+	if a.opts.TrimFraction > 0 {
+		r.LatencyHeader = fmt.Sprintf("Latency (ms, positive = first, lowest and highest %.1f%% trimmed)", a.opts.TrimFraction*100)
+	}
+
+	if a.duration > 0 {
+		r.AvgRate = printer.Sprintf("%.2f tx/sec", float64(a.nUniqueTx)/a.duration.Seconds())
+	}
+	if peakMinute, peakCnt := a.peakMinute(); peakCnt > 0 {
+		r.PeakRate = fmt.Sprintf("%s tx/min (%s)", prettyInt64(peakCnt), time.Unix(peakMinute*60, 0).UTC().Format("2006-01-02 15:04"))
+	}
+
+	addedValue := a.addedValue(a.opts.AddedValueMS)
+	for _, src := range a.sources {
+		if a.nTransactionsPerSource[src] == 0 {
+			continue
+		}
+		notSeenLocal := "-"
+		if src != referenceLocalSource {
+			notSeenLocal = prettyInt64(a.nNotSeenLocalPerSource[src])
+		}
+		r.SourceRows = append(r.SourceRows, htmlSourceRow{
+			Source:       src,
+			Received:     prettyInt64(a.nTransactionsPerSource[src]),
+			Exclusive:    prettyInt64(a.nUniqueTxPerSource[src]),
+			NotSeenLocal: notSeenLocal,
+			FirstWins:    prettyInt64(a.nFirstWinsPerSource[src]),
+			AddedValue:   prettyInt64(addedValue[src]),
+		})
+	}
+
+	if spreads := a.propagationSpreads(); len(spreads) > 0 {
+		r.Spread = htmlPercentiles(sortedCopy(spreads), nil)
+	}
+	if a.opts.CoverageTarget > 0 && len(a.sources) > 1 {
+		r.Redundancy = a.sprintSourceRedundancy(a.opts.CoverageTarget)
+	}
+	if len(a.opts.CoverageTimes) > 0 {
+		r.TimeToCoverage = a.sprintTimeToCoverage(a.opts.CoverageBucket, a.opts.CoverageTimes)
+	}
+	if len(a.opts.InclusionTimes) > 0 {
+		r.Dwell = a.sprintDwellTimes() + "\n" + a.sprintBlockCoverage()
+	}
+	if a.opts.Candidate != "" {
+		r.CandidateTitle = "Candidate source: " + a.opts.Candidate
+		r.Candidate = a.sprintCandidate(a.opts.Candidate)
+	}
+
+	for _, comp := range a.comps {
+		res := a.benchmarkSourceVsLocal(comp.src, comp.ref)
+		coverage := fmt.Sprintf("Seen only by %s: %s, only by %s: %s", comp.src, prettyInt(res.onlyBySrc), comp.ref, prettyInt(res.onlyByRef))
+		if a.tooFewShared(res) {
+			r.Comparisons = append(r.Comparisons, htmlComparison{ //nolint:exhaustruct
+				Title:    fmt.Sprintf("%s vs %s", comp.src, comp.ref),
+				Coverage: coverage,
+				Skipped:  fmt.Sprintf("Skipped, only %s txs seen by both (minimum: %s)", prettyInt(res.totalSeenByBoth), prettyInt(a.opts.MinSharedTxs)),
+			})
+			continue
+		}
+		c := htmlComparison{ //nolint:exhaustruct
+			Title:      fmt.Sprintf("%s vs %s", comp.src, comp.ref),
+			Coverage:   coverage,
+			FirstBySrc: fmt.Sprintf("%s / %s (%s)", prettyInt(res.totalFirstBySrc), prettyInt(res.totalSeenByBoth), common.Int64DiffPercentFmt(int64(res.totalFirstBySrc), int64(res.totalSeenByBoth))),
+			FirstByRef: fmt.Sprintf("%s / %s (%s)", prettyInt(res.totalFirstByRef), prettyInt(res.totalSeenByBoth), common.Int64DiffPercentFmt(int64(res.totalFirstByRef), int64(res.totalSeenByBoth))),
+			Equal:      fmt.Sprintf("%s (tie policy: %s)", prettyInt(res.totalEqual), a.opts.TiePolicy),
+		}
+		for _, bucketMS := range bucketsMS {
+			cnt := res.srcFirstBuckets[bucketMS]
+			c.Buckets = append(c.Buckets, [2]string{fmt.Sprintf("%d ms", bucketMS), fmt.Sprintf("%s (%s)", prettyInt64(cnt), common.Int64DiffPercentFmt(cnt, int64(res.totalFirstBySrc)))})
+		}
+		if len(res.deltas) > 0 {
+			all := sortedCopy(res.deltas)
+			sorted := trimmed(all, a.opts.TrimFraction)
+			var cis []confidenceInterval
+			if a.opts.BootstrapSamples > 0 {
+				cis = bootstrapPercentileCIs(sorted, latencyPercentiles, a.opts.BootstrapSamples)
+				c.HasCIs = true
+			}
+			c.Percentiles = htmlPercentiles(sorted, cis)
+			c.Percentiles = append(c.Percentiles, htmlPercentile{Name: "MAD", Value: prettyMS(medianAbsoluteDeviation(all)), CI: "-"})
+		}
+		r.Comparisons = append(r.Comparisons, c)
+	}
+
+	for _, src := range a.onchainComps {
+		res := a.benchmarkSourceVsOnchain(src)
+		onchain := htmlOnchain{ //nolint:exhaustruct
+			Title:   fmt.Sprintf("%s vs %s", src, referenceOnchain),
+			Summary: res.summary(src),
+		}
+		if len(res.deltas) > 0 {
+			onchain.Percentiles = htmlPercentiles(sortedCopy(res.deltas), nil)
+		}
+		r.Onchain = append(r.Onchain, onchain)
+	}
+
+	var buf bytes.Buffer
+	if err := htmlReportTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// htmlPercentiles returns the latencyPercentiles of an ascending sorted slice of µs, with their confidence intervals
+// if set
+func htmlPercentiles(sorted []int64, cis []confidenceInterval) []htmlPercentile {
+	res := make([]htmlPercentile, 0, len(latencyPercentiles))
+	for i, p := range latencyPercentiles {
+		row := htmlPercentile{Name: fmt.Sprintf("p%.0f", p), Value: prettyMS(percentile(sorted, p))} //nolint:exhaustruct
+		if cis != nil {
+			row.CI = fmt.Sprintf("%s .. %s", prettyMS(cis[i].Low), prettyMS(cis[i].High))
+		}
+		res = append(res, row)
+	}
+	return res
+}
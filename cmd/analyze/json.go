@@ -0,0 +1,132 @@
+package main
+
+// JSON version of the overall stats and the latency comparisons of the analyzer report (without the optional sections of
+// the text and HTML reports), i.e. for dashboards querying the HTTP service
+
+import (
+	"fmt"
+	"time"
+)
+
+// Report holds the overall stats and the latency comparisons of an analyzer report
+type Report struct {
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	UniqueTxs int       `json:"unique_txs"`
+
+	AddedValueMS int64          `json:"added_value_ms"`
+	Sources      []ReportSource `json:"sources"`
+
+	TiePolicy          string             `json:"tie_policy"`
+	Comparisons        []ReportComparison `json:"comparisons"`
+	SkippedComparisons []string           `json:"skipped_comparisons"`
+}
+
+// ReportSource holds the counts of a source
+type ReportSource struct {
+	Name         string `json:"name"`
+	Received     int64  `json:"received"`
+	Exclusive    int64  `json:"exclusive"`
+	NotSeenLocal *int64 `json:"not_seen_local,omitempty"` // not set for the local source
+	FirstWins    int64  `json:"first_wins"`
+	AddedValue   int64  `json:"added_value"`
+}
+
+// ReportComparison holds the latency comparison of a source/reference pair
+type ReportComparison struct {
+	Source           string             `json:"source"`
+	Reference        string             `json:"reference"`
+	OnlyBySource     int                `json:"only_by_source"`
+	OnlyByReference  int                `json:"only_by_reference"`
+	SeenByBoth       int                `json:"seen_by_both"`
+	Skipped          bool               `json:"skipped"` // too few txs seen by both, the counts below are not set
+	FirstBySource    int                `json:"first_by_source"`
+	FirstByReference int                `json:"first_by_reference"`
+	Equal            int                `json:"equal"`
+	SourceAheadBy    map[string]int64   `json:"source_ahead_by,omitempty"` // ["<threshold>ms"] = txs which src received first by at least the threshold
+	PercentilesMS    map[string]float64 `json:"percentiles_ms,omitempty"`  // ["p<n>"] = latency delta (ref - src, ms with µs fraction, positive = src first)
+	MedianAbsDevMS   *float64           `json:"median_abs_dev_ms,omitempty"`
+
+	PercentileCIsMS map[string]ReportInterval `json:"percentile_cis_ms,omitempty"` // ["p<n>"] = 95% bootstrap confidence interval, if enabled
+}
+
+// ReportInterval is a confidence interval of a latency percentile (ms)
+type ReportInterval struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// Report returns the overall stats and the latency comparisons of the analysis
+func (a *Analyzer) Report() Report {
+	r := Report{
+		From:               a.timeFirst.UTC(),
+		To:                 a.timeLast.UTC(),
+		UniqueTxs:          a.nUniqueTx,
+		AddedValueMS:       a.opts.AddedValueMS,
+		Sources:            make([]ReportSource, 0, len(a.sources)),
+		TiePolicy:          a.opts.TiePolicy,
+		Comparisons:        make([]ReportComparison, 0, len(a.comps)),
+		SkippedComparisons: append([]string{}, a.compsSkip...),
+	}
+
+	addedValue := a.addedValue(a.opts.AddedValueMS)
+	for _, src := range a.sources {
+		if a.nTransactionsPerSource[src] == 0 {
+			continue
+		}
+		s := ReportSource{ //nolint:exhaustruct
+			Name:       src,
+			Received:   a.nTransactionsPerSource[src],
+			Exclusive:  a.nUniqueTxPerSource[src],
+			FirstWins:  a.nFirstWinsPerSource[src],
+			AddedValue: addedValue[src],
+		}
+		if src != referenceLocalSource {
+			notSeenLocal := a.nNotSeenLocalPerSource[src]
+			s.NotSeenLocal = &notSeenLocal
+		}
+		r.Sources = append(r.Sources, s)
+	}
+
+	for _, comp := range a.comps {
+		res := a.benchmarkSourceVsLocal(comp.src, comp.ref)
+		c := ReportComparison{ //nolint:exhaustruct
+			Source:          comp.src,
+			Reference:       comp.ref,
+			OnlyBySource:    res.onlyBySrc,
+			OnlyByReference: res.onlyByRef,
+			SeenByBoth:      res.totalSeenByBoth,
+			Skipped:         a.tooFewShared(res),
+		}
+		if c.Skipped {
+			r.Comparisons = append(r.Comparisons, c)
+			continue
+		}
+
+		c.FirstBySource = res.totalFirstBySrc
+		c.FirstByReference = res.totalFirstByRef
+		c.Equal = res.totalEqual
+		c.SourceAheadBy = make(map[string]int64)
+		for _, bucketMS := range bucketsMS {
+			c.SourceAheadBy[fmt.Sprintf("%dms", bucketMS)] = res.srcFirstBuckets[bucketMS]
+		}
+		if len(res.deltas) > 0 {
+			all := sortedCopy(res.deltas)
+			sorted := trimmed(all, a.opts.TrimFraction)
+			c.PercentilesMS = make(map[string]float64)
+			for _, p := range latencyPercentiles {
+				c.PercentilesMS[fmt.Sprintf("p%.0f", p)] = usToMS(percentile(sorted, p))
+			}
+			mad := usToMS(medianAbsoluteDeviation(all))
+			c.MedianAbsDevMS = &mad
+			if a.opts.BootstrapSamples > 0 {
+				c.PercentileCIsMS = make(map[string]ReportInterval)
+				for i, ci := range bootstrapPercentileCIs(sorted, latencyPercentiles, a.opts.BootstrapSamples) {
+					c.PercentileCIsMS[fmt.Sprintf("p%.0f", latencyPercentiles[i])] = ReportInterval{usToMS(ci.Low), usToMS(ci.High)}
+				}
+			}
+		}
+		r.Comparisons = append(r.Comparisons, c)
+	}
+	return r
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteLatenciesCSV writes the raw data of the latency comparisons, for external analysis (i.e. with pandas or R):
+// for each tx seen by multiple sources, the timestamp of each source in µs (empty if not seen by it). Rows are sorted by
+// the first sighting. Columns: hash,<sources...>
+func (a *Analyzer) WriteLatenciesCSV(w io.Writer) error {
+	type row struct {
+		hash    string
+		firstTS int64
+	}
+
+	rows := make([]row, 0)
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) || len(sources) == 1 {
+			continue
+		}
+
+		firstTS := int64(0)
+		for _, ts := range sources {
+			if firstTS == 0 || ts < firstTS {
+				firstTS = ts
+			}
+		}
+		rows = append(rows, row{txHash, firstTS})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].firstTS != rows[j].firstTS {
+			return rows[i].firstTS < rows[j].firstTS
+		}
+		return rows[i].hash < rows[j].hash
+	})
+
+	bw := bufio.NewWriter(w)
+	if _, err := fmt.Fprintf(bw, "hash,%s\n", strings.Join(a.sources, ",")); err != nil {
+		return err
+	}
+	cells := make([]string, len(a.sources))
+	for _, r := range rows {
+		sources := a.txs[r.hash]
+		for i, src := range a.sources {
+			cells[i] = ""
+			if ts, ok := sources[src]; ok {
+				cells[i] = fmt.Sprint(ts)
+			}
+		}
+		if _, err := fmt.Fprintf(bw, "%s,%s\n", strings.ToLower(r.hash), strings.Join(cells, ",")); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/flashbots/mempool-dumpster/common"
 	"github.com/urfave/cli/v2"
@@ -13,17 +15,148 @@ var (
 	version = "dev" // is set during build process
 	debug   = os.Getenv("DEBUG") == "1"
 
-	commonFlags = []cli.Flag{
+	// output files of the sourcelog command
+	outputFlags = []cli.Flag{
 		&cli.StringFlag{ //nolint:exhaustruct
 			Name:  "out",
 			Value: "",
 			Usage: "output filename",
 		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "html",
+			Value: "",
+			Usage: "also write the report as self-contained HTML page to this file",
+		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "coverage-csv",
+			Value: "",
+			Usage: "also write the cumulative number of txs per source over time as CSV to this file (i.e. for plotting)",
+		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "block-coverage-csv",
+			Value: "",
+			Usage: "also write the number of included txs of each block, and how many of them each source saw before the inclusion, as CSV to this file (requires --inclusion-times)",
+		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "latencies-csv",
+			Value: "",
+			Usage: "also write the timestamps (µs) of each source for every tx seen by multiple sources as CSV to this file (hash,<sources...>)",
+		},
+	}
+
+	// analysis options, of the sourcelog command and of the HTTP service (for every request)
+	commonFlags = []cli.Flag{
 		&cli.StringSliceFlag{ //nolint:exhaustruct
 			Name:  "known-txs",
 			Value: &cli.StringSlice{},
 			Usage: "reference transaction input files",
 		},
+		&cli.StringSliceFlag{ //nolint:exhaustruct
+			Name:  "tx-whitelist",
+			Value: &cli.StringSlice{},
+			Usage: "CSV files with one tx hash per line: analyze only these txs",
+		},
+		&cli.StringSliceFlag{ //nolint:exhaustruct
+			Name:  "tx-blacklist",
+			Value: &cli.StringSlice{},
+			Usage: "CSV files with one tx hash per line: exclude these txs from the analysis",
+		},
+		&cli.IntFlag{ //nolint:exhaustruct
+			Name:  "bootstrap",
+			Value: 0,
+			Usage: "number of bootstrap resamples for confidence intervals of the latency percentiles (0 = disabled)",
+		},
+		&cli.Float64Flag{ //nolint:exhaustruct
+			Name:  "trim",
+			Value: 0,
+			Usage: "drop this fraction of the lowest and highest latency deltas before computing the percentiles, i.e. 0.01 (robust to outliers)",
+		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "tie-policy",
+			Value: TiePolicyEqual,
+			Usage: "how to count equal timestamps of source and reference: equal, src or ref",
+		},
+		&cli.Int64Flag{ //nolint:exhaustruct
+			Name:  "added-value-ms",
+			Value: 100,
+			Usage: "count txs for which a source was first by more than this many ms over all other sources",
+		},
+		&cli.IntFlag{ //nolint:exhaustruct
+			Name:  "min-shared-txs",
+			Value: 0,
+			Usage: "skip latency comparisons of sources with fewer txs seen by both (0 = report all)",
+		},
+		&cli.Float64Flag{ //nolint:exhaustruct
+			Name:  "coverage-target",
+			Value: 0,
+			Usage: "report the minimal set of sources (greedy set cover) which sees this percentage of unique txs, i.e. 99 to decide which feeds to drop (0 = disabled)",
+		},
+		&cli.Float64SliceFlag{ //nolint:exhaustruct
+			Name:  "coverage-times",
+			Value: &cli.Float64Slice{},
+			Usage: "report the time at which each source had seen these percentages of the unique txs, i.e. 50,90 (resolution: --coverage-bucket)",
+		},
+		&cli.StringSliceFlag{ //nolint:exhaustruct
+			Name:  "compare",
+			Value: &cli.StringSlice{},
+			Usage: "latency comparisons as <source>:<reference>, i.e. bloxroute:local (default: bloxroute and chainbound vs local and each other). The reference onchain compares with the inclusion block timestamps (requires --inclusion-times)",
+		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "candidate",
+			Value: "",
+			Usage: "report the added coverage and the first-win rate of this source vs the earliest of all other sources, i.e. to decide on a trialed feed",
+		},
+		&cli.Int64Flag{ //nolint:exhaustruct
+			Name:  "clock-drift-warn-ms",
+			Value: 0,
+			Usage: "warn about source pairs with a larger median timestamp offset, which indicates clock drift of a collector, i.e. 250 (0 = disabled)",
+		},
+		&cli.DurationFlag{ //nolint:exhaustruct
+			Name:  "coverage-bucket",
+			Value: 10 * time.Minute,
+			Usage: "time bucket of the coverage CSV and of --coverage-times",
+		},
+		&cli.StringSliceFlag{ //nolint:exhaustruct
+			Name:  "inclusion-times",
+			Value: &cli.StringSlice{},
+			Usage: "CSV files with the inclusion block timestamp of txs (hash,block_timestamp in unix seconds): report the mempool dwell time",
+		},
+		&cli.BoolFlag{ //nolint:exhaustruct
+			Name:  "dwell-per-source",
+			Value: false,
+			Usage: "also report the dwell time since the sighting by each source (requires --inclusion-times)",
+		},
+	}
+
+	serveFlags = []cli.Flag{
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "listen-addr",
+			Value: ":8096",
+			Usage: "address to listen on",
+		},
+		&cli.StringFlag{ //nolint:exhaustruct
+			Name:  "data-dir",
+			Value: "out/",
+			Usage: "collector output directory (with <date>/sourcelog/ subdirectories)",
+		},
+		&cli.IntFlag{ //nolint:exhaustruct
+			Name:  "max-days",
+			Value: 7,
+			Usage: "maximum number of days per request",
+		},
+		&cli.IntFlag{ //nolint:exhaustruct
+			Name:  "max-concurrent",
+			Value: 1,
+			Usage: "maximum number of concurrent analyses",
+		},
+	}
+
+	diffFlags = []cli.Flag{
+		&cli.StringSliceFlag{ //nolint:exhaustruct
+			Name:     "prev",
+			Required: true,
+			Usage:    "sourcelog input files of the previous period (the current period files are the arguments)",
+		},
 	}
 
 	// Helpers
@@ -56,9 +189,21 @@ func main() {
 				Name:    "sourcelog",
 				Aliases: []string{"s"},
 				Usage:   "analyze sourcelog CSVs",
-				Flags:   commonFlags,
+				Flags:   append(outputFlags, commonFlags...),
 				Action:  analyze,
 			},
+			{
+				Name:   "diff",
+				Usage:  "compare the sourcelog analysis of two periods (i.e. two days)",
+				Flags:  diffFlags,
+				Action: diff,
+			},
+			{
+				Name:   "serve",
+				Usage:  "run an HTTP server to analyze a date range of collector output on demand (POST /analyze)",
+				Flags:  append(serveFlags, commonFlags...),
+				Action: serve,
+			},
 		},
 	}
 
@@ -69,8 +214,6 @@ func main() {
 
 func analyze(cCtx *cli.Context) error {
 	fnCSVSourcelog := cCtx.String("out")
-	knownTxsFiles := cCtx.StringSlice("known-txs")
-
 	inputFiles := cCtx.Args().Slice()
 	if cCtx.NArg() == 0 {
 		log.Fatal("no input files specified as arguments")
@@ -78,8 +221,12 @@ func analyze(cCtx *cli.Context) error {
 
 	log.Infow("Merge sourcelog", "version", version)
 
-	// Ensure output files are don't yet exist
-	common.MustNotExist(log, fnCSVSourcelog)
+	// Ensure the output files don't exist yet (reports are never overwritten), before the long analysis
+	for _, flag := range outputFlags {
+		if fn := cCtx.String(flag.Names()[0]); fn != "" {
+			common.MustNotExist(log, fn)
+		}
+	}
 	log.Infof("Output file: %s", fnCSVSourcelog)
 
 	// Check input files
@@ -87,6 +234,8 @@ func analyze(cCtx *cli.Context) error {
 		common.MustBeFile(log, fn)
 	}
 
+	opts := loadAnalyzerOpts(cCtx)
+
 	// Load input files
 	sourcelog, cntProcessedRecords := common.LoadSourceLogFiles(log, inputFiles)
 	log.Infow("Processed all input files",
@@ -95,6 +244,69 @@ func analyze(cCtx *cli.Context) error {
 		"memUsedMiB", printer.Sprintf("%d", common.GetMemUsageMb()),
 	)
 
+	log.Info("Analyzing...")
+	opts.Transactions = sourcelog
+	analyzer := NewAnalyzer(opts)
+	s := analyzer.Sprint()
+
+	if fnCSVSourcelog != "" {
+		writeSummary(fnCSVSourcelog, "summary", s)
+	}
+
+	if fnHTML := cCtx.String("html"); fnHTML != "" {
+		page, err := analyzer.SprintHTML()
+		check(err, "SprintHTML")
+		writeSummary(fnHTML, "HTML report", page)
+	}
+
+	if fnCoverage := cCtx.String("coverage-csv"); fnCoverage != "" {
+		writeSummary(fnCoverage, "coverage CSV", analyzer.CoverageCSV(cCtx.Duration("coverage-bucket")))
+	}
+
+	if fnBlocks := cCtx.String("block-coverage-csv"); fnBlocks != "" {
+		writeSummary(fnBlocks, "block coverage CSV", analyzer.BlockCoverageCSV())
+	}
+
+	if fnLatencies := cCtx.String("latencies-csv"); fnLatencies != "" {
+		writeLatencies(analyzer, fnLatencies)
+	}
+
+	fmt.Println("")
+	fmt.Println(s)
+	return nil
+}
+
+func writeLatencies(analyzer *Analyzer, fn string) {
+	log.Infof("Writing latencies CSV file %s ...", fn)
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	check(err, "os.OpenFile")
+	defer f.Close()
+	err = analyzer.WriteLatenciesCSV(f)
+	check(err, "WriteLatenciesCSV")
+}
+
+// loadAnalyzerOpts validates the analysis flags (commonFlags) and loads the files they reference, the known txs, the
+// tx filters and the inclusion times. The transactions to analyze are not set.
+func loadAnalyzerOpts(cCtx *cli.Context) AnalyzerOpts {
+	knownTxsFiles := cCtx.StringSlice("known-txs")
+	trimFraction := cCtx.Float64("trim")
+	if trimFraction < 0 || trimFraction >= 0.5 {
+		log.Fatalf("invalid trim fraction: %f (must be between 0 and 0.5)", trimFraction)
+	}
+	tiePolicy := cCtx.String("tie-policy")
+	if tiePolicy != TiePolicyEqual && tiePolicy != TiePolicySrc && tiePolicy != TiePolicyRef {
+		log.Fatalf("invalid tie policy: %s", tiePolicy)
+	}
+
+	var sourceComps []sourceComp
+	for _, comp := range cCtx.StringSlice("compare") {
+		src, ref, ok := strings.Cut(comp, ":")
+		if !ok {
+			log.Fatalf("invalid comparison: %s (format: <source>:<reference>)", comp)
+		}
+		sourceComps = append(sourceComps, sourceComp{src, ref})
+	}
+
 	// Load reference input files (i.e. transactions before the current date to remove false positives)
 	prevKnownTxs, err := common.LoadTxHashesFromMetadataCSVFiles(log, knownTxsFiles)
 	check(err, "LoadTxHashesFromMetadataCSVFiles")
@@ -106,22 +318,49 @@ func analyze(cCtx *cli.Context) error {
 		)
 	}
 
-	log.Info("Analyzing...")
-	analyzer := NewAnalyzer(sourcelog, prevKnownTxs)
-	s := analyzer.Sprint()
+	// Load the tx whitelist and blacklist (i.e. to focus on or exclude a known set of sandwich txs)
+	txWhitelist, err := common.LoadTxHashesFromListFiles(log, cCtx.StringSlice("tx-whitelist"))
+	check(err, "LoadTxHashesFromListFiles")
+	txBlacklist, err := common.LoadTxHashesFromListFiles(log, cCtx.StringSlice("tx-blacklist"))
+	check(err, "LoadTxHashesFromListFiles")
+	if len(txWhitelist) > 0 || len(txBlacklist) > 0 {
+		log.Infow("Loaded tx filters",
+			"whitelist", printer.Sprintf("%d", len(txWhitelist)),
+			"blacklist", printer.Sprintf("%d", len(txBlacklist)),
+		)
+	}
 
-	if fnCSVSourcelog != "" {
-		writeSummary(fnCSVSourcelog, s)
+	// Load the inclusion block timestamps (i.e. exported from a node or a block explorer dataset)
+	inclusionTimes, err := common.LoadInclusionTimesFromCSVFiles(log, cCtx.StringSlice("inclusion-times"))
+	check(err, "LoadInclusionTimesFromCSVFiles")
+	if len(inclusionTimes) > 0 {
+		log.Infow("Loaded inclusion times", "txs", printer.Sprintf("%d", len(inclusionTimes)))
 	}
 
-	fmt.Println("")
-	fmt.Println(s)
-	return nil
+	return AnalyzerOpts{ //nolint:exhaustruct
+		PrevKnownTxs:     prevKnownTxs,
+		BootstrapSamples: cCtx.Int("bootstrap"),
+		TiePolicy:        tiePolicy,
+		TrimFraction:     trimFraction,
+		AddedValueMS:     cCtx.Int64("added-value-ms"),
+		MinSharedTxs:     cCtx.Int("min-shared-txs"),
+		ClockDriftWarnMS: cCtx.Int64("clock-drift-warn-ms"),
+		CoverageTarget:   cCtx.Float64("coverage-target"),
+		CoverageTimes:    cCtx.Float64Slice("coverage-times"),
+		CoverageBucket:   cCtx.Duration("coverage-bucket"),
+		Candidate:        cCtx.String("candidate"),
+		SourceComps:      sourceComps,
+		TxWhitelist:      txWhitelist,
+		TxBlacklist:      txBlacklist,
+		InclusionTimes:   inclusionTimes,
+		DwellPerSource:   cCtx.Bool("dwell-per-source"),
+	}
 }
 
-func writeSummary(fn, s string) {
-	log.Infof("Writing summary CSV file %s ...", fn)
-	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+// writeSummary writes a report (of the given format, for the log) to the file, which must not exist yet
+func writeSummary(fn, format, s string) {
+	log.Infof("Writing %s file %s ...", format, fn)
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
 	if err != nil {
 		log.Errorw("openFile", "error", err)
 		return
@@ -133,3 +372,27 @@ func writeSummary(fn, s string) {
 		return
 	}
 }
+
+func diff(cCtx *cli.Context) error {
+	prevFiles := cCtx.StringSlice("prev")
+	curFiles := cCtx.Args().Slice()
+	if cCtx.NArg() == 0 {
+		log.Fatal("no input files specified as arguments")
+	}
+
+	for _, fn := range append(prevFiles, curFiles...) {
+		common.MustBeFile(log, fn)
+	}
+
+	log.Info("Analyzing previous period...")
+	prevSourcelog, _ := common.LoadSourceLogFiles(log, prevFiles)
+	prev := NewAnalyzer(AnalyzerOpts{Transactions: prevSourcelog}).Summary() //nolint:exhaustruct
+
+	log.Info("Analyzing current period...")
+	curSourcelog, _ := common.LoadSourceLogFiles(log, curFiles)
+	cur := NewAnalyzer(AnalyzerOpts{Transactions: curSourcelog}).Summary() //nolint:exhaustruct
+
+	fmt.Println("")
+	fmt.Println(SprintSummaryDiff(prev, cur))
+	return nil
+}
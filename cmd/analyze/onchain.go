@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flashbots/mempool-dumpster/common"
+)
+
+// onchainResult compares the sightings of a source with the inclusion of the txs in a block, i.e. the latency
+// comparison with the "onchain" reference
+type onchainResult struct {
+	included   int     // included txs seen by the source
+	seenBefore int     // included txs seen by the source before the inclusion block timestamp
+	deltas     []int64 // inclusion time - sighting by the source (µs) for each included tx, negative if seen after the inclusion
+}
+
+// benchmarkSourceVsOnchain compares the sightings of a source with the inclusion block timestamps. Block timestamps
+// have a resolution of seconds, so the deltas are only accurate to about a second.
+func (a *Analyzer) benchmarkSourceVsOnchain(src string) *onchainResult {
+	res := &onchainResult{ //nolint:exhaustruct
+		deltas: make([]int64, 0),
+	}
+
+	for txHash, sources := range a.txs {
+		txHashLower := strings.ToLower(txHash)
+		if a.skipTx(txHashLower) {
+			continue
+		}
+
+		srcTS, seenBySrc := sources[src]
+		inclusionMS, included := a.opts.InclusionTimes[txHashLower]
+		if !seenBySrc || !included {
+			continue
+		}
+		inclusionTS := inclusionMS * 1000
+
+		res.included += 1
+		if srcTS <= inclusionTS {
+			res.seenBefore += 1
+		}
+		res.deltas = append(res.deltas, inclusionTS-srcTS)
+	}
+	return res
+}
+
+// summary describes how many included txs the source saw, and how many of them before the inclusion
+func (res *onchainResult) summary(src string) string {
+	return fmt.Sprintf("Included txs seen by %s: %s, before the inclusion block timestamp: %s (%s)", src, prettyInt(res.included), prettyInt(res.seenBefore), common.Int64DiffPercentFmt(int64(res.seenBefore), int64(res.included)))
+}
+
+// sprintOnchainComparison renders how many included txs the source saw before the inclusion, and how long before the
+// inclusion it saw them, by percentile
+func (a *Analyzer) sprintOnchainComparison(src string) string {
+	res := a.benchmarkSourceVsOnchain(src)
+	out := res.summary(src) + "\n"
+	if len(res.deltas) == 0 {
+		return out
+	}
+
+	out += fmt.Sprintf("Time before inclusion (inclusion block timestamp - sighting by %s, ms): \n", src)
+	out += sprintDwellPercentiles(res.deltas)
+	return out
+}
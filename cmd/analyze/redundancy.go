@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flashbots/mempool-dumpster/common"
+)
+
+// coverageStep is a step of the greedy source selection: the source with the largest marginal coverage
+type coverageStep struct {
+	src        string
+	added      int // unique txs which none of the previously selected sources saw
+	cumulative int // unique txs seen by the sources selected so far (including this one)
+}
+
+// greedySourceCover selects sources one by one, each time the one which sees the most txs not yet covered by the
+// selected ones (greedy set cover), until all txs are covered. Ties are broken by source name. The cumulative coverage
+// shows which sources add little on top of the others (i.e. paid feeds which could be dropped).
+func (a *Analyzer) greedySourceCover() []coverageStep {
+	uncovered := make([]map[string]int64, 0, a.nUniqueTx)
+	for txHash, sources := range a.txs {
+		if !a.skipTx(strings.ToLower(txHash)) {
+			uncovered = append(uncovered, sources)
+		}
+	}
+
+	steps := make([]coverageStep, 0)
+	selected := make(map[string]bool)
+	cumulative := 0
+	for len(uncovered) > 0 {
+		// count the uncovered txs of every remaining source
+		marginal := make(map[string]int)
+		for _, sources := range uncovered {
+			for src := range sources {
+				if !selected[src] {
+					marginal[src]++
+				}
+			}
+		}
+
+		// a.sources is sorted, so the first source with the maximum wins ties
+		best := ""
+		for _, src := range a.sources {
+			if marginal[src] > marginal[best] {
+				best = src
+			}
+		}
+		if best == "" {
+			break
+		}
+
+		selected[best] = true
+		cumulative += marginal[best]
+		steps = append(steps, coverageStep{best, marginal[best], cumulative})
+
+		// keep only the txs which the selected source didn't see
+		remaining := uncovered[:0]
+		for _, sources := range uncovered {
+			if _, ok := sources[best]; !ok {
+				remaining = append(remaining, sources)
+			}
+		}
+		uncovered = remaining
+	}
+	return steps
+}
+
+// sprintSourceRedundancy renders the greedy coverage curve, and the minimal set of sources which covers the target
+// percentage of unique txs
+func (a *Analyzer) sprintSourceRedundancy(targetPct float64) string {
+	steps := a.greedySourceCover()
+	if len(steps) == 0 {
+		return ""
+	}
+
+	out := "Cumulative coverage (greedy, the source adding the most uncovered txs first): \n"
+	for _, step := range steps {
+		out += fmt.Sprintf("- %-10s %10s   (%7s, +%s) \n", step.src, prettyInt(step.cumulative), common.Int64DiffPercentFmt(int64(step.cumulative), int64(a.nUniqueTx)), prettyInt(step.added))
+	}
+
+	minimalSet := make([]string, 0)
+	for _, step := range steps {
+		minimalSet = append(minimalSet, step.src)
+		if 100*float64(step.cumulative) >= targetPct*float64(a.nUniqueTx) {
+			break
+		}
+	}
+	out += fmt.Sprintf("Sources covering %.1f%% of the unique txs: %s \n", targetPct, strings.Join(minimalSet, ", "))
+	return out
+}
@@ -0,0 +1,152 @@
+package main
+
+// HTTP service to run the analyzer on demand over a date range of collector output (sourcelog CSV files)
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/urfave/cli/v2"
+)
+
+type analyzeRequest struct {
+	From string `json:"from"` // first day, format: YYYY-MM-DD
+	To   string `json:"to"`   // last day (inclusive), format: YYYY-MM-DD
+}
+
+type analyzeServer struct {
+	dataDir string
+	maxDays int
+	sem     chan struct{} // limits the number of concurrent analyses
+	opts    AnalyzerOpts  // from the flags, the transactions are set for each request
+}
+
+func serve(cCtx *cli.Context) error {
+	listenAddr := cCtx.String("listen-addr")
+	maxConcurrent := cCtx.Int("max-concurrent")
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	srv := &analyzeServer{
+		dataDir: cCtx.String("data-dir"),
+		maxDays: cCtx.Int("max-days"),
+		sem:     make(chan struct{}, maxConcurrent),
+		opts:    loadAnalyzerOpts(cCtx),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/analyze", srv.handleAnalyze)
+
+	httpSrv := &http.Server{ //nolint:exhaustruct
+		Addr:              listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Minute, // loading and analyzing several days of data takes a while
+	}
+
+	log.Infow("Starting analyzer server", "listenAddr", listenAddr, "dataDir", srv.dataDir, "maxDays", srv.maxDays, "version", version)
+	return httpSrv.ListenAndServe()
+}
+
+// handleAnalyze expects a POST request with a JSON body like {"from": "2023-09-01", "to": "2023-09-02"}, and responds with the text report,
+// or with the JSON report (see Report) with ?format=json
+func (s *analyzeServer) handleAnalyze(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if format != "" && format != "text" && format != "json" {
+		http.Error(w, "invalid format (text or json)", http.StatusBadRequest)
+		return
+	}
+
+	var r analyzeRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, 1024)).Decode(&r); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	from, err := time.Parse(time.DateOnly, r.From)
+	if err != nil {
+		http.Error(w, "invalid 'from' date", http.StatusBadRequest)
+		return
+	}
+	to, err := time.Parse(time.DateOnly, r.To)
+	if err != nil {
+		http.Error(w, "invalid 'to' date", http.StatusBadRequest)
+		return
+	}
+	if to.Before(from) {
+		http.Error(w, "'to' is before 'from'", http.StatusBadRequest)
+		return
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > s.maxDays {
+		http.Error(w, fmt.Sprintf("date range too large (%d days, max %d)", days, s.maxDays), http.StatusBadRequest)
+		return
+	}
+
+	// only run a limited number of analyses at the same time, because they can use a lot of memory
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	default:
+		http.Error(w, "too many concurrent analyses, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	files, err := s.sourcelogFiles(from, to)
+	if err != nil {
+		log.Errorw("sourcelogFiles", "error", err)
+		http.Error(w, "failed to list input files", http.StatusInternalServerError)
+		return
+	}
+	if len(files) == 0 {
+		http.Error(w, "no sourcelog files found for this date range", http.StatusNotFound)
+		return
+	}
+
+	log.Infow("Analyzing on demand", "from", r.From, "to", r.To, "files", len(files))
+	sourcelog, cntProcessedRecords := common.LoadSourceLogFiles(log, files)
+	log.Infow("Processed all input files",
+		"txTotal", printer.Sprintf("%d", len(sourcelog)),
+		"records", printer.Sprintf("%d", cntProcessedRecords),
+		"memUsedMiB", printer.Sprintf("%d", common.GetMemUsageMb()),
+	)
+
+	opts := s.opts
+	opts.Transactions = sourcelog
+	analyzer := NewAnalyzer(opts)
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(analyzer.Report()); err != nil {
+			log.Errorw("failed to write the JSON report", "error", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, analyzer.Sprint())
+}
+
+// sourcelogFiles returns all sourcelog files of the collector output directory between two days (inclusive)
+func (s *analyzeServer) sourcelogFiles(from, to time.Time) (files []string, err error) {
+	for t := from; !t.After(to); t = t.AddDate(0, 0, 1) {
+		for _, pattern := range []string{"*.csv", "*.csv.zip", "*.csv.gz", "*.csv.zst", "*.csv.sz"} {
+			matches, err := filepath.Glob(filepath.Join(s.dataDir, t.Format(time.DateOnly), "sourcelog", pattern))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, matches...)
+		}
+	}
+	return files, nil
+}
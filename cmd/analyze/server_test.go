@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestServeJSON(t *testing.T) {
+	log = zap.NewNop().Sugar()
+	dataDir := t.TempDir()
+	dir := filepath.Join(dataDir, "2023-08-07", "sourcelog")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	h1, h2, h3 := "0x"+strings.Repeat("1", 64), "0x"+strings.Repeat("2", 64), "0x"+strings.Repeat("3", 64)
+	sourcelog := "1691402400000," + h1 + ",a\n1691402400005," + h1 + ",b\n" + // a first
+		"1691402400100," + h2 + ",a\n1691402400090," + h2 + ",b\n" + // b first
+		"1691402400200," + h3 + ",b\n" // only b
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.csv"), []byte(sourcelog), 0o600))
+
+	// the analysis options of the flags are used for every request
+	srv := &analyzeServer{
+		dataDir: dataDir,
+		maxDays: 1,
+		sem:     make(chan struct{}, 1),
+		opts:    AnalyzerOpts{TiePolicy: TiePolicyRef, SourceComps: []sourceComp{{"a", "b"}, {"a", "c"}}}, //nolint:exhaustruct
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/analyze?format=json", strings.NewReader(`{"from": "2023-08-07", "to": "2023-08-07"}`))
+	rec := httptest.NewRecorder()
+	srv.handleAnalyze(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var report Report
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&report))
+	require.Equal(t, 3, report.UniqueTxs)
+	require.Equal(t, TiePolicyRef, report.TiePolicy)
+	require.Equal(t, []string{"a vs c (c not in the data)"}, report.SkippedComparisons)
+	require.Len(t, report.Comparisons, 1)
+	require.Equal(t, "a", report.Comparisons[0].Source)
+	require.Equal(t, 2, report.Comparisons[0].SeenByBoth)
+	require.Equal(t, 1, report.Comparisons[0].FirstBySource)
+	require.Equal(t, 1, report.Comparisons[0].OnlyByReference)
+
+	// unknown formats are rejected
+	req = httptest.NewRequest(http.MethodPost, "/analyze?format=xml", strings.NewReader(`{"from": "2023-08-07", "to": "2023-08-07"}`))
+	rec = httptest.NewRecorder()
+	srv.handleAnalyze(rec, req)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
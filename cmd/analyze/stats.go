@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// latencyPercentiles are reported for the latency deltas of each source comparison
+var latencyPercentiles = []float64{10, 25, 50, 75, 90}
+
+// percentile returns the nearest-rank percentile (0 < p <= 100) of an ascending sorted slice
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// sortedCopy returns an ascending sorted copy of the values
+func sortedCopy(values []int64) []int64 {
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// trimmed returns the values without the lowest and highest fraction (0 <= fraction < 0.5) of an ascending sorted slice
+func trimmed(sorted []int64, fraction float64) []int64 {
+	n := int(fraction * float64(len(sorted)))
+	if fraction <= 0 || 2*n >= len(sorted) {
+		return sorted
+	}
+	return sorted[n : len(sorted)-n]
+}
+
+// medianAbsoluteDeviation returns the median of the absolute deviations from the median of an ascending sorted
+// slice, a spread measure which is robust to outliers (unlike the standard deviation)
+func medianAbsoluteDeviation(sorted []int64) int64 {
+	median := percentile(sorted, 50)
+	deviations := make([]int64, len(sorted))
+	for i, v := range sorted {
+		deviations[i] = v - median
+		if deviations[i] < 0 {
+			deviations[i] = -deviations[i]
+		}
+	}
+	return percentile(sortedCopy(deviations), 50)
+}
+
+// confidenceInterval is the range in which a statistic lies with a given confidence
+type confidenceInterval struct {
+	Low  int64
+	High int64
+}
+
+// bootstrapPercentileCIs estimates 95% confidence intervals for each of the percentiles, by resampling the
+// values with replacement `samples` times. The random source is seeded deterministically for reproducible reports.
+func bootstrapPercentileCIs(values []int64, percentiles []float64, samples int) []confidenceInterval {
+	cis := make([]confidenceInterval, len(percentiles))
+	if len(values) == 0 || samples <= 0 {
+		return cis
+	}
+
+	rnd := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	estimates := make([][]int64, len(percentiles)) // [percentile][sample] = value
+	resample := make([]int64, len(values))
+	for i := 0; i < samples; i++ {
+		for j := range resample {
+			resample[j] = values[rnd.Intn(len(values))]
+		}
+		sort.Slice(resample, func(a, b int) bool { return resample[a] < resample[b] })
+		for k, p := range percentiles {
+			estimates[k] = append(estimates[k], percentile(resample, p))
+		}
+	}
+
+	for k := range percentiles {
+		sorted := sortedCopy(estimates[k])
+		cis[k] = confidenceInterval{
+			Low:  percentile(sorted, 2.5),
+			High: percentile(sorted, 97.5),
+		}
+	}
+	return cis
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	require.Equal(t, int64(1), percentile(values, 10))
+	require.Equal(t, int64(5), percentile(values, 50))
+	require.Equal(t, int64(9), percentile(values, 90))
+	require.Equal(t, int64(10), percentile(values, 100))
+	require.Equal(t, int64(0), percentile([]int64{}, 50))
+}
+
+func TestBootstrapPercentileCIs(t *testing.T) {
+	values := make([]int64, 1000)
+	for i := range values {
+		values[i] = int64(i)
+	}
+
+	cis := bootstrapPercentileCIs(values, latencyPercentiles, 200)
+	require.Len(t, cis, len(latencyPercentiles))
+	for i, p := range latencyPercentiles {
+		estimate := percentile(values, p)
+		require.LessOrEqual(t, cis[i].Low, estimate)
+		require.GreaterOrEqual(t, cis[i].High, estimate)
+	}
+
+	// deterministic
+	require.Equal(t, cis, bootstrapPercentileCIs(values, latencyPercentiles, 200))
+}
+
+func TestTrimmedAndMAD(t *testing.T) {
+	values := []int64{-1000, 1, 2, 3, 4, 5, 6, 7, 8, 5000}
+	require.Equal(t, values, trimmed(values, 0))
+	require.Equal(t, []int64{1, 2, 3, 4, 5, 6, 7, 8}, trimmed(values, 0.1))
+	require.Equal(t, values, trimmed(values, 0.5))
+
+	// deviations from the median 4: 1004, 3, 2, 1, 0, 1, 2, 3, 4, 4996
+	require.Equal(t, int64(2), medianAbsoluteDeviation(values))
+
+	// the MAD is computed on the untrimmed deltas: trimmed, the spread would be 0 (deviations from the median 0: 0, 0, 0, 0, 10, 10, 10, 10 ms)
+	deltas := []int64{-1_000_000, 0, 0, 0, 0, 10_000, 10_000, 10_000, 10_000, 5_000_000}
+	require.Equal(t, int64(0), medianAbsoluteDeviation(trimmed(deltas, 0.1)))
+	require.Equal(t, int64(10_000), medianAbsoluteDeviation(deltas))
+
+	log = zap.NewNop().Sugar()
+	a := NewAnalyzer(AnalyzerOpts{TrimFraction: 0.1}) //nolint:exhaustruct
+	out := a.sprintLatencyPercentiles("a", "b", deltas)
+	require.Contains(t, out, fmt.Sprintf("- %-8s %10d   (untrimmed)\n", "MAD", 10))
+}
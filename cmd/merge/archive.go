@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/urfave/cli/v2"
+)
+
+// archiveDay writes the transactions, sourcelog, trash, replacements and source txs CSV files of a collector date directory into a single day archive
+func archiveDay(cCtx *cli.Context) error {
+	fnOut := cCtx.String("out")
+	if cCtx.NArg() != 1 {
+		log.Fatal("exactly one date directory required as argument")
+	}
+	dateDir := cCtx.Args().First()
+
+	log.Infow("Archive date directory", "dir", dateDir, "out", fnOut, "version", version)
+	if s, err := os.Stat(dateDir); err != nil || !s.IsDir() {
+		log.Fatalf("Input is not a directory: %s", dateDir)
+	}
+	if !common.IsArchive(fnOut) {
+		log.Fatalf("Output file must end in %s: %s", common.ArchiveExt, fnOut)
+	}
+	common.MustNotExist(log, fnOut)
+
+	f, err := os.OpenFile(fnOut, os.O_CREATE|os.O_WRONLY, 0o600)
+	check(err, "os.OpenFile")
+	defer f.Close()
+
+	cntFiles, err := common.WriteArchive(dateDir, f)
+	check(err, "WriteArchive")
+
+	fi, err := f.Stat()
+	check(err, "f.Stat")
+	log.Infow("Archive written", "out", fnOut, "files", cntFiles, "sizeMiB", printer.Sprintf("%.2f", float64(fi.Size())/1024/1024))
+	return nil
+}
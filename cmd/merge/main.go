@@ -75,6 +75,71 @@ func main() {
 				Flags:   commonFlags,
 				Action:  mergeSourcelog,
 			},
+			{
+				Name:  "repair",
+				Usage: "write a cleaned copy of a transactions CSV (without truncated, invalid and duplicate lines), and a report of the removed lines",
+				Flags: []cli.Flag{
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:     "out",
+						Required: true,
+						Usage:    "output file (the report is written to <out>.report.csv)",
+					},
+				},
+				Action: repairTransactions,
+			},
+			{
+				Name:  "archive",
+				Usage: "write the CSV files of a collector date directory (transactions, sourcelog, trash, ...) into a single zstd-compressed tar, which the merger and analyzer can read as input file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:     "out",
+						Required: true,
+						Usage:    "output file (<date>" + common.ArchiveExt + ")",
+					},
+				},
+				Action: archiveDay,
+			},
+			{
+				Name:  "reconcile",
+				Usage: "cross-check the sourcelog and the transactions CSV of a bucket, and report hashes which are only in one of them (i.e. after a crash)",
+				Flags: []cli.Flag{
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:     "sourcelog",
+						Required: true,
+						Usage:    "sourcelog CSV of the bucket",
+					},
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:     "txs",
+						Required: true,
+						Usage:    "transactions CSV of the bucket",
+					},
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:  "trash",
+						Usage: "trash CSV of the bucket (optional, its txs are not counted as orphans)",
+					},
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:  "out",
+						Usage: "write the orphaned hashes to this file (hash,side)",
+					},
+				},
+				Action: reconcile,
+			},
+			{
+				Name:  "first-region",
+				Usage: "determine the region which saw each tx first, from the sourcelog CSVs (or day archives) of collectors in multiple regions (recorded with -region)",
+				Flags: []cli.Flag{
+					&cli.StringFlag{ //nolint:exhaustruct
+						Name:  "out",
+						Usage: "write the first sighting of each tx to this file (timestamp_ms,hash,region)",
+					},
+				},
+				Action: firstRegion,
+			},
+			{
+				Name:   "trash-summary",
+				Usage:  "tally the trash CSVs (or day archives) of a period by reason and by source",
+				Action: trashSummary,
+			},
 		},
 	}
 
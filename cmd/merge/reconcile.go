@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/urfave/cli/v2"
+)
+
+// reconcile cross-checks the sourcelog and the transactions file of a bucket, and reports the orphaned hashes on either side
+func reconcile(cCtx *cli.Context) error {
+	fnSourcelog := cCtx.String("sourcelog")
+	fnTxs := cCtx.String("txs")
+	fnTrash := cCtx.String("trash")
+	fnOut := cCtx.String("out")
+
+	log.Infow("Reconcile sourcelog and transactions", "sourcelog", fnSourcelog, "txs", fnTxs, "trash", fnTrash, "version", version)
+	for _, fn := range []string{fnSourcelog, fnTxs, fnTrash} {
+		if fn != "" {
+			common.MustBeFile(log, fn)
+		}
+	}
+	if fnOut != "" {
+		common.MustNotExist(log, fnOut)
+	}
+
+	sourcelogRows, err := common.GetCSV(fnSourcelog)
+	check(err, "GetCSV sourcelog")
+	txsRows, err := common.GetCSV(fnTxs)
+	check(err, "GetCSV txs")
+	var trashRows [][]string
+	if fnTrash != "" {
+		trashRows, err = common.GetCSV(fnTrash)
+		check(err, "GetCSV trash")
+	}
+
+	report := common.ReconcileSourcelogTxs(sourcelogRows, txsRows, trashRows)
+	log.Infow("Reconciled", "summary", report.String())
+
+	if fnOut == "" {
+		return nil
+	}
+
+	// Write the orphaned hashes (hash,side)
+	fOut, err := os.OpenFile(fnOut, os.O_CREATE|os.O_WRONLY, 0o600)
+	check(err, "os.OpenFile")
+	defer fOut.Close()
+	_, err = fOut.WriteString("hash,side\n")
+	check(err, "fOut.WriteString")
+	for _, hash := range report.OnlyInSourcelog {
+		_, err = fmt.Fprintf(fOut, "%s,%s\n", hash, common.ReconcileOnlySourcelog)
+		check(err, "fmt.Fprintf")
+	}
+	for _, hash := range report.OnlyInTransactions {
+		_, err = fmt.Fprintf(fOut, "%s,%s\n", hash, common.ReconcileOnlyTransactions)
+		check(err, "fmt.Fprintf")
+	}
+
+	log.Infow("Report written", "out", fnOut)
+	return nil
+}
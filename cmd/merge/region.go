@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/urfave/cli/v2"
+)
+
+// firstRegion determines the region which saw each tx first globally, from the sourcelogs of collectors in multiple
+// regions (recorded with -region)
+func firstRegion(cCtx *cli.Context) error {
+	fnOut := cCtx.String("out")
+	inputFiles := cCtx.Args().Slice()
+	if cCtx.NArg() == 0 {
+		log.Fatal("no input files specified as arguments")
+	}
+
+	log.Infow("First region", "files", len(inputFiles), "version", version)
+	common.MustNotExist(log, fnOut)
+	for _, fn := range inputFiles {
+		common.MustBeFile(log, fn)
+	}
+
+	firstRegions := common.NewFirstRegions()
+	for _, fn := range inputFiles {
+		log.Infof("Loading %s ...", fn)
+		var rows [][]string
+		var err error
+		if common.IsArchive(fn) {
+			rows, err = common.GetCSVFromArchive(fn, "sourcelog")
+		} else {
+			rows, err = common.GetCSV(fn)
+		}
+		check(err, "GetCSV")
+		firstRegions.Add(rows)
+	}
+
+	if fnOut != "" {
+		log.Infof("Writing first regions CSV file %s ...", fnOut)
+		err := writeFirstRegionsCSV(fnOut, firstRegions)
+		check(err, "writeFirstRegionsCSV")
+	}
+
+	fmt.Println("")
+	fmt.Println(firstRegions.String())
+	return nil
+}
+
+func writeFirstRegionsCSV(fn string, firstRegions *common.FirstRegions) error {
+	f, err := os.OpenFile(fn, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.WriteString("timestamp_ms,hash,region\n"); err != nil {
+		return err
+	}
+	for _, hash := range firstRegions.SortedHashes() {
+		sighting := firstRegions.First[hash]
+		if _, err = fmt.Fprintf(f, "%d,%s,%s\n", sighting.TimestampMs, hash, sighting.Region); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/urfave/cli/v2"
+)
+
+// repairTransactions writes a cleaned copy of a transactions CSV (i.e. after a power outage), and a report of the removed lines
+func repairTransactions(cCtx *cli.Context) error {
+	fnOut := cCtx.String("out")
+	if cCtx.NArg() != 1 {
+		log.Fatal("exactly one input file required as argument")
+	}
+	fnIn := cCtx.Args().First()
+	fnReport := fnOut + ".report.csv"
+
+	log.Infow("Repair transactions CSV", "input", fnIn, "out", fnOut, "version", version)
+	common.MustBeFile(log, fnIn)
+	if common.IsArchive(fnIn) {
+		log.Fatalf("Input file must be a transactions CSV, not an archive: %s", fnIn)
+	}
+	common.MustNotExist(log, fnOut)
+	common.MustNotExist(log, fnReport)
+
+	fIn, err := os.Open(fnIn)
+	check(err, "os.Open")
+	defer fIn.Close()
+
+	fOut, err := os.OpenFile(fnOut, os.O_CREATE|os.O_WRONLY, 0o600)
+	check(err, "os.OpenFile")
+	defer fOut.Close()
+
+	report, err := common.RepairTransactionsCSV(fIn, fOut)
+	check(err, "RepairTransactionsCSV")
+
+	// Write the report (line,reason)
+	fReport, err := os.OpenFile(fnReport, os.O_CREATE|os.O_WRONLY, 0o600)
+	check(err, "os.OpenFile")
+	defer fReport.Close()
+	_, err = fReport.WriteString("line,reason\n")
+	check(err, "fReport.WriteString")
+	for _, removed := range report.RemovedLines {
+		_, err = fmt.Fprintf(fReport, "%d,%s\n", removed.Line, removed.Reason)
+		check(err, "fmt.Fprintf")
+	}
+
+	log.Infow("Repaired file written", "out", fnOut, "report", fnReport, "summary", report.String())
+	return nil
+}
@@ -52,6 +52,8 @@ func mergeSourcelog(cCtx *cli.Context) error {
 	return nil
 }
 
+// writeSourcelogCSV writes the merged sourcelog in the published format, with millisecond timestamps (the loaded
+// sourcelog has microseconds)
 func writeSourcelogCSV(fn string, sourcelog map[string]map[string]int64) error {
 	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
 	if err != nil {
@@ -68,7 +70,8 @@ func writeSourcelogCSV(fn string, sourcelog map[string]map[string]int64) error {
 	// save tx+source by timestamp: [timestamp][hash] = source
 	cache := make(map[int]map[string][]string)
 	for hash, v := range sourcelog {
-		for source, ts := range v {
+		for source, tsUs := range v {
+			ts := tsUs / 1000
 			if _, ok := cache[int(ts)]; !ok {
 				cache[int(ts)] = make(map[string][]string)
 			}
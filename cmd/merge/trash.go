@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/urfave/cli/v2"
+)
+
+// trashSummary tallies the trash files of a period by reason and by source
+func trashSummary(cCtx *cli.Context) error {
+	inputFiles := cCtx.Args().Slice()
+	if cCtx.NArg() == 0 {
+		log.Fatal("no input files specified as arguments")
+	}
+
+	log.Infow("Summarize trash", "files", len(inputFiles), "version", version)
+	for _, fn := range inputFiles {
+		common.MustBeFile(log, fn)
+	}
+
+	summary := common.NewTrashSummary()
+	for _, fn := range inputFiles {
+		log.Infof("Loading %s ...", fn)
+		var rows [][]string
+		var err error
+		if common.IsArchive(fn) {
+			rows, err = common.GetCSVFromArchive(fn, "trash")
+		} else {
+			rows, err = common.GetCSV(fn)
+		}
+		check(err, "GetCSV")
+		summary.Add(rows)
+	}
+
+	fmt.Println("")
+	fmt.Println(summary.String())
+	return nil
+}
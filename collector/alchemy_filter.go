@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var ErrInvalidAlchemyFilter = errors.New("invalid alchemy address filter")
+
+// AlchemyTxFilter is the server-side address filter of the alchemy_pendingTransactions subscription: only pending txs
+// from one of FromAddresses or to one of ToAddresses are sent (either matches if both are set).
+type AlchemyTxFilter struct {
+	FromAddresses []string
+	ToAddresses   []string
+}
+
+// alchemySubscriptionParams are the parameters of alchemy_pendingTransactions (full txs, not hashes only)
+type alchemySubscriptionParams struct {
+	FromAddress []string `json:"fromAddress,omitempty"`
+	ToAddress   []string `json:"toAddress,omitempty"`
+	HashesOnly  bool     `json:"hashesOnly"`
+}
+
+// Validate checks that the filter has at least one address, and that all are hex addresses
+func (f *AlchemyTxFilter) Validate() error {
+	if len(f.FromAddresses) == 0 && len(f.ToAddresses) == 0 {
+		return fmt.Errorf("%w: no addresses", ErrInvalidAlchemyFilter)
+	}
+	for _, addr := range append(append([]string{}, f.FromAddresses...), f.ToAddresses...) {
+		if !ethcommon.IsHexAddress(addr) {
+			return fmt.Errorf("%w: %s", ErrInvalidAlchemyFilter, addr)
+		}
+	}
+	return nil
+}
+
+func (f *AlchemyTxFilter) subscriptionParams() alchemySubscriptionParams {
+	return alchemySubscriptionParams{FromAddress: f.FromAddresses, ToAddress: f.ToAddresses, HashesOnly: false}
+}
+
+// addressFilter applies an AlchemyTxFilter to received txs, if the provider rejected the filtered subscription and the
+// connection fell back to the unfiltered one. Matching the sender costs an ECDSA recovery per tx.
+type addressFilter struct {
+	from map[ethcommon.Address]bool
+	to   map[ethcommon.Address]bool
+}
+
+func newAddressFilter(f *AlchemyTxFilter) *addressFilter {
+	af := &addressFilter{from: make(map[ethcommon.Address]bool), to: make(map[ethcommon.Address]bool)}
+	for _, addr := range f.FromAddresses {
+		af.from[ethcommon.HexToAddress(strings.TrimSpace(addr))] = true
+	}
+	for _, addr := range f.ToAddresses {
+		af.to[ethcommon.HexToAddress(strings.TrimSpace(addr))] = true
+	}
+	return af
+}
+
+// match returns whether the tx is from or to one of the addresses (always if the filter is nil)
+func (af *addressFilter) match(tx *types.Transaction) bool {
+	if af == nil {
+		return true
+	}
+	if tx.To() != nil && af.to[*tx.To()] {
+		return true
+	}
+	if len(af.from) == 0 {
+		return false
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	return err == nil && af.from[from]
+}
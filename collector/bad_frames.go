@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// badFrameCounter counts messages of a source which can't be decoded into a transaction. The connection is kept
+// open, and the errors are logged at most once per badFrameLogInterval (with the number of bad frames since).
+type badFrameCounter struct {
+	log      *zap.SugaredLogger
+	total    atomic.Uint64
+	sinceLog atomic.Uint64
+	lastLog  atomic.Int64 // unix ms of the last log entry
+}
+
+func newBadFrameCounter(log *zap.SugaredLogger) *badFrameCounter {
+	return &badFrameCounter{log: log} //nolint:exhaustruct
+}
+
+// record counts a bad frame, and logs it if the last log entry is older than badFrameLogInterval
+func (c *badFrameCounter) record(err error, frame []byte) {
+	c.total.Inc()
+	sinceLog := c.sinceLog.Inc()
+
+	now := time.Now().UnixMilli()
+	last := c.lastLog.Load()
+	if now-last < badFrameLogInterval.Milliseconds() || !c.lastLog.CompareAndSwap(last, now) {
+		return
+	}
+
+	if len(frame) > badFrameLogMaxBytes {
+		frame = frame[:badFrameLogMaxBytes]
+	}
+
+	c.sinceLog.Sub(sinceLog)
+	c.log.Errorw("received malformed frames",
+		"error", err,
+		"frame", string(frame),
+		"bad_frames", common.Printer.Sprint(sinceLog),
+		"bad_frames_total", common.Printer.Sprint(c.total.Load()),
+	)
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"bufio"
+	"sync"
+)
+
+// bufferedFile buffers the writes to an output file, instead of a write syscall per line. The buffer is written out
+// by Flush (periodically, see TxProcessorOpts.BufferFlushInterval) and by Close. It's safe for concurrent use, though
+// the processor writes, flushes and closes the bucket files only on its processing loop.
+type bufferedFile struct {
+	lock sync.Mutex
+	f    OutputFile
+	w    *bufio.Writer
+}
+
+func newBufferedFile(f OutputFile) *bufferedFile {
+	return &bufferedFile{f: f, w: bufio.NewWriterSize(f, outputBufferSize)} //nolint:exhaustruct
+}
+
+func (b *bufferedFile) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.w.Write(p)
+}
+
+func (b *bufferedFile) Name() string {
+	return b.f.Name()
+}
+
+// Flush writes the buffered data to the file (through the compressor, if the file is compressed)
+func (b *bufferedFile) Flush() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.w.Flush()
+}
+
+// Close flushes the buffer and closes the file. The file is closed even if the flush fails.
+func (b *bufferedFile) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	err := b.w.Flush()
+	if cerr := b.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,15 @@
+package collector
+
+import "time"
+
+// Clock returns the current time. The TxProcessor uses it for bucket and cache expiry, so tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the default Clock, returning the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
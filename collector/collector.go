@@ -2,44 +2,282 @@
 package collector
 
 import (
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/flashbots/mempool-dumpster/common"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 type CollectorOpts struct {
-	Log                *zap.SugaredLogger
+	Log *zap.SugaredLogger // if nil, a logger is created with LogJSON and LogLevel
+
+	LogJSON  bool          // JSON encoded logs instead of console output
+	LogLevel zapcore.Level // default: info
+
 	UID                string
 	Nodes              []string
 	OutDir             string
 	WriteSourcelog     bool
+	SourcelogFirstOnly bool        // record only the first sighting of a tx by each source in the sourcelog
+	WriteSourceTxs     bool        // record the raw tx once per source (to detect sources altering payloads)
+	WriteTrash         bool        // record txs which are not written to the txs file (i.e. too large)
+	WritePerSource     bool        // write the txs of each source to its own file, without dedup across sources
+	SourcelogTSRes     string      // resolution of the sourcelog timestamps (common.SourcelogTimestampMs/Us/Ns)
+	Origin             string      // appended as column to the txs and sourcelog rows if set (i.e. "<uid>@<hostname>")
+	Region             string      // appended to the origin as "<origin>/<region>" if set, to find the globally first region of txs after merging
+	OnWriteError       string      // policy for failed writes to the output files (OnWriteErrorDrop/Pause/Exit, default: drop)
+	Compression        string      // codec of the bucket files (common.CompressionNone/Gzip/Zstd/Snappy, default: none)
+	FileMode           os.FileMode // permissions of output files, applied exactly (default: 0o600, subject to the umask)
+	DirMode            os.FileMode // permissions of output directories, applied exactly (default: 0o777, subject to the umask)
+	GCSURL             string      // optional gs://<bucket>[/<prefix>] to upload the files of closed buckets to
+	GCSDeleteLocal     bool        // remove local files after a successful upload to GCS
+	TrackReplacements  bool
+	ChainID            int64
+	MaxTxBytes         int
+	VerifySignatures   bool // trash txs whose signature doesn't recover a sender (CPU-costly)
+	ContractCreations  bool // with Selectors: also write contract creations to the txs file (alone: only contract creations)
+	TxsShards          int  // split the txs file of each bucket into this many files by tx hash (0 or 1 = a single file)
+	TxChannelSize      int  // buffer size of the channel from all connections to the processor (default: 100)
+	MaxConnections     int  // maximum number of source connections, Start fails if more sources are configured (0 = unlimited)
+	WriteSignature     bool // add the signature columns y_parity,r,s to the txs file
+	WriteCalldataGas   bool // add the columns calldata_size,intrinsic_gas to the txs file
+	WriteHeader        bool // write a header row with the column names as first line of each new file
+	DailyFiles         bool // write one file per day instead of one per hour
+	ReentryWindow      time.Duration
+	StatsInterval      time.Duration // how often stats are logged (default: 1 min)
+	StatsResetInterval time.Duration // how often the stats counters are reset (0: with every log, negative: never)
+	Warmup             time.Duration // txs received within this window after the start are not counted as first-seen (0 = disabled)
 	BloxrouteAuthToken string
 	ChainboundAPIKey   string
+
+	DisableWSCompression bool // don't negotiate websocket compression with generic nodes and bloxroute
+
+	MaxTxPerSecPerSource float64       // default rate limit of every source without its own limit (0 = unlimited)
+	IdleTimeout          time.Duration // default idle timeout of node, bloxroute and eden sources without their own (0 = disabled)
+	Retention            time.Duration // remove date directories of the output directory whose day ended more than this long ago (0 = keep all)
+	BufferFlushInterval  time.Duration // buffer the writes to the output files and flush them at this interval (0 = unbuffered)
+	DedupWindow          time.Duration // default window of every source without its own to suppress repeated txs of the source (0 = disabled)
+
+	// Selectors only writes calls with one of these function selectors to the txs file, the other txs are trashed (see ParseSelectors)
+	Selectors [][4]byte
+
+	// CompressionPerFile overrides Compression per file category (i.e. {"sourcelog": "gzip"}, see ParseCompressionPerFile)
+	CompressionPerFile map[string]string
+
+	// Proxy is the default proxy of node, bloxroute and eden sources without their own (see ParseProxy, default: from the environment)
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// FrameDumps writes the raw frames of node, bloxroute and eden sources to debug files (disabled if Dir is empty)
+	FrameDumps FrameDumpOpts
+
+	TxStreamListenAddr string // if set, newly processed txs are streamed to websocket clients at ws://<addr>/stream
+	ClickHouseDSN      string // if set, newly processed txs and all sightings are inserted into ClickHouse (see ClickHouseSinkOpts)
+
+	// Replay of recorded transactions CSVs as the only source (i.e. for end-to-end tests). The processor runs on the
+	// recorded time, so it can't be combined with other sources.
+	ReplayFiles []string
+	ReplaySpeed float64 // 1 = real time, 0 = as fast as possible
+
+	// Additional sources (i.e. from a sources config file, see LoadSourcesConfig). The logger is set by Start.
+	NodeSources       []NodeOpts
+	BlxSources        []BlxNodeOpts
+	ChainboundSources []ChainboundNodeOpts
 }
 
-// Start kicks off all the service components in the background
-func Start(opts *CollectorOpts) {
-	processor := NewTxProcessor(opts.Log, opts.OutDir, opts.UID, opts.WriteSourcelog)
+// Start kicks off all the service components in the background, and returns the tx processor (i.e. to rotate the files)
+func Start(opts *CollectorOpts) *TxProcessor {
+	if opts.Log == nil {
+		opts.Log = common.NewLogger(opts.LogJSON, opts.LogLevel, os.Stdout)
+	}
+
+	// all source connections run through the connection manager, which bounds their number and shares the dialer
+	connManager := newConnManager(opts.MaxConnections)
+	if err := connManager.checkLimit(opts.numSources()); err != nil {
+		opts.Log.Fatalw("too many sources", "error", err)
+	}
+
+	// while replaying, the buckets and caches of the processor expire on the recorded time
+	var clock Clock
+	var replayClock *ReplayClock
+	if len(opts.ReplayFiles) > 0 {
+		if opts.numSources() > 0 {
+			opts.Log.Fatal("replay files can't be combined with other sources, as the processor runs on the recorded time")
+		}
+		replayClock = NewReplayClock(opts.ReplayFiles)
+		clock = replayClock
+	}
+
+	var sinks []Sink
+	if opts.TxStreamListenAddr != "" {
+		txStream := NewTxStream(opts.Log, opts.TxStreamListenAddr)
+		sinks = append(sinks, txStream)
+		go func() {
+			if err := txStream.Start(); err != nil {
+				opts.Log.Fatalw("tx stream server failed", "error", err)
+			}
+		}()
+	}
+	if opts.ClickHouseDSN != "" {
+		clickHouseSink, err := NewClickHouseSink(ClickHouseSinkOpts{Log: opts.Log, DSN: opts.ClickHouseDSN}) //nolint:exhaustruct
+		if err != nil {
+			opts.Log.Fatalw("failed to create ClickHouse sink", "error", err)
+		}
+		sinks = append(sinks, clickHouseSink)
+	}
+
+	// the region is recorded as suffix of the origin (see common.RegionOfOrigin)
+	origin := opts.Origin
+	if opts.Region != "" {
+		origin += "/" + opts.Region
+	}
+
+	processor := NewTxProcessor(TxProcessorOpts{
+		Log:               opts.Log,
+		OutDir:            opts.OutDir,
+		UID:               opts.UID,
+		WriteSourcelog:    opts.WriteSourcelog,
+		WriteSourceTxs:    opts.WriteSourceTxs,
+		WriteTrash:        opts.WriteTrash,
+		WritePerSource:    opts.WritePerSource,
+		TrackReplacements: opts.TrackReplacements,
+		ChainID:           opts.ChainID,
+		MaxTxBytes:        opts.MaxTxBytes,
+		VerifySignatures:  opts.VerifySignatures,
+		Selectors:         opts.Selectors,
+		ContractCreations: opts.ContractCreations,
+		TxsShards:         opts.TxsShards,
+		Compression:       opts.Compression,
+		WriteHeader:       opts.WriteHeader,
+		DailyFiles:        opts.DailyFiles,
+		Origin:            origin,
+		OnWriteError:      opts.OnWriteError,
+		TxChannelSize:     opts.TxChannelSize,
+		WriteSignature:    opts.WriteSignature,
+		WriteCalldataGas:  opts.WriteCalldataGas,
+		Sinks:             sinks,
+		ReentryWindow:     opts.ReentryWindow,
+
+		SourcelogTimestampResolution: opts.SourcelogTSRes,
+		SourcelogFirstOnly:           opts.SourcelogFirstOnly,
+		StatsInterval:                opts.StatsInterval,
+		StatsResetInterval:           opts.StatsResetInterval,
+		Warmup:                       opts.Warmup,
+		FileMode:                     opts.FileMode,
+		DirMode:                      opts.DirMode,
+		GCSURL:                       opts.GCSURL,
+		GCSDeleteLocal:               opts.GCSDeleteLocal,
+		CompressionPerFile:           opts.CompressionPerFile,
+		BufferFlushInterval:          opts.BufferFlushInterval,
+		Retention:                    opts.Retention,
+		Clock:                        clock,
+	})
 	go processor.Start()
 
+	// generic nodes
+	nodeSources := make([]NodeOpts, 0, len(opts.Nodes)+len(opts.NodeSources))
 	for _, node := range opts.Nodes {
-		conn := NewNodeConnection(opts.Log, node, processor.txC)
-		go conn.Start()
+		nodeSources = append(nodeSources, NodeOpts{URI: node}) //nolint:exhaustruct
+	}
+	nodeSources = append(nodeSources, opts.NodeSources...)
+	for _, nodeOpts := range nodeSources {
+		nodeOpts.Log = opts.Log
+		nodeOpts.DisableCompression = opts.DisableWSCompression
+		if nodeOpts.MaxTxPerSec == 0 {
+			nodeOpts.MaxTxPerSec = opts.MaxTxPerSecPerSource
+		}
+		if nodeOpts.IdleTimeout == 0 {
+			nodeOpts.IdleTimeout = opts.IdleTimeout
+		}
+		if nodeOpts.DedupWindow == 0 {
+			nodeOpts.DedupWindow = opts.DedupWindow
+		}
+		if nodeOpts.Proxy == nil {
+			nodeOpts.Proxy = opts.Proxy
+		}
+		conn := NewNodeConnection(nodeOpts, processor.txC)
+		conn.rxBytes = processor.rxBytes.counter(conn.src.Name)
+		conn.frames = mustFrameDumper(opts, conn.src.Name)
+		conn.netDialer = connManager.netDialer
+		connManager.run(conn.Start)
 	}
 
+	// bloxroute and eden
+	blxSources := make([]BlxNodeOpts, 0, len(opts.BlxSources)+1)
 	if opts.BloxrouteAuthToken != "" {
-		blxOpts := BlxNodeOpts{ //nolint:exhaustruct
-			Log:        opts.Log,
-			AuthHeader: opts.BloxrouteAuthToken,
+		blxSources = append(blxSources, BlxNodeOpts{AuthHeader: opts.BloxrouteAuthToken}) //nolint:exhaustruct
+	}
+	blxSources = append(blxSources, opts.BlxSources...)
+	for _, blxOpts := range blxSources {
+		blxOpts.Log = opts.Log
+		blxOpts.DisableCompression = opts.DisableWSCompression
+		if blxOpts.MaxTxPerSec == 0 {
+			blxOpts.MaxTxPerSec = opts.MaxTxPerSecPerSource
+		}
+		if blxOpts.IdleTimeout == 0 {
+			blxOpts.IdleTimeout = opts.IdleTimeout
+		}
+		if blxOpts.DedupWindow == 0 {
+			blxOpts.DedupWindow = opts.DedupWindow
+		}
+		if blxOpts.Proxy == nil {
+			blxOpts.Proxy = opts.Proxy
 		}
 		blxConn := NewBlxNodeConnection(blxOpts, processor.txC)
-		go blxConn.Start()
+		blxConn.rxBytes = processor.rxBytes.counter(blxConn.src.Name)
+		blxConn.frames = mustFrameDumper(opts, blxConn.src.Name)
+		blxConn.netDialer = connManager.netDialer
+		connManager.run(blxConn.Start)
 	}
 
+	// chainbound
+	chainboundSources := make([]ChainboundNodeOpts, 0, len(opts.ChainboundSources)+1)
 	if opts.ChainboundAPIKey != "" {
-		opts := ChainboundNodeOpts{ //nolint:exhaustruct
-			Log:    opts.Log,
-			APIKey: opts.ChainboundAPIKey,
+		chainboundSources = append(chainboundSources, ChainboundNodeOpts{APIKey: opts.ChainboundAPIKey}) //nolint:exhaustruct
+	}
+	chainboundSources = append(chainboundSources, opts.ChainboundSources...)
+	for _, chainboundOpts := range chainboundSources {
+		chainboundOpts.Log = opts.Log
+		if chainboundOpts.MaxTxPerSec == 0 {
+			chainboundOpts.MaxTxPerSec = opts.MaxTxPerSecPerSource
+		}
+		if chainboundOpts.DedupWindow == 0 {
+			chainboundOpts.DedupWindow = opts.DedupWindow
 		}
-		chainboundConn := NewChainboundNodeConnection(opts, processor.txC)
-		go chainboundConn.Start()
+		chainboundConn := NewChainboundNodeConnection(chainboundOpts, processor.txC)
+		chainboundConn.rxBytes = processor.rxBytes.counter(chainboundConn.src.Name)
+		connManager.run(chainboundConn.Start)
+	}
+
+	// replay of recorded files
+	if len(opts.ReplayFiles) > 0 {
+		replay := NewReplay(ReplayOpts{Log: opts.Log, Files: opts.ReplayFiles, Speed: opts.ReplaySpeed, Clock: replayClock}, processor.txC) //nolint:exhaustruct
+		go replay.Start()
+	}
+
+	return processor
+}
+
+// numSources returns the number of configured source connections (node, bloxroute, eden and chainbound, not the replay)
+func (opts *CollectorOpts) numSources() int {
+	n := len(opts.Nodes) + len(opts.NodeSources) + len(opts.BlxSources) + len(opts.ChainboundSources)
+	if opts.BloxrouteAuthToken != "" {
+		n++
+	}
+	if opts.ChainboundAPIKey != "" {
+		n++
+	}
+	return n
+}
+
+// mustFrameDumper returns the raw frame dumper of a source (nil if not enabled for it), and exits if its file can't be opened
+func mustFrameDumper(opts *CollectorOpts, src string) *frameDumper {
+	frames, err := newFrameDumper(opts.Log.With("src", src), opts.FrameDumps, src, opts.UID)
+	if err != nil {
+		opts.Log.Fatalw("failed to open the raw frame dump file", "src", src, "error", err)
 	}
+	return frames
 }
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+var ErrInvalidCompression = errors.New("invalid compression")
+
+// OutputFile is an output file of a bucket, written either directly or through a compressor
+type OutputFile interface {
+	io.WriteCloser
+	Name() string
+}
+
+// compressedFile writes through a compressor into the underlying file. Close flushes the compressor (writing the
+// final block and footer) before closing the file, so a closed file is always complete. Appending to an existing file
+// (i.e. a bucket reopened after it was closed) adds a new gzip member, zstd frame or snappy stream, which readers
+// decode as concatenation.
+type compressedFile struct {
+	f  *os.File
+	zw io.WriteCloser
+}
+
+func (c *compressedFile) Write(p []byte) (int, error) {
+	return c.zw.Write(p)
+}
+
+func (c *compressedFile) Name() string {
+	return c.f.Name()
+}
+
+func (c *compressedFile) Close() error {
+	err := c.zw.Close()
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ValidateCompression checks that the codec is one of common.CompressionNone/Gzip/Zstd/Snappy (or empty, for none)
+func ValidateCompression(codec string) error {
+	switch codec {
+	case "", common.CompressionNone, common.CompressionGzip, common.CompressionZstd, common.CompressionSnappy:
+		return nil
+	}
+	return fmt.Errorf("%w: %s (none, gzip, zstd or snappy)", ErrInvalidCompression, codec)
+}
+
+// ParseCompressionPerFile parses per-category codecs in the format <subDir>=<codec>[,...], i.e. "sourcelog=gzip". The
+// categories are the subdirectories of a collector date directory (see common.ArchiveSubDirs).
+func ParseCompressionPerFile(s string) (map[string]string, error) {
+	codecs := make(map[string]string)
+	if s == "" {
+		return codecs, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		subDir, codec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s (format: <category>=<codec>)", ErrInvalidCompression, entry)
+		}
+		if !slices.Contains(common.ArchiveSubDirs, subDir) {
+			return nil, fmt.Errorf("%w: unknown category %s (%s)", ErrInvalidCompression, subDir, strings.Join(common.ArchiveSubDirs, ", "))
+		}
+		if err := ValidateCompression(codec); err != nil {
+			return nil, err
+		}
+		codecs[subDir] = codec
+	}
+	return codecs, nil
+}
+
+// newOutputFile wraps the file with a compressor for the codec, or returns it as is if uncompressed
+func newOutputFile(f *os.File, codec string) (OutputFile, error) {
+	var zw io.WriteCloser
+	switch codec {
+	case "", common.CompressionNone:
+		return f, nil
+	case common.CompressionGzip:
+		zw = gzip.NewWriter(f)
+	case common.CompressionZstd:
+		enc, err := zstd.NewWriter(f)
+		if err != nil {
+			return nil, err
+		}
+		zw = enc
+	case common.CompressionSnappy:
+		zw = s2.NewWriter(f, s2.WriterSnappyCompat())
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCompression, codec)
+	}
+	return &compressedFile{f, zw}, nil
+}
@@ -0,0 +1,199 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidSourceConfig = errors.New("invalid source config")
+
+// Source types in the sources config file
+const (
+	SourceTypeNode       = "node"
+	SourceTypeBloxroute  = "bloxroute"
+	SourceTypeEden       = "eden"
+	SourceTypeChainbound = "chainbound"
+)
+
+// SourceConfig describes a single mempool source in the sources config file
+type SourceConfig struct {
+	Type    string `yaml:"type"`    // node, bloxroute, eden or chainbound
+	URL     string `yaml:"url"`     // node URI, or endpoint override for the other types (required for eden)
+	Token   string `yaml:"token"`   // auth header or API key
+	Label   string `yaml:"label"`   // optional source tag override
+	Enabled *bool  `yaml:"enabled"` // optional, default: true
+
+	MaxTxPerSec float64       `yaml:"max_tx_per_sec"` // optional rate limit (default: -max-tx-per-sec)
+	IdleTimeout time.Duration `yaml:"idle_timeout"`   // optional, i.e. "1m": reconnect without messages for this long (default: -idle-timeout, not for chainbound)
+	DedupWindow time.Duration `yaml:"dedup_window"`   // optional, i.e. "2s": suppress repeats of a tx by this source within the window (default: -source-dedup-window)
+	Feed        string        `yaml:"feed"`           // optional bloxroute stream: newTxs (default) or pendingTxs
+
+	// optional URLs of bloxroute or eden to fail over to (in order) if the connection fails or stalls
+	FailoverURLs []string `yaml:"failover_urls"`
+
+	// optional http://, https:// or socks5:// proxy, or "direct" to bypass the proxy of the environment (node, bloxroute and eden)
+	Proxy string `yaml:"proxy"`
+
+	// optional HTTP headers of the websocket handshake, sent on every (re)connect, i.e. an API key (node)
+	Headers map[string]string `yaml:"headers"`
+
+	// optional server-side filter of alchemy nodes: only pending txs from or to these addresses (see AlchemyTxFilter)
+	FromAddresses []string `yaml:"from_addresses"`
+	ToAddresses   []string `yaml:"to_addresses"`
+
+	// optional client certificate and key for mutual TLS, and CA certificate to verify the server (node, bloxroute and eden)
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+	TLSCA   string `yaml:"tls_ca"`
+}
+
+// SourcesConfig is the content of a sources config file (YAML or JSON), i.e.:
+//
+//	sources:
+//	  - type: node
+//	    url: ws://localhost:8546
+//	    label: local
+//	  - type: bloxroute
+//	    token: ${BLX_AUTH_HEADER}
+type SourcesConfig struct {
+	Sources []SourceConfig `yaml:"sources"`
+}
+
+// LoadSourcesConfig reads a sources config file. Environment variables (${VAR}) are expanded, so secrets don't have to be stored in the file.
+func LoadSourcesConfig(fn string) (*SourcesConfig, error) {
+	content, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(SourcesConfig)
+	err = yaml.Unmarshal([]byte(os.ExpandEnv(string(content))), config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ApplyTo adds all enabled sources to the collector options
+func (c *SourcesConfig) ApplyTo(opts *CollectorOpts) error {
+	for i, src := range c.Sources {
+		if src.Enabled != nil && !*src.Enabled {
+			continue
+		}
+
+		tlsConfig, err := LoadTLSConfig(src.TLSCert, src.TLSKey, src.TLSCA)
+		if err != nil {
+			return fmt.Errorf("%w: source %d: %w", ErrInvalidSourceConfig, i, err)
+		}
+		if tlsConfig != nil && src.Type == SourceTypeChainbound {
+			return fmt.Errorf("%w: source %d: TLS settings are not supported for chainbound", ErrInvalidSourceConfig, i)
+		}
+
+		proxy, err := ParseProxy(src.Proxy)
+		if err != nil {
+			return fmt.Errorf("%w: source %d: %w", ErrInvalidSourceConfig, i, err)
+		}
+		if proxy != nil && src.Type == SourceTypeChainbound {
+			return fmt.Errorf("%w: source %d: proxy is not supported for chainbound (gRPC uses the proxy of the environment)", ErrInvalidSourceConfig, i)
+		}
+
+		if len(src.FailoverURLs) > 0 && src.Type != SourceTypeBloxroute && src.Type != SourceTypeEden {
+			return fmt.Errorf("%w: source %d: failover_urls are only supported for bloxroute and eden", ErrInvalidSourceConfig, i)
+		}
+		if src.IdleTimeout != 0 && src.Type == SourceTypeChainbound {
+			return fmt.Errorf("%w: source %d: idle_timeout is not supported for chainbound", ErrInvalidSourceConfig, i)
+		}
+		if len(src.Headers) > 0 && src.Type != SourceTypeNode {
+			return fmt.Errorf("%w: source %d: headers are only supported for node (use token for the others)", ErrInvalidSourceConfig, i)
+		}
+		var alchemyFilter *AlchemyTxFilter
+		if len(src.FromAddresses) > 0 || len(src.ToAddresses) > 0 {
+			if src.Type != SourceTypeNode || !strings.Contains(src.URL, "alchemy.com/") {
+				return fmt.Errorf("%w: source %d: from_addresses and to_addresses are only supported for alchemy nodes", ErrInvalidSourceConfig, i)
+			}
+			alchemyFilter = &AlchemyTxFilter{FromAddresses: src.FromAddresses, ToAddresses: src.ToAddresses}
+			if err = alchemyFilter.Validate(); err != nil {
+				return fmt.Errorf("%w: source %d: %w", ErrInvalidSourceConfig, i, err)
+			}
+		}
+		if src.Feed != "" && src.Type != SourceTypeBloxroute {
+			return fmt.Errorf("%w: source %d: feed is only supported for bloxroute", ErrInvalidSourceConfig, i)
+		}
+		if src.Feed != "" && src.Feed != BlxFeedNewTxs && src.Feed != BlxFeedPendingTxs {
+			return fmt.Errorf("%w: source %d: unknown bloxroute feed '%s'", ErrInvalidSourceConfig, i, src.Feed)
+		}
+
+		switch src.Type {
+		case SourceTypeNode:
+			if src.URL == "" {
+				return fmt.Errorf("%w: source %d: missing url", ErrInvalidSourceConfig, i)
+			}
+			opts.NodeSources = append(opts.NodeSources, NodeOpts{ //nolint:exhaustruct
+				URI:         src.URL,
+				SourceTag:   src.Label,
+				MaxTxPerSec: src.MaxTxPerSec,
+				IdleTimeout: src.IdleTimeout,
+				DedupWindow: src.DedupWindow,
+				TLSConfig:   tlsConfig,
+				Proxy:       proxy,
+				Headers:     httpHeaders(src.Headers),
+
+				AlchemyFilter: alchemyFilter,
+			})
+		case SourceTypeBloxroute, SourceTypeEden:
+			if src.Token == "" {
+				return fmt.Errorf("%w: source %d: missing token", ErrInvalidSourceConfig, i)
+			}
+			if src.Type == SourceTypeEden && src.URL == "" {
+				return fmt.Errorf("%w: source %d: missing url", ErrInvalidSourceConfig, i)
+			}
+			opts.BlxSources = append(opts.BlxSources, BlxNodeOpts{ //nolint:exhaustruct
+				AuthHeader: src.Token,
+				IsEden:     src.Type == SourceTypeEden,
+				URL:        src.URL,
+				SourceTag:  src.Label,
+				Feed:       src.Feed,
+
+				FailoverURLs: src.FailoverURLs,
+
+				MaxTxPerSec: src.MaxTxPerSec,
+				IdleTimeout: src.IdleTimeout,
+				DedupWindow: src.DedupWindow,
+				TLSConfig:   tlsConfig,
+				Proxy:       proxy,
+			})
+		case SourceTypeChainbound:
+			if src.Token == "" {
+				return fmt.Errorf("%w: source %d: missing token", ErrInvalidSourceConfig, i)
+			}
+			opts.ChainboundSources = append(opts.ChainboundSources, ChainboundNodeOpts{ //nolint:exhaustruct
+				APIKey:      src.Token,
+				URL:         src.URL,
+				SourceTag:   src.Label,
+				MaxTxPerSec: src.MaxTxPerSec,
+				DedupWindow: src.DedupWindow,
+			})
+		default:
+			return fmt.Errorf("%w: source %d: unknown type '%s'", ErrInvalidSourceConfig, i, src.Type)
+		}
+	}
+	return nil
+}
+
+// httpHeaders converts the headers of a source config into canonical HTTP headers (nil if there are none)
+func httpHeaders(headers map[string]string) http.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+	h := make(http.Header, len(headers))
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return h
+}
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var ErrTooManyConnections = errors.New("more sources than the connection limit")
+
+// connManager bounds the number of source connections, and provides the network dialer shared by all websocket
+// connections (node, bloxroute and eden), so they have the same TCP dial timeout and keepalive. Connections reconnect
+// forever and never free their slot, so the limit is checked once at startup for all sources (see checkLimit), instead
+// of having the sources beyond it wait forever. Chainbound connections use their own gRPC transport, and only count
+// against the limit.
+type connManager struct {
+	maxConns  int // 0 if unlimited
+	netDialer *net.Dialer
+}
+
+func newConnManager(maxConns int) *connManager {
+	return &connManager{
+		maxConns: maxConns,
+		netDialer: &net.Dialer{ //nolint:exhaustruct
+			Timeout:   connDialTimeout,
+			KeepAlive: connKeepAlive,
+		},
+	}
+}
+
+// checkLimit returns ErrTooManyConnections if more sources are configured than the connection limit
+func (m *connManager) checkLimit(nSources int) error {
+	if m.maxConns > 0 && nSources > m.maxConns {
+		return fmt.Errorf("%w: %d sources, max %d connections", ErrTooManyConnections, nSources, m.maxConns)
+	}
+	return nil
+}
+
+// run starts the source connection in the background (its start function blocks for the lifetime of the process)
+func (m *connManager) run(start func()) {
+	go start()
+}
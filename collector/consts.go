@@ -13,6 +13,67 @@ const (
 	// bucketMinutes is the number of minutes to write into each CSV file (i.e. new file created for every X minutes bucket)
 	bucketMinutes = 60
 
+	// bucketMinutesDaily is the bucket size with TxProcessorOpts.DailyFiles (one file per day)
+	bucketMinutesDaily = 24 * 60
+
+	// bucketCloseDelay is how long after the end of a bucket its files are closed (so late txs, i.e. queued in the
+	// channel, still go into the right file)
+	bucketCloseDelay = time.Hour
+
+	// defaultStatsInterval is how often the processor logs stats and cleans up its caches, if not configured
+	defaultStatsInterval = time.Minute
+
+	// defaultTxChannelSize is the buffer size of the channel from the connections to the processor, if not configured
+	defaultTxChannelSize = 100
+
+	// defaultFileMode is the permissions of output files, if not configured
+	defaultFileMode = 0o600
+
+	// outputBufferSize is the write buffer size of each output file with TxProcessorOpts.BufferFlushInterval
+	outputBufferSize = 64 << 10
+
+	// defaultFrameDumpMaxBytes is the size limit of the raw frame dump file of a source, if not configured
+	defaultFrameDumpMaxBytes = 100 << 20
+
+	// defaultChainID is used for sender recovery if no chain ID is configured (mainnet)
+	defaultChainID = 1
+
+	// node URI prefix to subscribe only to pending tx hashes, and fetch the transactions by hash
+	hashSubscriptionPrefix = "hashes+"
+
+	// hash subscription mode: number of concurrent tx fetches, queue size and timeout per fetch
+	hashFetchWorkers = 10
+	hashQueueSize    = 1000
+	hashFetchTimeout = 5 * time.Second
+
+	// malformed frames of a source are logged at most once per interval, with a sample of at most this many bytes
+	badFrameLogInterval = time.Minute
+	badFrameLogMaxBytes = 200
+
+	// ClickHouse sink: rows per table which trigger an insert before the flush interval, and timeout of an insert
+	defaultClickHouseBatchSize     = 10_000
+	defaultClickHouseFlushInterval = time.Second
+	clickHouseTimeout              = 30 * time.Second
+
+	// the live latency leaderboard counts a tx if another source receives it within this window after the first one
+	leaderboardWindow = time.Minute
+
+	// txs dropped by the per-source rate limit are logged at most once per interval
+	rateLimitLogInterval = time.Minute
+
+	// per-source dedup (DedupWindow): maximum number of cached hashes per source, and suppressions are logged at most once per interval
+	sourceDedupMaxHashes   = 10_000
+	sourceDedupLogInterval = time.Minute
+
+	// connection manager: TCP dial timeout and keepalive interval of all websocket connections
+	connDialTimeout = 30 * time.Second
+	connKeepAlive   = 30 * time.Second
+
+	// bloxroute failover: a connection without messages for this long is considered stalled (with failover URLs and
+	// no configured idle timeout), and the primary URL is retried after this long on a failover URL
+	blxIdleTimeout      = 30 * time.Second
+	blxFailbackInterval = 10 * time.Minute
+
 	// exponential backoff settings
 	initialBackoffSec = 5
 	maxBackoffSec     = 120
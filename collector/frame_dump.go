@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// FrameDumpOpts configures the debug dump of raw frames, as received from the sources before decoding (i.e. to
+// diagnose encoding quirks of a provider). It's verbose, so it's disabled by default.
+type FrameDumpOpts struct {
+	Dir      string          // directory of the dump files (disabled if empty)
+	Sources  map[string]bool // names of the sources to dump (empty = all)
+	Sample   int             // dump every Nth frame (0 or 1 = every frame)
+	MaxBytes int64           // stop dumping a source once its file reaches this size (default: defaultFrameDumpMaxBytes)
+}
+
+// frameDumper writes the raw frames of a single source as JSON lines ({"timestamp_ms":..,"source":..,"frame":..})
+// to <dir>/frames_<source>_<uid>.jsonl. A nil frameDumper dumps nothing.
+type frameDumper struct {
+	log      *zap.SugaredLogger
+	src      string
+	sample   uint64
+	maxBytes int64
+
+	lock    sync.Mutex
+	f       *os.File // nil once the size limit is reached
+	cnt     uint64
+	written int64
+}
+
+type dumpedFrame struct {
+	TimestampMs int64  `json:"timestamp_ms"`
+	Source      string `json:"source"`
+	Frame       string `json:"frame"`
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// newFrameDumper returns the dumper of a source, or nil if dumping is disabled or not enabled for the source
+func newFrameDumper(log *zap.SugaredLogger, opts FrameDumpOpts, src, uid string) (*frameDumper, error) {
+	if opts.Dir == "" || (len(opts.Sources) > 0 && !opts.Sources[src]) {
+		return nil, nil //nolint:nilnil // disabled
+	}
+
+	if err := os.MkdirAll(opts.Dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	fn := filepath.Join(opts.Dir, fmt.Sprintf("frames_%s_%s.jsonl", unsafeFilenameChars.ReplaceAllString(src, "_"), uid))
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	sample := uint64(1)
+	if opts.Sample > 1 {
+		sample = uint64(opts.Sample)
+	}
+	maxBytes := opts.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultFrameDumpMaxBytes
+	}
+
+	log.Warnw("dumping raw frames of the source (debug)", "file", fn, "sample", sample, "max_bytes", maxBytes)
+	return &frameDumper{ //nolint:exhaustruct
+		log:      log,
+		src:      src,
+		sample:   sample,
+		maxBytes: maxBytes,
+		f:        f,
+	}, nil
+}
+
+// record writes the frame, if it's sampled and the size limit isn't reached yet
+func (d *frameDumper) record(t time.Time, frame []byte) {
+	if d == nil {
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.cnt++
+	if d.f == nil || (d.cnt-1)%d.sample != 0 {
+		return
+	}
+
+	line, err := json.Marshal(dumpedFrame{t.UnixMilli(), d.src, string(frame)})
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	n, err := d.f.Write(line)
+	d.written += int64(n)
+	if err != nil || d.written >= d.maxBytes {
+		d.log.Warnw("stopped dumping raw frames of the source", "file", d.f.Name(), "bytes", d.written, "error", err)
+		_ = d.f.Close()
+		d.f = nil
+	}
+}
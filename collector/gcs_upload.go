@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidGCSURL = errors.New("invalid GCS URL (must be gs://<bucket>[/<prefix>])")
+
+// ValidateGCSURL checks that the upload target is a gs://<bucket>[/<prefix>] URL
+func ValidateGCSURL(gcsURL string) error {
+	bucket := strings.Split(strings.TrimPrefix(gcsURL, "gs://"), "/")[0]
+	if !strings.HasPrefix(gcsURL, "gs://") || bucket == "" {
+		return fmt.Errorf("%w: %s", ErrInvalidGCSURL, gcsURL)
+	}
+	return nil
+}
+
+// uploadToGCS uploads a closed output file to Google Cloud Storage, at the same path relative to the output directory
+// (i.e. gs://bucket/prefix/2023-08-07/transactions/txs_2023-08-07_10-00_uid.csv). It uses gsutil, which needs to be
+// installed and authenticated (i.e. with a service account). The local file is deleted after a successful upload,
+// if configured.
+func (p *TxProcessor) uploadToGCS(fn string) {
+	target, err := p.gcsObjectURL(fn)
+	if err != nil {
+		p.log.Errorw("failed to get relative path for upload", "filename", fn, "error", err)
+		return
+	}
+
+	cmd := exec.Command(p.gsutil, "-q", "cp", fn, target) //nolint:gosec
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		p.log.Errorw("failed to upload file to GCS", "filename", fn, "target", target, "error", err, "output", string(output))
+		return
+	}
+	p.log.Infow("uploaded file to GCS", "filename", fn, "target", target)
+
+	if p.gcsDeleteLocal {
+		if err := os.Remove(fn); err != nil {
+			p.log.Errorw("failed to remove uploaded file", "filename", fn, "error", err)
+		}
+	}
+}
+
+// gcsObjectURL returns the upload target of an output file: its path relative to the output directory, below the GCS URL
+func (p *TxProcessor) gcsObjectURL(fn string) (string, error) {
+	relPath, err := filepath.Rel(p.outDir, fn)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(p.gcsURL, "/") + "/" + filepath.ToSlash(relPath), nil
+}
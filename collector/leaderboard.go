@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/mempool-dumpster/common"
+	"go.uber.org/zap"
+)
+
+// latencyLeaderboard is a lightweight online version of the analyzer's latency comparison: it counts which source
+// received txs first, for txs which were also received by another source within leaderboardWindow, and by how much
+// the first source was ahead of the second one.
+type latencyLeaderboard struct {
+	lock   sync.Mutex
+	recent map[ethcommon.Hash]firstSighting
+	wins   map[string]uint64 // [src] = number of multi-source txs received first
+	leadMs map[string]int64  // [src] = sum of the lead over the second source (ms)
+}
+
+type firstSighting struct {
+	src         string
+	t           time.Time
+	seenByOther bool
+}
+
+func newLatencyLeaderboard() *latencyLeaderboard {
+	return &latencyLeaderboard{ //nolint:exhaustruct
+		recent: make(map[ethcommon.Hash]firstSighting),
+		wins:   make(map[string]uint64),
+		leadMs: make(map[string]int64),
+	}
+}
+
+// first records the first sighting of a tx
+func (l *latencyLeaderboard) first(hash ethcommon.Hash, src string, t time.Time) {
+	l.lock.Lock()
+	l.recent[hash] = firstSighting{src: src, t: t, seenByOther: false}
+	l.lock.Unlock()
+}
+
+// later records a later sighting of a tx. The first source wins if this is the first sighting by another source.
+func (l *latencyLeaderboard) later(hash ethcommon.Hash, src string, t time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	first, ok := l.recent[hash]
+	if !ok || first.seenByOther || first.src == src {
+		return
+	}
+
+	first.seenByOther = true
+	l.recent[hash] = first
+	l.wins[first.src]++
+	l.leadMs[first.src] += t.Sub(first.t).Milliseconds()
+}
+
+// logAndReset logs the standings since the last reset (sorted by wins), resets them if reset is set, and removes sightings older than now - leaderboardWindow
+func (l *latencyLeaderboard) logAndReset(log *zap.SugaredLogger, now time.Time, reset bool) {
+	l.lock.Lock()
+	for hash, first := range l.recent {
+		if now.Sub(first.t) > leaderboardWindow {
+			delete(l.recent, hash)
+		}
+	}
+
+	// ranked by wins, and alphabetically for equal wins
+	sources := sortedSources(l.wins)
+	total := uint64(0)
+	for _, wins := range l.wins {
+		total += wins
+	}
+	sort.SliceStable(sources, func(i, j int) bool { return l.wins[sources[i]] > l.wins[sources[j]] })
+
+	leaderboardLog := log.With("multi_source_txs", common.Printer.Sprint(total))
+	for i, src := range sources {
+		avgLeadMs := l.leadMs[src] / int64(l.wins[src])
+		leaderboardLog = leaderboardLog.With(src, common.Printer.Sprintf("#%d: %d first (%s), avg lead %d ms", i+1, l.wins[src], common.Int64DiffPercentFmt(int64(l.wins[src]), int64(total)), avgLeadMs))
+	}
+
+	if reset {
+		l.wins = make(map[string]uint64)
+		l.leadMs = make(map[string]int64)
+	}
+	l.lock.Unlock()
+
+	leaderboardLog.Info("latency_leaderboard")
+}
@@ -2,103 +2,408 @@ package collector
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/ethclient/gethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/gorilla/websocket"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
+type NodeOpts struct {
+	Log                *zap.SugaredLogger
+	URI                string      // prefix with "hashes+" to subscribe only to tx hashes and fetch the txs by hash (hosted providers like Infura)
+	DisableCompression bool        // disable websocket permessage-deflate compression (by default it's negotiated with the server)
+	SourceTag          string      // optional override, default: common.TxSourcName(URI)
+	MaxTxPerSec        float64     // limit of txs per second from this source, excess txs are dropped and counted (0 = unlimited)
+	TLSConfig          *tls.Config // optional, i.e. with a client certificate for mutual TLS (see LoadTLSConfig)
+
+	// Proxy is optional, default: from the environment (see ParseProxy)
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Headers are optional HTTP headers sent with the websocket handshake (and HTTP requests) on every (re)connect,
+	// i.e. an API key or bearer token of a hosted endpoint
+	Headers http.Header
+
+	// IdleTimeout reconnects if no message is received for this long, to recover "connected but dead" sockets (0 = disabled)
+	IdleTimeout time.Duration
+
+	// AlchemyFilter subscribes only to the pending txs from or to these addresses (optional, Alchemy only), filtered by
+	// the provider to save bandwidth. If the provider rejects the filtered subscription, the connection falls back to
+	// the unfiltered one, and applies the filter to the received txs.
+	AlchemyFilter *AlchemyTxFilter
+
+	// DedupWindow suppresses repeats of a tx by this source within the window before they reach the processor (0 = disabled)
+	DedupWindow time.Duration
+}
+
 type NodeConnection struct {
-	log       *zap.SugaredLogger
-	uri       string
-	uriTag    string // identifier of tx source (i.e. "infura", "alchemy", "ws://localhost:8546")
-	txC       chan TxIn
-	isAlchemy bool
+	log            *zap.SugaredLogger
+	uri            string
+	src            common.Source // name of the tx source, i.e. "infura", "alchemy", "ws://localhost:8546"
+	txC            chan TxIn
+	isAlchemy      bool
+	useCompression bool
+	badFrames      *badFrameCounter
+	limiter        *rateLimiter // nil if unlimited
+	dedup          *sourceDedup // nil if disabled
+	tlsConfig      *tls.Config
+	proxy          func(*http.Request) (*url.URL, error)
+	headers        http.Header
+	rxBytes        *atomic.Uint64 // optional, counts the bytes of received messages
+	frames         *frameDumper   // optional, dumps the received messages (debug)
+	netDialer      *net.Dialer    // optional, shared by the connections of the collector (see connManager)
+	idleTimeout    time.Duration  // 0 if disabled
+
+	alchemyFilter *AlchemyTxFilter // nil if unfiltered, or after falling back to the unfiltered subscription
+	clientFilter  *addressFilter   // set after falling back to the unfiltered subscription, nil otherwise
+
+	// hash subscription mode: pending tx hashes are received on hashC, and fetched by the workers from hashQueue.
+	// The workers are also started if a node sends hashes on the full tx subscription (i.e. local devnets).
+	subscribeHashes bool
+	hashC           chan ethcommon.Hash
+	hashQueue       chan hashIn
+	hashWorkersOnce sync.Once
+	ethClient       atomic.Pointer[ethclient.Client]
+}
+
+type hashIn struct {
+	t    time.Time // time the hash was received, used as tx timestamp
+	hash ethcommon.Hash
 }
 
-func NewNodeConnection(log *zap.SugaredLogger, nodeURI string, txC chan TxIn) *NodeConnection {
-	srcAlias := common.TxSourcName(nodeURI)
-	return &NodeConnection{
-		log:       log.With("src", srcAlias),
-		uri:       nodeURI,
-		uriTag:    srcAlias,
-		txC:       txC,
-		isAlchemy: strings.Contains(nodeURI, "alchemy.com/"),
+func NewNodeConnection(opts NodeOpts, txC chan TxIn) *NodeConnection {
+	uri, subscribeHashes := strings.CutPrefix(opts.URI, hashSubscriptionPrefix)
+	subscribeHashes = subscribeHashes || strings.Contains(uri, "infura.io/") // infura only sends hashes of pending txs
+
+	srcAlias := opts.SourceTag
+	if srcAlias == "" {
+		srcAlias = common.TxSourcName(uri)
+	}
+
+	log := opts.Log.With("src", srcAlias)
+	nc := &NodeConnection{ //nolint:exhaustruct
+		log:            log,
+		uri:            uri,
+		src:            common.Source{Name: srcAlias, Kind: common.SourceKindPublic, Transport: common.SourceTransportWebsocket},
+		txC:            txC,
+		isAlchemy:      strings.Contains(uri, "alchemy.com/"),
+		useCompression: !opts.DisableCompression,
+		badFrames:      newBadFrameCounter(log),
+		limiter:        newRateLimiter(log, opts.MaxTxPerSec),
+		dedup:          newSourceDedup(log, opts.DedupWindow),
+		tlsConfig:      opts.TLSConfig,
+		proxy:          opts.Proxy,
+		headers:        opts.Headers,
+		idleTimeout:    opts.IdleTimeout,
+		alchemyFilter:  opts.AlchemyFilter,
+
+		subscribeHashes: subscribeHashes,
+		hashQueue:       make(chan hashIn, hashQueueSize),
+	}
+
+	if subscribeHashes {
+		nc.hashC = make(chan ethcommon.Hash)
 	}
+	return nc
 }
 
 func (nc *NodeConnection) Start() {
 	log := nc.log.With("uri", nc.uri)
-	txC := make(chan *types.Transaction)
 
-	sub, err := nc.connect(txC)
+	// txs are received undecoded, because a message failing to decode would end the subscription
+	txC := make(chan json.RawMessage)
+
+	// hash subscription mode: start the workers fetching the full transactions
+	if nc.subscribeHashes {
+		nc.hashWorkersOnce.Do(nc.startHashWorkers)
+	}
+
+	rpcClient, sub, err := nc.connect(txC)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
+	// with an idle timeout, the time since the last message is checked periodically
+	var idleTickC <-chan time.Time // nil channel (never ready) if disabled
+	if nc.idleTimeout > 0 {
+		ticker := time.NewTicker(nc.idleTimeout / 2)
+		defer ticker.Stop()
+		idleTickC = ticker.C
+	}
+	lastMsgAt := time.Now()
+
 	for {
 		select {
 		case err := <-sub.Err():
 			log.Errorw("subscription error", "error", err)
-
-			// reconnect
-			for {
-				log.Info("reconnecting...")
-				sub, err = nc.connect(txC)
-				if err == nil {
-					log.Info("reconnected successfully")
-					break
-				}
-				log.Errorw("failed to reconnect, retrying in a few seconds...", "error", err)
-				time.Sleep(5 * time.Second)
+			rpcClient.Close()
+			rpcClient, sub = nc.reconnect(log, txC)
+			lastMsgAt = time.Now()
+		case <-idleTickC:
+			if time.Since(lastMsgAt) < nc.idleTimeout {
+				continue
+			}
+			log.Errorw("connection stalled, reconnecting", "idle_timeout", nc.idleTimeout.String())
+			sub.Unsubscribe()
+			rpcClient.Close()
+			rpcClient, sub = nc.reconnect(log, txC)
+			lastMsgAt = time.Now()
+		case msg := <-txC:
+			t := time.Now().UTC()
+			lastMsgAt = t
+			addRxBytes(nc.rxBytes, len(msg))
+			nc.frames.record(t, msg)
+			tx, hash, err := decodePendingTxMsg(msg)
+			if err != nil {
+				nc.badFrames.record(err, msg)
+				continue
+			}
+			if tx != nil {
+				hash = tx.Hash()
+			}
+			if tx != nil && !nc.clientFilter.match(tx) {
+				continue
+			}
+			if !nc.dedup.allow(hash) || !nc.limiter.allow() {
+				continue
+			}
+			if tx == nil {
+				// the node ignores the full tx parameter (i.e. anvil, hardhat), fetch the txs by hash
+				nc.hashWorkersOnce.Do(func() {
+					log.Warn("node sends tx hashes instead of full transactions, fetching the transactions by hash")
+					nc.startHashWorkers()
+				})
+				nc.hashQueue <- hashIn{t, hash}
+				continue
+			}
+			nc.txC <- TxIn{t, tx, nc.src}
+		case hash := <-nc.hashC: // nil channel (never ready) if not in hash subscription mode
+			lastMsgAt = time.Now()
+			addRxBytes(nc.rxBytes, len(hash))
+			if nc.dedup.allow(hash) && nc.limiter.allow() {
+				nc.hashQueue <- hashIn{lastMsgAt.UTC(), hash}
 			}
-		case tx := <-txC:
-			nc.txC <- TxIn{time.Now().UTC(), tx, nc.uriTag}
 		}
 	}
 }
 
-func (nc *NodeConnection) connect(txC chan *types.Transaction) (*rpc.ClientSubscription, error) {
+// reconnect subscribes again (on a new connection, the previous client must be closed by the caller), retrying until
+// it succeeds
+func (nc *NodeConnection) reconnect(log *zap.SugaredLogger, txC chan json.RawMessage) (*rpc.Client, *rpc.ClientSubscription) {
+	for {
+		log.Info("reconnecting...")
+		rpcClient, sub, err := nc.connect(txC)
+		if err == nil {
+			log.Info("reconnected successfully")
+			return rpcClient, sub
+		}
+		log.Errorw("failed to reconnect, retrying in a few seconds...", "error", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// connect opens a new connection and subscribes, returning the client (to be closed before reconnecting) and the
+// subscription
+func (nc *NodeConnection) connect(txC chan json.RawMessage) (*rpc.Client, *rpc.ClientSubscription, error) {
 	if nc.isAlchemy {
 		return nc.connectAlchemy(txC)
+	} else if nc.subscribeHashes {
+		return nc.connectHashes()
 	} else {
 		return nc.connectGeneric(txC)
 	}
 }
 
-func (nc *NodeConnection) connectGeneric(txC chan *types.Transaction) (*rpc.ClientSubscription, error) {
-	nc.log.Infow("connecting to node...", "uri", nc.uri)
-	rpcClient, err := rpc.Dial(nc.uri)
+// dial opens the RPC connection. If compression is enabled but the server rejects the websocket handshake, it retries
+// once without compression, and keeps it disabled for future reconnects if that succeeds.
+func (nc *NodeConnection) dial() (*rpc.Client, error) {
+	if !nc.useCompression {
+		return nc.dialWith(false)
+	}
+
+	rpcClient, err := nc.dialWith(true)
+	if err == nil || !isBadHandshake(err) {
+		return rpcClient, err
+	}
+
+	nc.log.Warnw("websocket handshake failed, retrying without compression", "error", err)
+	rpcClient, err = nc.dialWith(false)
 	if err != nil {
 		return nil, err
 	}
+	nc.useCompression = false
+	return rpcClient, nil
+}
+
+// dialWith opens the RPC connection, with a custom websocket dialer if compression, TLS, proxy or dialer settings are needed,
+// and the custom headers if set
+func (nc *NodeConnection) dialWith(enableCompression bool) (*rpc.Client, error) {
+	if !enableCompression && nc.tlsConfig == nil && nc.proxy == nil && len(nc.headers) == 0 && nc.netDialer == nil {
+		return rpc.Dial(nc.uri)
+	}
+	opts := []rpc.ClientOption{rpc.WithWebsocketDialer(newWebsocketDialer(enableCompression, nc.tlsConfig, nc.proxy, nc.netDialer))}
+	if len(nc.headers) > 0 {
+		opts = append(opts, rpc.WithHeaders(nc.headers))
+	}
+	return rpc.DialOptions(context.Background(), nc.uri, opts...)
+}
+
+// isBadHandshake returns whether the server rejected the websocket handshake. The rpc package doesn't wrap the
+// websocket error, so its message is matched too.
+func isBadHandshake(err error) bool {
+	return errors.Is(err, websocket.ErrBadHandshake) || strings.HasPrefix(err.Error(), websocket.ErrBadHandshake.Error())
+}
 
-	sub, err := gethclient.New(rpcClient).SubscribeFullPendingTransactions(context.Background(), txC)
+func (nc *NodeConnection) connectGeneric(txC chan json.RawMessage) (*rpc.Client, *rpc.ClientSubscription, error) {
+	nc.log.Infow("connecting to node...", "uri", nc.uri)
+	rpcClient, err := nc.dial()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	nc.log.Infow("connection successful", "uri", nc.uri)
-	return sub, nil
+	sub, err := rpcClient.EthSubscribe(context.Background(), txC, "newPendingTransactions", true) // same as gethclient.SubscribeFullPendingTransactions
+	if err != nil {
+		rpcClient.Close()
+		return nil, nil, err
+	}
+
+	// for nodes which send only hashes on this subscription
+	nc.ethClient.Store(ethclient.NewClient(rpcClient))
+
+	nc.log.Infow("connection successful", "uri", nc.uri, "compression", nc.useCompression)
+	return rpcClient, sub, nil
 }
 
-func (nc *NodeConnection) connectAlchemy(txC chan *types.Transaction) (*rpc.ClientSubscription, error) {
+func (nc *NodeConnection) connectAlchemy(txC chan json.RawMessage) (*rpc.Client, *rpc.ClientSubscription, error) {
 	nc.log.Infow("connecting to node...", "uri", nc.uri)
-	client, err := ethclient.Dial(nc.uri)
+	rpcClient, err := nc.dial()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// with an address filter, the provider only sends matching txs. If it rejects the filter (i.e. a plan or an
+	// endpoint without filtered subscriptions), fall back to the unfiltered subscription and filter locally.
+	if nc.alchemyFilter != nil {
+		sub, err := rpcClient.Subscribe(context.Background(), "eth", txC, "alchemy_pendingTransactions", nc.alchemyFilter.subscriptionParams())
+		if err == nil {
+			nc.log.Infow("connection successful", "uri", nc.uri, "compression", nc.useCompression, "filtered", true)
+			return rpcClient, sub, nil
+		}
+		var rpcErr rpc.Error
+		if !errors.As(err, &rpcErr) { // not rejected by the provider, i.e. the connection failed
+			rpcClient.Close()
+			return nil, nil, err
+		}
+		nc.log.Warnw("filtered alchemy subscription rejected, falling back to the unfiltered subscription with local filtering", "error", err)
+		nc.clientFilter = newAddressFilter(nc.alchemyFilter)
+		nc.alchemyFilter = nil
 	}
 
-	sub, err := client.Client().Subscribe(context.Background(), "eth", txC, "alchemy_pendingTransactions")
+	sub, err := rpcClient.Subscribe(context.Background(), "eth", txC, "alchemy_pendingTransactions")
 	if err != nil {
-		return nil, err
+		rpcClient.Close()
+		return nil, nil, err
 	}
 
-	nc.log.Infow("connection successful", "uri", nc.uri)
-	return sub, nil
+	nc.log.Infow("connection successful", "uri", nc.uri, "compression", nc.useCompression)
+	return rpcClient, sub, nil
+}
+
+// connectHashes subscribes to the hashes of new pending transactions (without the full tx), which is what
+// hosted providers like Infura support. The transactions are then fetched by the fetchTxsByHash workers.
+func (nc *NodeConnection) connectHashes() (*rpc.Client, *rpc.ClientSubscription, error) {
+	nc.log.Infow("connecting to node...", "uri", nc.uri, "mode", "hashes")
+	rpcClient, err := nc.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sub, err := gethclient.New(rpcClient).SubscribePendingTransactions(context.Background(), nc.hashC)
+	if err != nil {
+		rpcClient.Close()
+		return nil, nil, err
+	}
+
+	nc.ethClient.Store(ethclient.NewClient(rpcClient))
+	nc.log.Infow("connection successful", "uri", nc.uri, "mode", "hashes", "compression", nc.useCompression)
+	return rpcClient, sub, nil
+}
+
+// decodePendingTxMsg decodes a message of the full pending tx subscription. Nodes which don't support full txs on the
+// subscription (i.e. local devnets like anvil and hardhat) send the tx hash instead, which is returned with a nil tx.
+func decodePendingTxMsg(msg json.RawMessage) (tx *types.Transaction, hash ethcommon.Hash, err error) {
+	if len(msg) > 0 && msg[0] == '"' {
+		err = json.Unmarshal(msg, &hash)
+		return nil, hash, err
+	}
+
+	tx = new(types.Transaction)
+	if err = tx.UnmarshalJSON(msg); err != nil {
+		return nil, hash, err
+	}
+	return tx, hash, nil
+}
+
+// startHashWorkers starts the workers fetching the full transactions for the hashes in the queue
+func (nc *NodeConnection) startHashWorkers() {
+	for i := 0; i < hashFetchWorkers; i++ {
+		go nc.fetchTxsByHash()
+	}
+}
+
+// fetchTxsByHash gets the full transactions for hashes from the queue. The timestamp of the tx is when the hash was received.
+func (nc *NodeConnection) fetchTxsByHash() {
+	for h := range nc.hashQueue {
+		client := nc.ethClient.Load()
+		if client == nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), hashFetchTimeout)
+		tx, _, err := client.TransactionByHash(ctx, h.hash)
+		cancel()
+		if err != nil {
+			// the tx might already be dropped from the provider's mempool
+			nc.log.Debugw("failed to fetch tx by hash", "hash", h.hash.Hex(), "error", err)
+			continue
+		}
+		addRxBytes(nc.rxBytes, int(tx.Size()))
+
+		nc.txC <- TxIn{h.t, tx, nc.src}
+	}
+}
+
+// newWebsocketDialer returns a dialer with the same settings as websocket.DefaultDialer, optionally
+// negotiating permessage-deflate compression (RFC 7692). If the server doesn't support the extension,
+// the connection just continues uncompressed. tlsConfig is optional (nil for the default TLS settings),
+// as is proxy (nil for the proxy from the environment, socks5:// proxies are supported too), and netDialer (nil for
+// the default TCP settings).
+func newWebsocketDialer(enableCompression bool, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error), netDialer *net.Dialer) websocket.Dialer {
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	dialer := websocket.Dialer{ //nolint:exhaustruct
+		Proxy:             proxy,
+		HandshakeTimeout:  45 * time.Second,
+		EnableCompression: enableCompression,
+		TLSClientConfig:   tlsConfig,
+	}
+	if netDialer != nil {
+		dialer.NetDialContext = netDialer.DialContext
+	}
+	return dialer
 }
@@ -8,34 +8,79 @@ package collector
 // eden: https://docs.edennetwork.io/eden-rpc/speed-rpc
 
 import (
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/flashbots/mempool-dumpster/common"
 	"github.com/gorilla/websocket"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
+// bloXroute transaction streams (https://docs.bloxroute.com/streams/newtxs-and-pendingtxs)
+const (
+	BlxFeedNewTxs     = "newTxs"     // all new txs propagated in the BDN (default)
+	BlxFeedPendingTxs = "pendingTxs" // only txs which passed validation against the current state
+)
+
 type BlxNodeOpts struct {
 	Log        *zap.SugaredLogger
 	AuthHeader string
 	IsEden     bool
 	URL        string // optional override, default: blxDefaultURL
-	SourceTag  string // optional override, default: "blx" (common.BloxrouteTag)
+	SourceTag  string // optional override, default: "bloxroute" (common.BloxrouteTag), or "bloxroute-<feed>" for feeds other than newTxs
+	Feed       string // bloxroute stream to subscribe to (BlxFeedNewTxs or BlxFeedPendingTxs, default: newTxs), not used for eden
+
+	// FailoverURLs are tried in order if the connection to the previous URL fails or stalls (no message within
+	// IdleTimeout, or blxIdleTimeout if not set). The source tag stays the same. While connected to a failover URL,
+	// the primary URL is retried every blxFailbackInterval.
+	FailoverURLs []string
+
+	// IdleTimeout reconnects if no message is received for this long, to recover "connected but dead" sockets
+	// (0 = disabled, or blxIdleTimeout with failover URLs)
+	IdleTimeout time.Duration
+
+	DisableCompression bool        // disable websocket permessage-deflate compression
+	MaxTxPerSec        float64     // limit of txs per second from this source, excess txs are dropped and counted (0 = unlimited)
+	TLSConfig          *tls.Config // optional, i.e. with a client certificate for mutual TLS (see LoadTLSConfig)
+
+	// Proxy is optional, default: from the environment (see ParseProxy)
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// DedupWindow suppresses repeats of a tx by this source within the window before they reach the processor (0 = disabled)
+	DedupWindow time.Duration
 }
 
 type BlxNodeConnection struct {
 	log        *zap.SugaredLogger
 	authHeader string
-	url        string
+	urls       []string // primary URL, followed by the failover URLs
+	urlIdx     int      // index of the current URL
 	isEden     bool
-	srcTag     string
+	feed       string
+	src        common.Source
 	txC        chan TxIn
 	backoffSec int
+	badFrames  *badFrameCounter
+	limiter    *rateLimiter // nil if unlimited
+	dedup      *sourceDedup // nil if disabled
+	tlsConfig  *tls.Config
+	proxy      func(*http.Request) (*url.URL, error)
+	rxBytes    *atomic.Uint64 // optional, counts the bytes of received messages
+	frames     *frameDumper   // optional, dumps the received messages (debug)
+	netDialer  *net.Dialer    // optional, shared by the connections of the collector (see connManager)
+
+	useCompression bool
+	idleTimeout    time.Duration // 0 if disabled
 }
 
 func NewBlxNodeConnection(opts BlxNodeOpts, txC chan TxIn) *BlxNodeConnection {
@@ -44,29 +89,67 @@ func NewBlxNodeConnection(opts BlxNodeOpts, txC chan TxIn) *BlxNodeConnection {
 		url = blxDefaultURL
 	}
 
+	feed := opts.Feed
+	if feed == "" {
+		feed = BlxFeedNewTxs
+	}
+
 	srcTag := opts.SourceTag
 	if srcTag == "" {
 		srcTag = common.BloxrouteTag
+		if feed != BlxFeedNewTxs && !opts.IsEden {
+			srcTag += "-" + feed
+		}
+	}
+
+	idleTimeout := opts.IdleTimeout
+	if idleTimeout == 0 && len(opts.FailoverURLs) > 0 {
+		idleTimeout = blxIdleTimeout
 	}
 
+	log := opts.Log.With("src", srcTag)
 	return &BlxNodeConnection{
-		log:        opts.Log.With("src", srcTag),
+		log:        log,
 		authHeader: opts.AuthHeader,
-		url:        url,
+		urls:       append([]string{url}, opts.FailoverURLs...),
 		isEden:     opts.IsEden,
-		srcTag:     srcTag,
+		feed:       feed,
+		src:        common.Source{Name: srcTag, Kind: common.SourceKindPrivate, Transport: common.SourceTransportWebsocket},
 		txC:        txC,
 		backoffSec: initialBackoffSec,
+		badFrames:  newBadFrameCounter(log),
+		limiter:    newRateLimiter(log, opts.MaxTxPerSec),
+		dedup:      newSourceDedup(log, opts.DedupWindow),
+		tlsConfig:  opts.TLSConfig,
+		proxy:      opts.Proxy,
+
+		useCompression: !opts.DisableCompression,
+		idleTimeout:    idleTimeout,
 	}
 }
 
+// Start connects, and reconnects with backoff whenever the connection ends (it never returns)
 func (nc *BlxNodeConnection) Start() {
-	nc.connect()
+	for {
+		if failback := nc.connect(); !failback {
+			nc.backoff()
+		}
+	}
 }
 
-func (nc *BlxNodeConnection) reconnect() {
+// failover switches to the next URL for the following connection attempt (no-op without failover URLs)
+func (nc *BlxNodeConnection) failover() {
+	if len(nc.urls) < 2 {
+		return
+	}
+	nc.urlIdx = (nc.urlIdx + 1) % len(nc.urls)
+	nc.log.Warnw("failing over to the next url", "uri", nc.urls[nc.urlIdx])
+}
+
+// backoff waits before the next connection attempt, with exponential backoff
+func (nc *BlxNodeConnection) backoff() {
 	backoffDuration := time.Duration(nc.backoffSec) * time.Second
-	nc.log.Infof("reconnecting to %s in %s sec ...", nc.srcTag, backoffDuration.String())
+	nc.log.Infof("reconnecting to %s in %s sec ...", nc.src, backoffDuration.String())
 	time.Sleep(backoffDuration)
 
 	// increase backoff timeout for next try
@@ -74,49 +157,91 @@ func (nc *BlxNodeConnection) reconnect() {
 	if nc.backoffSec > maxBackoffSec {
 		nc.backoffSec = maxBackoffSec
 	}
+}
 
-	nc.connect()
+// dial opens the websocket connection. If compression is enabled but the server rejects the handshake (some servers
+// reject the compression extension), it retries once without compression, and keeps it disabled for future reconnects
+// if that succeeds.
+func (nc *BlxNodeConnection) dial(url string) (*websocket.Conn, *http.Response, error) {
+	header := http.Header{"Authorization": []string{nc.authHeader}}
+	dialer := newWebsocketDialer(nc.useCompression, nc.tlsConfig, nc.proxy, nc.netDialer)
+	wsSubscriber, resp, err := dialer.Dial(url, header)
+	if err == nil || !nc.useCompression || !errors.Is(err, websocket.ErrBadHandshake) {
+		return wsSubscriber, resp, err
+	}
+
+	nc.log.Warnw("websocket handshake failed, retrying without compression", "error", err)
+	dialer.EnableCompression = false
+	wsSubscriber, resp, err = dialer.Dial(url, header)
+	if err != nil {
+		return nil, resp, err
+	}
+	nc.useCompression = false
+	return wsSubscriber, resp, nil
 }
 
-func (nc *BlxNodeConnection) connect() {
-	nc.log.Infow("connecting...", "uri", nc.url)
-	dialer := websocket.DefaultDialer
-	wsSubscriber, resp, err := dialer.Dial(nc.url, http.Header{"Authorization": []string{nc.authHeader}})
+// connect connects to the current URL and processes the messages until the connection fails, or returns failback
+// if it should connect to the primary URL again right away
+func (nc *BlxNodeConnection) connect() (failback bool) {
+	url := nc.urls[nc.urlIdx]
+	nc.log.Infow("connecting...", "uri", url)
+	wsSubscriber, resp, err := nc.dial(url)
 	if err != nil {
-		nc.log.Errorw("failed to connect to bloxroute", "error", err)
-		go nc.reconnect()
-		return
+		nc.log.Errorw("failed to connect to bloxroute", "uri", url, "error", err)
+		nc.failover()
+		return false
 	}
 	defer wsSubscriber.Close()
 	defer resp.Body.Close()
 
-	subRequest := `{"id": 1, "method": "subscribe", "params": ["newTxs", {"include": ["raw_tx"]}]}`
+	subRequest := fmt.Sprintf(`{"id": 1, "method": "subscribe", "params": [%q, {"include": ["raw_tx"]}]}`, nc.feed)
 	if nc.isEden {
 		subRequest = `{"jsonrpc": "2.0", "id": 1, "method": "subscribe", "params": ["rawTxs"]}`
 	}
 	err = wsSubscriber.WriteMessage(websocket.TextMessage, []byte(subRequest))
 	if err != nil {
-		nc.log.Errorw("failed to subscribe to bloxroute", "error", err)
-		go nc.reconnect()
-		return
+		nc.log.Errorw("failed to subscribe to bloxroute", "uri", url, "error", err)
+		nc.failover()
+		return false
 	}
 
-	nc.log.Infow("connection successful", "uri", nc.url)
+	nc.log.Infow("connection successful", "uri", url, "feed", nc.feed, "compression", nc.useCompression)
 	nc.backoffSec = initialBackoffSec // reset backoff timeout
+	connectedAt := time.Now()
 
 	for {
+		// a stalled connection (no messages) is reconnected, and with failover URLs fails over too
+		if nc.idleTimeout > 0 {
+			_ = wsSubscriber.SetReadDeadline(time.Now().Add(nc.idleTimeout))
+		}
+
 		_, nextNotification, err := wsSubscriber.ReadMessage()
+		t := time.Now().UTC() // timestamp of receipt, before decoding
 		if err != nil {
 			// Handle websocket errors, by closing and reconnecting. Errors seen previously:
 			// - "websocket: close 1006 (abnormal closure): unexpected EOF"
-			if strings.Contains(err.Error(), "failed parsing the authorization header") {
+			var netErr net.Error
+			switch {
+			case strings.Contains(err.Error(), "failed parsing the authorization header"):
 				nc.log.Errorw("invalid bloxroute auth header", "error", err)
-			} else {
+			case errors.As(err, &netErr) && netErr.Timeout():
+				nc.log.Errorw("connection stalled, reconnecting", "uri", url, "idle_timeout", nc.idleTimeout.String())
+			default:
 				nc.log.Errorw("failed to read message, reconnecting", "error", err)
 			}
 
-			go nc.reconnect()
-			return
+			nc.failover()
+			return false
+		}
+
+		addRxBytes(nc.rxBytes, len(nextNotification))
+		nc.frames.record(t, nextNotification)
+
+		// fail back to the primary URL once in a while (if that fails, the connection fails over again)
+		if nc.urlIdx != 0 && time.Since(connectedAt) > blxFailbackInterval {
+			nc.log.Infow("failing back to the primary url", "uri", nc.urls[0])
+			nc.urlIdx = 0
+			return true
 		}
 
 		// fmt.Println("got message", string(nextNotification))
@@ -125,7 +250,7 @@ func (nc *BlxNodeConnection) connect() {
 			var txMsg common.EdenRawTxMsg
 			err = json.Unmarshal(nextNotification, &txMsg)
 			if err != nil {
-				nc.log.Errorw("failed to unmarshal message", "error", err)
+				nc.badFrames.record(err, nextNotification)
 				continue
 			}
 			rlp = txMsg.Params.Result.RLP
@@ -133,7 +258,7 @@ func (nc *BlxNodeConnection) connect() {
 			var txMsg common.BlxRawTxMsg
 			err = json.Unmarshal(nextNotification, &txMsg)
 			if err != nil {
-				nc.log.Errorw("failed to unmarshal message", "error", err)
+				nc.badFrames.record(err, nextNotification)
 				continue
 			}
 			rlp = txMsg.Params.Result.RawTx
@@ -146,17 +271,19 @@ func (nc *BlxNodeConnection) connect() {
 		// nc.log.Debugw("got tx", "rawtx", rlp)
 		rawtx, err := hex.DecodeString(strings.TrimPrefix(rlp, "0x"))
 		if err != nil {
-			nc.log.Errorw("failed to decode raw tx", "error", err)
+			nc.badFrames.record(err, nextNotification)
 			continue
 		}
 
 		var tx types.Transaction
 		err = tx.UnmarshalBinary(rawtx)
 		if err != nil {
-			nc.log.Errorw("failed to unmarshal tx", "error", err, "rlp", rlp)
+			nc.badFrames.record(err, nextNotification)
 			continue
 		}
 
-		nc.txC <- TxIn{time.Now().UTC(), &tx, nc.srcTag}
+		if nc.dedup.allow(tx.Hash()) && nc.limiter.allow() {
+			nc.txC <- TxIn{t, &tx, nc.src}
+		}
 	}
 }
@@ -9,6 +9,7 @@ import (
 
 	fiber "github.com/chainbound/fiber-go"
 	"github.com/flashbots/mempool-dumpster/common"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
@@ -17,16 +18,22 @@ type ChainboundNodeOpts struct {
 	APIKey    string
 	URL       string // optional override, default: ChainboundDefaultURL
 	SourceTag string // optional override, default: "Chainbound"
+
+	MaxTxPerSec float64       // limit of txs per second from this source, excess txs are dropped and counted (0 = unlimited)
+	DedupWindow time.Duration // suppress repeats of a tx by this source within the window before they reach the processor (0 = disabled)
 }
 
 type ChainboundNodeConnection struct {
 	log        *zap.SugaredLogger
 	apiKey     string
 	url        string
-	srcTag     string
+	src        common.Source
 	fiberC     chan *fiber.Transaction
 	txC        chan TxIn
 	backoffSec int
+	limiter    *rateLimiter   // nil if unlimited
+	dedup      *sourceDedup   // nil if disabled
+	rxBytes    *atomic.Uint64 // optional, counts the (RLP) bytes of received txs
 }
 
 func NewChainboundNodeConnection(opts ChainboundNodeOpts, txC chan TxIn) *ChainboundNodeConnection {
@@ -40,14 +47,17 @@ func NewChainboundNodeConnection(opts ChainboundNodeOpts, txC chan TxIn) *Chainb
 		srcTag = common.ChainboundTag
 	}
 
+	log := opts.Log.With("src", srcTag)
 	return &ChainboundNodeConnection{
-		log:        opts.Log.With("src", srcTag),
+		log:        log,
 		apiKey:     opts.APIKey,
 		url:        url,
-		srcTag:     srcTag,
+		src:        common.Source{Name: srcTag, Kind: common.SourceKindPrivate, Transport: common.SourceTransportGRPC},
 		fiberC:     make(chan *fiber.Transaction),
 		txC:        txC,
 		backoffSec: initialBackoffSec,
+		limiter:    newRateLimiter(log, opts.MaxTxPerSec),
+		dedup:      newSourceDedup(log, opts.DedupWindow),
 	}
 }
 
@@ -57,8 +67,16 @@ func (cbc *ChainboundNodeConnection) Start() {
 	go cbc.connect()
 
 	for fiberTx := range cbc.fiberC {
+		t := time.Now().UTC() // timestamp of receipt, before decoding
+		if !cbc.limiter.allow() {
+			continue
+		}
 		nativeTx := fiberTx.ToNative()
-		cbc.txC <- TxIn{time.Now().UTC(), nativeTx, cbc.srcTag}
+		addRxBytes(cbc.rxBytes, int(nativeTx.Size()))
+		if !cbc.dedup.allow(nativeTx.Hash()) {
+			continue
+		}
+		cbc.txC <- TxIn{t, nativeTx, cbc.src}
 	}
 
 	cbc.log.Error("chainbound stream closed")
@@ -81,7 +99,7 @@ func (cbc *ChainboundNodeConnection) reconnect() {
 func (cbc *ChainboundNodeConnection) connect() {
 	cbc.log.Infow("connecting...", "uri", cbc.url)
 
-	client := fiber.NewClient(chainboundDefaultURL, cbc.apiKey)
+	client := fiber.NewClient(cbc.url, cbc.apiKey)
 	defer client.Close()
 
 	// Connect
@@ -0,0 +1,37 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+var ErrInvalidProxy = errors.New("invalid proxy")
+
+// ProxyDirect as proxy URL connects without a proxy, even if one is set in the environment
+const ProxyDirect = "direct"
+
+// ParseProxy returns the proxy function for the websocket connections of a source: http://, https:// or socks5://
+// URLs (with optional user:password), or ProxyDirect. Returns nil for an empty URL, in which case the proxy is
+// taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func ParseProxy(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxyURL {
+	case "":
+		return nil, nil
+	case ProxyDirect:
+		return func(*http.Request) (*url.URL, error) { return nil, nil }, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProxy, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("%w: unsupported scheme '%s' (http, https or socks5)", ErrInvalidProxy, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: missing host", ErrInvalidProxy)
+	}
+	return http.ProxyURL(u), nil
+}
@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// rateLimiter is a token bucket limiting the number of txs a single source can send to the processor per second
+// (with bursts of up to one second worth of txs). Txs over the limit are dropped, counted and logged at most once
+// per rateLimitLogInterval. A nil rateLimiter allows everything.
+type rateLimiter struct {
+	log       *zap.SugaredLogger
+	maxPerSec float64
+
+	lock       sync.Mutex
+	tokens     float64
+	lastRefill time.Time
+	lastLog    time.Time
+
+	dropped      atomic.Uint64 // since the last log entry
+	droppedTotal atomic.Uint64
+}
+
+// newRateLimiter returns a limiter for maxPerSec txs per second, or nil if maxPerSec is 0 (unlimited)
+func newRateLimiter(log *zap.SugaredLogger, maxPerSec float64) *rateLimiter {
+	if maxPerSec <= 0 {
+		return nil
+	}
+
+	return &rateLimiter{ //nolint:exhaustruct
+		log:        log,
+		maxPerSec:  maxPerSec,
+		tokens:     maxPerSec,
+		lastRefill: time.Now(),
+		lastLog:    time.Now(),
+	}
+}
+
+// allow returns whether another tx can be sent, and counts it as dropped otherwise
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.lastRefill).Seconds() * l.maxPerSec
+	if l.tokens > l.maxPerSec {
+		l.tokens = l.maxPerSec
+	}
+	l.lastRefill = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true
+	}
+
+	l.dropped.Inc()
+	l.droppedTotal.Inc()
+	if now.Sub(l.lastLog) >= rateLimitLogInterval {
+		l.lastLog = now
+		l.log.Warnw("source exceeds the rate limit, dropping txs",
+			"max_tx_per_sec", l.maxPerSec,
+			"dropped", common.Printer.Sprint(l.dropped.Swap(0)),
+			"dropped_total", common.Printer.Sprint(l.droppedTotal.Load()),
+		)
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+package collector
+
+// Replay recorded transactions CSVs through the processor, as if they arrived in real time (or accelerated), i.e. for
+// deterministic end-to-end tests of filters and sinks.
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/flashbots/mempool-dumpster/common"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// errStopReading stops reading a CSV file early
+var errStopReading = errors.New("stop reading")
+
+type ReplayOpts struct {
+	Log       *zap.SugaredLogger
+	Files     []string // transactions CSVs (.csv, .csv.zip, compressed .csv.gz/.zst/.sz or day archives), replayed in order
+	Speed     float64  // pacing multiplier of the recorded time (1 = real time, 10 = 10x faster, 0 = as fast as possible)
+	SourceTag string   // optional override, default: "replay"
+
+	// Clock is set to the recorded timestamp of each tx before it's sent (optional, i.e. the Clock of the processor,
+	// see NewReplayClock)
+	Clock *ReplayClock
+}
+
+// ReplayClock is a Clock which follows the recorded timestamps of the replayed txs. As the Clock of the processor,
+// the buckets and caches expire, and the warmup ends, on the recorded time instead of the wall time of the replay.
+type ReplayClock struct {
+	ms atomic.Int64
+}
+
+// NewReplayClock returns a ReplayClock starting at the first recorded timestamp of the files (or the current time, if
+// there is none), so the processor's warmup starts with the recording
+func NewReplayClock(files []string) *ReplayClock {
+	c := &ReplayClock{} //nolint:exhaustruct
+	c.ms.Store(time.Now().UnixMilli())
+	for _, fn := range files {
+		found := false
+		_ = readTransactionsCSV(fn, func(row []string) error {
+			ts, err := strconv.ParseInt(row[0], 10, 64)
+			if err != nil || len(row) < 3 {
+				return nil // skipped by the replay as well
+			}
+			c.ms.Store(ts)
+			found = true
+			return errStopReading
+		})
+		if found {
+			break
+		}
+	}
+	return c
+}
+
+func (c *ReplayClock) Now() time.Time {
+	return time.UnixMilli(c.ms.Load()).UTC()
+}
+
+// Replay is a source which sends the txs of recorded transactions CSVs, with their recorded timestamps
+type Replay struct {
+	log   *zap.SugaredLogger
+	files []string
+	speed float64
+	clock *ReplayClock
+	src   common.Source
+	txC   chan TxIn
+}
+
+func NewReplay(opts ReplayOpts, txC chan TxIn) *Replay {
+	srcTag := opts.SourceTag
+	if srcTag == "" {
+		srcTag = "replay"
+	}
+
+	return &Replay{
+		log:   opts.Log.With("src", srcTag),
+		files: opts.Files,
+		speed: opts.Speed,
+		clock: opts.Clock,
+		src:   common.Source{Name: srcTag, Kind: common.SourceKindPublic, Transport: common.SourceTransportFile},
+		txC:   txC,
+	}
+}
+
+// Start replays all files (blocking). The rows are streamed, so large files (i.e. a recorded day) aren't held in memory.
+func (r *Replay) Start() {
+	var firstTS int64
+	startedAt := time.Now()
+	cntTxs := 0
+
+	for _, fn := range r.files {
+		r.log.Infow("replaying file", "file", fn, "speed", r.speed)
+		err := readTransactionsCSV(fn, func(row []string) error {
+			if len(row) < 3 {
+				r.log.Warnw("invalid line", "line", row)
+				return nil
+			}
+			ts, err := strconv.ParseInt(row[0], 10, 64)
+			if err != nil {
+				r.log.Warnw("invalid timestamp", "line", row, "error", err)
+				return nil
+			}
+			tx, err := common.RLPStringToTx(row[2])
+			if err != nil {
+				r.log.Warnw("invalid raw tx", "hash", row[1], "error", err)
+				return nil
+			}
+
+			// wait until the recorded time offset (divided by the speed) has passed since the start
+			if firstTS == 0 {
+				firstTS = ts
+			}
+			if r.speed > 0 {
+				offset := time.Duration(float64(ts-firstTS)/r.speed) * time.Millisecond
+				if wait := time.Until(startedAt.Add(offset)); wait > 0 {
+					time.Sleep(wait)
+				}
+			}
+
+			if r.clock != nil {
+				r.clock.ms.Store(ts)
+			}
+			r.txC <- TxIn{time.UnixMilli(ts).UTC(), tx, r.src}
+			cntTxs++
+			return nil
+		})
+		if err != nil {
+			r.log.Errorw("failed to read file", "file", fn, "error", err)
+		}
+	}
+
+	r.log.Infow("replay finished", "txs", common.Printer.Sprint(cntTxs), "duration", time.Since(startedAt).String())
+}
+
+// readTransactionsCSV calls fn for every row of a transactions CSV (or of all of them, in a zip file or day archive),
+// without the header rows. Returning errStopReading from fn stops reading without an error.
+func readTransactionsCSV(fn string, rowFn func(row []string) error) error {
+	err := common.ReadCSVFile(fn, "transactions", func(name string, r io.Reader) error {
+		csvReader := csv.NewReader(r)
+		for {
+			row, err := csvReader.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if common.IsHeaderRow(row) {
+				continue
+			}
+			if err = rowFn(row); err != nil {
+				return err
+			}
+		}
+	})
+	if errors.Is(err, errStopReading) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// removeExpiredDateDirs removes the date directories of the output directory (i.e. out/2023-08-07) whose day ended
+// more than the retention ago, unless files of a bucket of that day are still open. Other entries are not touched.
+// Must be called with outFilesLock held, so no bucket of the day is opened while its directory is removed.
+func (p *TxProcessor) removeExpiredDateDirs(now time.Time) {
+	entries, err := os.ReadDir(p.outDir)
+	if err != nil {
+		p.log.Errorw("failed to read output directory for retention", "error", err)
+		return
+	}
+
+	openDates := make(map[string]bool)
+	for timestamp := range p.outFiles {
+		openDates[time.Unix(timestamp, 0).UTC().Format(time.DateOnly)] = true
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		date, err := time.Parse(time.DateOnly, entry.Name())
+		if err != nil {
+			continue // not a date directory
+		}
+		if now.Sub(date.Add(24*time.Hour)) <= p.retention || openDates[entry.Name()] {
+			continue
+		}
+
+		dir := filepath.Join(p.outDir, entry.Name())
+		if err = os.RemoveAll(dir); err != nil {
+			p.log.Errorw("failed to remove expired date directory", "dir", dir, "error", err)
+			continue
+		}
+		p.log.Infow("removed expired date directory", "dir", dir, "retention", p.retention.String())
+	}
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"sync"
+
+	"go.uber.org/atomic"
+)
+
+// rxBytesCounter counts the bytes received per source, for bandwidth accounting. This includes duplicate txs and
+// frames which fail to decode. The connections count the (uncompressed) message payloads, so it's an approximation
+// of the wire bytes.
+type rxBytesCounter struct {
+	lock     sync.Mutex
+	bySource map[string]*atomic.Uint64
+}
+
+func newRxBytesCounter() *rxBytesCounter {
+	return &rxBytesCounter{bySource: make(map[string]*atomic.Uint64)} //nolint:exhaustruct
+}
+
+// counter returns the counter of a source, which the connection keeps to count without locking
+func (c *rxBytesCounter) counter(src string) *atomic.Uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.bySource[src] == nil {
+		c.bySource[src] = atomic.NewUint64(0)
+	}
+	return c.bySource[src]
+}
+
+// read returns the number of bytes per source, and resets the counters if reset is set
+func (c *rxBytesCounter) read(reset bool) map[string]uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	res := make(map[string]uint64, len(c.bySource))
+	for src, cnt := range c.bySource {
+		res[src] = resetCounter(cnt, reset)
+	}
+	return res
+}
+
+// addRxBytes adds n to the counter of a connection, if it has one
+func addRxBytes(cnt *atomic.Uint64, n int) {
+	if cnt != nil {
+		cnt.Add(uint64(n))
+	}
+}
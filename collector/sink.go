@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"fmt"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+// Sink receives every newly processed (deduplicated) transaction. An output is added by implementing the interface
+// and passing it with TxProcessorOpts.Sinks. The processor always writes to the txs files (see txsFileSink).
+type Sink interface {
+	Write(tx TxDetail) error
+	Close() error
+}
+
+// SightingSink is a Sink which also receives every sighting of a tx by a source, i.e. the rows of the sourcelog (also
+// if the sourcelog file is disabled, and limited to the first sighting per source with SourcelogFirstOnly).
+type SightingSink interface {
+	Sink
+	WriteSighting(sighting Sighting) error
+}
+
+// Sighting is a tx received from a source
+type Sighting struct {
+	Timestamp int64 // ms
+	Hash      string
+	Source    string
+	Origin    string // only set with TxProcessorOpts.Origin
+}
+
+// txsFileSink writes the transactions to the txs file of their bucket (or to the output stream)
+type txsFileSink struct {
+	p *TxProcessor
+}
+
+func (s *txsFileSink) Write(tx TxDetail) error {
+	outFiles, _, err := s.p.getOutputCSVFiles(tx.Timestamp / 1000)
+	if err != nil {
+		return err
+	}
+
+	line := fmt.Sprintf("%d,%s,%s", tx.Timestamp, tx.Hash, tx.RawTx)
+	if s.p.writeSignature {
+		line += fmt.Sprintf(",%s,%s,%s", tx.YParity, tx.R, tx.S)
+	}
+	if s.p.writeCalldata {
+		line += fmt.Sprintf(",%d,%d", *tx.CalldataSize, *tx.IntrinsicGas)
+	}
+	if tx.Origin != "" {
+		line += "," + tx.Origin
+	}
+	_, err = fmt.Fprintln(outFiles.txsFile(ethcommon.HexToHash(tx.Hash)), line)
+	if err != nil {
+		return err
+	}
+	outFiles.cntTxs.Inc()
+	return nil
+}
+
+// Close is a no-op, the files of each bucket are closed by the processor
+func (s *txsFileSink) Close() error {
+	return nil
+}
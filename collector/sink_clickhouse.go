@@ -0,0 +1,247 @@
+package collector
+
+// ClickHouse sink: inserts the unique txs and the sightings (sourcelog rows) into ClickHouse tables over its HTTP
+// interface, in batches with async inserts, i.e. for near-real-time dashboards without a CSV import. Example schema:
+//
+//	CREATE TABLE txs (timestamp_ms Int64, hash String, raw_tx String, origin String) ENGINE = MergeTree ORDER BY timestamp_ms;
+//	CREATE TABLE sourcelog (timestamp_ms Int64, hash String, source String, origin String) ENGINE = MergeTree ORDER BY timestamp_ms;
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidClickHouseDSN = errors.New("invalid ClickHouse DSN (must be http(s)://[user[:password]@]host:port[/database])")
+	ErrClickHouseInsert     = errors.New("ClickHouse insert failed")
+)
+
+type ClickHouseSinkOpts struct {
+	Log *zap.SugaredLogger
+
+	// DSN is the URL of the HTTP interface, with optional credentials and database (i.e. http://user:pw@localhost:8123/mempool)
+	DSN string
+
+	TxsTable       string        // default: txs
+	SourcelogTable string        // default: sourcelog
+	BatchSize      int           // rows per table which trigger a flush before the interval (default: 10,000)
+	FlushInterval  time.Duration // default: 1s
+}
+
+// ClickHouseSink is a SightingSink. Rows are buffered, and inserted by a background loop. Failed inserts are logged,
+// and the rows are dropped (counted), so an unreachable server never blocks the processing of txs.
+type ClickHouseSink struct {
+	log           *zap.SugaredLogger
+	client        *http.Client
+	endpoint      string // without credentials
+	user          string
+	password      string
+	database      string
+	txsTable      string
+	srcTable      string
+	batchSize     int
+	flushInterval time.Duration
+
+	lock    sync.Mutex
+	txs     bytes.Buffer // JSONEachRow rows of the txs table
+	txsCnt  int
+	srcs    bytes.Buffer // JSONEachRow rows of the sourcelog table
+	srcsCnt int
+
+	flushC  chan struct{}
+	closeC  chan struct{}
+	doneC   chan struct{}
+	dropped atomic.Uint64 // rows of failed inserts
+}
+
+type clickHouseTxRow struct {
+	TimestampMs int64  `json:"timestamp_ms"`
+	Hash        string `json:"hash"`
+	RawTx       string `json:"raw_tx"`
+	Origin      string `json:"origin"`
+}
+
+type clickHouseSightingRow struct {
+	TimestampMs int64  `json:"timestamp_ms"`
+	Hash        string `json:"hash"`
+	Source      string `json:"source"`
+	Origin      string `json:"origin"`
+}
+
+func NewClickHouseSink(opts ClickHouseSinkOpts) (*ClickHouseSink, error) {
+	u, err := url.Parse(opts.DSN)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidClickHouseDSN, opts.DSN)
+	}
+
+	s := &ClickHouseSink{ //nolint:exhaustruct
+		log:           opts.Log.With("service", "clickhouse"),
+		client:        &http.Client{Timeout: clickHouseTimeout}, //nolint:exhaustruct
+		endpoint:      u.Scheme + "://" + u.Host + "/",
+		database:      strings.Trim(u.Path, "/"),
+		txsTable:      opts.TxsTable,
+		srcTable:      opts.SourcelogTable,
+		batchSize:     opts.BatchSize,
+		flushInterval: opts.FlushInterval,
+		flushC:        make(chan struct{}, 1),
+		closeC:        make(chan struct{}),
+		doneC:         make(chan struct{}),
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if s.txsTable == "" {
+		s.txsTable = "txs"
+	}
+	if s.srcTable == "" {
+		s.srcTable = "sourcelog"
+	}
+	if s.batchSize == 0 {
+		s.batchSize = defaultClickHouseBatchSize
+	}
+	if s.flushInterval == 0 {
+		s.flushInterval = defaultClickHouseFlushInterval
+	}
+
+	go s.flushLoop()
+	return s, nil
+}
+
+// Write buffers a row of the txs table (ClickHouseSink is a Sink)
+func (s *ClickHouseSink) Write(tx TxDetail) error {
+	row, err := json.Marshal(clickHouseTxRow{tx.Timestamp, tx.Hash, tx.RawTx, tx.Origin})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.txs.Write(row)
+	s.txs.WriteByte('\n')
+	s.txsCnt++
+	full := s.txsCnt >= s.batchSize
+	s.lock.Unlock()
+
+	if full {
+		s.requestFlush()
+	}
+	return nil
+}
+
+// WriteSighting buffers a row of the sourcelog table (ClickHouseSink is a SightingSink)
+func (s *ClickHouseSink) WriteSighting(sighting Sighting) error {
+	row, err := json.Marshal(clickHouseSightingRow{sighting.Timestamp, sighting.Hash, sighting.Source, sighting.Origin})
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.srcs.Write(row)
+	s.srcs.WriteByte('\n')
+	s.srcsCnt++
+	full := s.srcsCnt >= s.batchSize
+	s.lock.Unlock()
+
+	if full {
+		s.requestFlush()
+	}
+	return nil
+}
+
+// Close stops the flush loop, and inserts the remaining rows
+func (s *ClickHouseSink) Close() error {
+	close(s.closeC)
+	<-s.doneC
+	return nil
+}
+
+// requestFlush wakes up the flush loop, without blocking (a pending request covers all rows)
+func (s *ClickHouseSink) requestFlush() {
+	select {
+	case s.flushC <- struct{}{}:
+	default:
+	}
+}
+
+func (s *ClickHouseSink) flushLoop() {
+	defer close(s.doneC)
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-s.flushC:
+			s.flush()
+		case <-s.closeC:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush takes the buffered rows, and inserts them into their tables
+func (s *ClickHouseSink) flush() {
+	s.lock.Lock()
+	txs, txsCnt := bytes.Clone(s.txs.Bytes()), s.txsCnt
+	srcs, srcsCnt := bytes.Clone(s.srcs.Bytes()), s.srcsCnt
+	s.txs.Reset()
+	s.srcs.Reset()
+	s.txsCnt, s.srcsCnt = 0, 0
+	s.lock.Unlock()
+
+	if txsCnt > 0 {
+		if err := s.insert(s.txsTable, txs); err != nil {
+			s.dropped.Add(uint64(txsCnt))
+			s.log.Errorw("failed to insert into ClickHouse", "table", s.txsTable, "rows", txsCnt, "dropped_total", s.dropped.Load(), "error", err)
+		}
+	}
+	if srcsCnt > 0 {
+		if err := s.insert(s.srcTable, srcs); err != nil {
+			s.dropped.Add(uint64(srcsCnt))
+			s.log.Errorw("failed to insert into ClickHouse", "table", s.srcTable, "rows", srcsCnt, "dropped_total", s.dropped.Load(), "error", err)
+		}
+	}
+}
+
+// insert posts JSONEachRow rows to the table, as async insert (the server batches small inserts before writing a part)
+func (s *ClickHouseSink) insert(table string, rows []byte) error {
+	params := url.Values{}
+	params.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table))
+	params.Set("async_insert", "1")
+	params.Set("wait_for_async_insert", "1") // to get errors in the response
+	if s.database != "" {
+		params.Set("database", s.database)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"?"+params.Encode(), bytes.NewReader(rows))
+	if err != nil {
+		return err
+	}
+	if s.user != "" {
+		req.Header.Set("X-ClickHouse-User", s.user)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: %s: %s", ErrClickHouseInsert, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/flashbots/mempool-dumpster/common"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+)
+
+// sourceDedup suppresses repeats of a tx by a single source within a short window, before they are sent to the
+// processor. The processor dedups globally anyway, but every repeat takes channel capacity from all sources. The
+// cache is bounded to sourceDedupMaxHashes (the oldest hashes are evicted first), and suppressions are counted and
+// logged at most once per sourceDedupLogInterval. A nil sourceDedup allows everything.
+type sourceDedup struct {
+	log    *zap.SugaredLogger
+	window time.Duration
+
+	lock    sync.Mutex
+	seen    map[ethcommon.Hash]time.Time
+	queue   []hashIn // in order of the first sighting, for expiry and eviction
+	lastLog time.Time
+
+	suppressed      atomic.Uint64 // since the last log entry
+	suppressedTotal atomic.Uint64
+}
+
+// newSourceDedup returns a dedup cache for the given window, or nil if the window is 0 (disabled)
+func newSourceDedup(log *zap.SugaredLogger, window time.Duration) *sourceDedup {
+	if window <= 0 {
+		return nil
+	}
+
+	return &sourceDedup{ //nolint:exhaustruct
+		log:     log,
+		window:  window,
+		seen:    make(map[ethcommon.Hash]time.Time),
+		lastLog: time.Now(),
+	}
+}
+
+// allow returns whether the tx hash wasn't seen from this source within the window, and counts it as suppressed otherwise
+func (d *sourceDedup) allow(hash ethcommon.Hash) bool {
+	if d == nil {
+		return true
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// expire the hashes older than the window, and evict the oldest ones if the cache is full
+	now := time.Now()
+	for len(d.queue) > 0 && (now.Sub(d.queue[0].t) > d.window || len(d.queue) >= sourceDedupMaxHashes) {
+		delete(d.seen, d.queue[0].hash)
+		d.queue = d.queue[1:]
+	}
+
+	if _, ok := d.seen[hash]; !ok {
+		d.seen[hash] = now
+		d.queue = append(d.queue, hashIn{now, hash})
+		return true
+	}
+
+	d.suppressed.Inc()
+	d.suppressedTotal.Inc()
+	if now.Sub(d.lastLog) >= sourceDedupLogInterval {
+		d.lastLog = now
+		d.log.Infow("suppressed repeated txs of the source",
+			"window", d.window.String(),
+			"suppressed", common.Printer.Sprint(d.suppressed.Swap(0)),
+			"suppressed_total", common.Printer.Sprint(d.suppressedTotal.Load()),
+		)
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrInvalidTLSConfig = errors.New("invalid TLS config")
+
+// LoadTLSConfig returns a TLS config for connections to endpoints requiring mutual TLS, with the client certificate
+// and key, and optionally a CA certificate to verify the server (default: system roots). Returns nil if all paths are empty.
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12} //nolint:exhaustruct
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("%w: client certificate and key are both required", ErrInvalidTLSConfig)
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if caFile != "" {
+		caCert, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("%w: no certificates found in %s", ErrInvalidTLSConfig, caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	return config, nil
+}
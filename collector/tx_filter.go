@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var ErrInvalidSelector = errors.New("invalid function selector (must be 4 bytes as hex, i.e. 0x7ff36ab5)")
+
+// ParseSelectors parses 4-byte function selectors in the format 0x<8 hex chars>[,...], i.e. "0x7ff36ab5,0x38ed1739"
+func ParseSelectors(s string) ([][4]byte, error) {
+	selectors := [][4]byte{}
+	if s == "" {
+		return selectors, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		b, err := hexutil.Decode(strings.TrimSpace(entry))
+		if err != nil || len(b) != 4 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSelector, entry)
+		}
+		selectors = append(selectors, [4]byte(b))
+	}
+	return selectors, nil
+}
+
+// filterTx returns whether the tx passes the selector and contract creation filter (always if it's disabled), and the
+// trash notes if not. Contract creations pass only with contractCreations, calls only with an allowed selector as the
+// first 4 bytes of their calldata (calls with shorter calldata, i.e. plain transfers, never pass).
+func (p *TxProcessor) filterTx(tx *types.Transaction) (ok bool, notes string) {
+	if !p.filterTxs {
+		return true, ""
+	}
+	if tx.To() == nil {
+		return p.contractCreations, "contract-creation"
+	}
+
+	data := tx.Data()
+	if len(data) < 4 {
+		return false, fmt.Sprintf("calldata_size=%d", len(data))
+	}
+	return p.selectors[[4]byte(data[:4])], "selector=" + hexutil.Encode(data[:4])
+}
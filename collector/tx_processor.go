@@ -1,32 +1,233 @@
 package collector
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/flashbots/mempool-dumpster/common"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 )
 
+var ErrInvalidOutputMode = errors.New("invalid output mode")
+
+// OutStdout as OutDir makes the processor write the transactions to stdout instead of files
+const OutStdout = "-"
+
+// Policies for failed writes to the output files (i.e. a full disk), see TxProcessorOpts.OnWriteError
+const (
+	OnWriteErrorDrop  = "drop"  // log and drop the record (default)
+	OnWriteErrorPause = "pause" // drop the record and pause the output (drop all txs), with exponential backoff until writes succeed again
+	OnWriteErrorExit  = "exit"  // exit the process (i.e. to be restarted and alerted on by the supervisor)
+)
+
+type TxProcessorOpts struct {
+	Log *zap.SugaredLogger
+
+	// OutDir is the directory for the bucketed CSV files. Alternatively it can be OutStdout or the path of a named pipe
+	// (FIFO), in which case only the transactions are written to that single stream (no sourcelog, trash, replacements or source txs).
+	OutDir string
+
+	UID               string
+	WriteSourcelog    bool   // whether to record source stats (a CSV file with timestamp,hash,source)
+	TrackReplacements bool   // whether to record txs replacing a previous one with same sender+nonce (a CSV file with timestamp_ms,from,nonce,prev_hash,hash,source)
+	ChainID           int64  // EIP-155 chain ID used for sender recovery (default: 1, mainnet)
+	WriteSourceTxs    bool   // whether to record the raw tx once per source (a CSV file with timestamp_ms,hash,source,raw_tx), to detect sources altering payloads
+	WriteTrash        bool   // whether to record txs which are not written to the txs file (a CSV file with timestamp_ms,hash,source,reason,notes)
+	WritePerSource    bool   // whether to write the txs of each source to its own file (timestamp_ms,hash,raw_tx), without dedup across sources
+	WriteSignature    bool   // add the signature columns y_parity,r,s to the txs file (and stream)
+	WriteCalldataGas  bool   // add the columns calldata_size,intrinsic_gas to the txs file (and stream), after the signature
+	MaxTxBytes        int    // txs with a larger raw size are written to the trash instead of the txs file (0 = no limit)
+	VerifySignatures  bool   // txs whose signature doesn't recover a sender (with the ChainID signer) are written to the trash (CPU-costly)
+	Sinks             []Sink // optional, receive all newly processed transactions in addition to the txs file (i.e. the TxStream)
+
+	// Selectors only writes calls whose calldata begins with one of these 4-byte function selectors to the txs file,
+	// and ContractCreations contract creations (txs without a to address), i.e. for datasets of single protocols. Other
+	// txs are written to the trash (common.TrashFiltered). All txs are written if both are unset.
+	Selectors         [][4]byte
+	ContractCreations bool
+
+	// Origin is appended as last column to every txs and sourcelog row if set (i.e. "<uid>@<hostname>"), so the
+	// collector of each row is known after merging the outputs of many collectors. Must not contain commas.
+	Origin string
+
+	// OnWriteError is the policy for failed writes to the output files: OnWriteErrorDrop (default), OnWriteErrorPause
+	// or OnWriteErrorExit. While paused, new txs are dropped (and counted) until the next retry, so the connections are
+	// never blocked.
+	OnWriteError string
+
+	// DailyFiles writes one file per day (and category) instead of one per hour, i.e. for low-volume chains. The rows
+	// keep their full timestamps, and the files of a day are closed an hour after its end.
+	DailyFiles bool
+
+	// WriteHeader writes a header row with the column names as first line of each new bucket file (i.e. for
+	// spreadsheets), matching the optional columns. Not written to the stream. The loaders of the merger and analyzer skip it.
+	WriteHeader bool
+
+	// Compression is the codec of the bucket files: common.CompressionNone (default), Gzip, Zstd or Snappy. The
+	// extension is appended to the filenames (i.e. .csv.zst). Compressed data is only complete on disk once the file is
+	// closed (at the end of the bucket, or on rotation), so the current bucket can't be tailed. The stream isn't compressed.
+	Compression string
+
+	// CompressionPerFile overrides Compression for single file categories, keyed by their subdirectory (i.e.
+	// {"sourcelog": common.CompressionGzip} compresses only the sourcelog, the largest file, and keeps the txs plain).
+	// See ParseCompressionPerFile.
+	CompressionPerFile map[string]string
+
+	// BufferFlushInterval buffers the writes to the bucket files in memory and flushes them at this interval, instead
+	// of a write syscall per line (0 = unbuffered). Up to an interval of rows is lost if the process is killed, but
+	// buffers are flushed when a file is closed, and by Close (on shutdown). The stream isn't buffered.
+	BufferFlushInterval time.Duration
+
+	// TxsShards splits the txs file of each bucket into this many files by tx hash (hash % TxsShards), i.e. for
+	// parallel downstream processing (0 or 1 = a single file). The other files are not sharded.
+	TxsShards int
+
+	// TxChannelSize is the buffer size of the channel from all connections to the processor (default: 100). The tx
+	// timestamps are taken by the connections before sending into it, but if it's full, sending blocks the connection's
+	// read loop, which delays the timestamps of the following txs of that source. A larger buffer absorbs bursts.
+	TxChannelSize int
+
+	// FileMode and DirMode are the permissions of created output files and directories. Configured modes are applied
+	// exactly, with a chmod after the creation, as the umask masks the creation mode and mkdir ignores the setgid bit on
+	// Linux. The defaults (0o600 and 0o777) are subject to the umask. See ValidateOutputModes.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+
+	// Retention removes date directories of the output directory whose day ended more than this long ago (0 = keep
+	// all), i.e. to run on small disks without external log rotation. Directories with open files are kept. With
+	// GCSURL, it should leave enough time for the uploads.
+	Retention time.Duration
+
+	// GCSURL is an optional gs://<bucket>[/<prefix>] URL to upload the files of closed buckets to (with gsutil, using
+	// the local directory layout), GCSDeleteLocal removes the local files after a successful upload. The open buckets
+	// are uploaded by Close (on shutdown). Late txs of a closed bucket go to new files with the next rotation suffix.
+	GCSURL         string
+	GCSDeleteLocal bool
+
+	// SourcelogTimestampResolution is the resolution of the sourcelog timestamps (common.SourcelogTimestampMs/Us/Ns, default: ms).
+	// Provider latency differences are often sub-millisecond.
+	SourcelogTimestampResolution string
+
+	// SourcelogFirstOnly records only the first sighting of a tx by each source in the sourcelog, which is all the
+	// analyzer uses. Repeated sightings are recognized within txCacheTime.
+	SourcelogFirstOnly bool
+
+	// ReentryWindow is how long hashes are remembered to count txs which are seen again after they were removed
+	// from the tx cache (after txCacheTime), which would be recorded again. Diagnostic only (0 = disabled).
+	ReentryWindow time.Duration
+
+	// StatsInterval is how often the stats are logged, and the caches are cleaned up (default: 1 min).
+	// StatsResetInterval is how often the tx counters are reset (default 0: with every stats log). Counters are
+	// accumulated over longer windows if it's larger, and never reset if it's negative (cumulative totals). The
+	// per-source unique tx counts are always reset with every stats log, to bound the memory of their hash sets.
+	StatsInterval      time.Duration
+	StatsResetInterval time.Duration
+
+	// Warmup excludes the txs received within this window after the start from the first-seen counters
+	// (source_stats_first) and the latency leaderboard (0 = disabled). The sources connect at slightly different
+	// times, so the first connected one would win all txs until the others are connected. The txs are recorded as usual.
+	Warmup time.Duration
+
+	// Clock is used for the expiry of cached txs and open bucket files (default: system time). Tx timestamps are
+	// taken by the connections.
+	Clock Clock
+}
+
+// OutFiles holds the output file handles of a single bucket (files that are not written are nil)
+type OutFiles struct {
+	FTxs          OutputFile   // the first shard, if the txs file is sharded
+	FTxsShards    []OutputFile // all txs files, if the txs file is sharded (see txsFile)
+	FSourcelog    OutputFile
+	FReplacements OutputFile
+	FSourceTxs    OutputFile
+	FTrash        OutputFile // txs that are not recorded (format: timestamp_ms,hash,source,reason,notes)
+
+	// per-source txs files of the bucket, opened on the first tx of a source (see perSourceFile)
+	fPerSource    map[string]OutputFile
+	perSourceLock sync.Mutex
+	bucketTime    time.Time
+	rotation      int
+
+	// number of lines written to each file (a bucket with suspiciously few lines indicates a feed outage)
+	cntTxs          atomic.Uint64
+	cntSourcelog    atomic.Uint64
+	cntReplacements atomic.Uint64
+	cntSourceTxs    atomic.Uint64
+	cntTrash        atomic.Uint64
+	cntPerSource    atomic.Uint64
+}
+
+// hashSource identifies the sighting of a tx by a given source
+type hashSource struct {
+	hash   ethcommon.Hash
+	source string
+}
+
+// senderNonce identifies a pending transaction slot, which can be replaced by another tx with higher fees
+type senderNonce struct {
+	from  ethcommon.Address
+	nonce uint64
+}
+
+type pendingTx struct {
+	hash ethcommon.Hash
+	t    time.Time
+}
+
 type TxProcessor struct {
 	log    *zap.SugaredLogger
 	uid    string
 	outDir string
 	txC    chan TxIn // note: it's important that the value is sent in here instead of a pointer, otherwise there are memory race conditions
+	clock  Clock
+
+	fileMode   os.FileMode // creation mode of output files
+	dirMode    os.FileMode // creation mode of output directories
+	chmodFiles bool        // whether the file mode is configured, and applied with a chmod after the creation
+	chmodDirs  bool        // whether the directory mode is configured, and applied with a chmod after the creation
+
+	bufferFlushInterval time.Duration // 0 if the output files are unbuffered
+	retention           time.Duration // 0 if old date directories are kept
 
-	outFilesLock      sync.RWMutex
-	outFilesTxs       map[int64]*os.File
-	outFilesSourcelog map[int64]*os.File
+	gcsURL         string
+	gcsDeleteLocal bool
+	gsutil         string         // the gsutil command
+	uploads        sync.WaitGroup // running uploads, waited for by Close
+
+	outFilesLock sync.RWMutex
+	outFiles     map[int64]*OutFiles
+	streamFiles  *OutFiles          // set if writing to stdout or a named pipe instead of bucketed files
+	rotations    map[int64]int      // number of times the files of a bucket were rotated (part of the new filenames)
+	rotateC      chan chan struct{} // rotation requests, the processing loop closes the ack channel when done
+
+	// the cleanup task requests the closing of expired buckets from the processing loop, which closes the ack channel
+	// when done, so no file is closed while a tx is being written to it
+	closeExpiredC chan chan struct{}
+	closedBuckets atomic.Uint64 // buckets closed since the last stats log
+
+	running atomic.Bool   // set once Start is called
+	stopC   chan struct{} // closed by Close, to stop the processing loop
+	doneC   chan struct{} // closed when the processing loop returned
 
 	txn     map[ethcommon.Hash]time.Time
 	txnLock sync.RWMutex
 
+	txnPerSource     map[hashSource]time.Time // (hash, source) sightings, for writeSourceTxs and sourcelogFirst
+	txnPerSourceLock sync.Mutex
+
 	txCnt atomic.Uint64
 
 	srcCntFirst     map[string]uint64
@@ -36,46 +237,327 @@ type TxProcessor struct {
 	srcCntUnique  map[string]map[string]bool
 	srcCntAllLock sync.RWMutex
 
-	writeSourcelog bool // whether to record source stats (a CSV file with timestamp_ms,hash,source)
+	leaderboard *latencyLeaderboard
+	rxBytes     *rxBytesCounter // bytes received per source, counted by the connections
+
+	writeSourcelog bool   // whether to record source stats (a CSV file with timestamp,hash,source)
+	sourcelogFirst bool   // whether to record only the first sighting per source in the sourcelog
+	writeSourceTxs bool   // whether to record the raw tx once per source
+	trashEnabled   bool   // whether to record txs which are not written to the txs file
+	writePerSource bool   // whether to write the txs of each source to its own file
+	sourcelogTSRes string // resolution of the sourcelog timestamps
+
+	signer         types.Signer // for sender recovery
+	verifySigs     bool         // whether to trash txs with an invalid signature
+	writeSignature bool
+	writeCalldata  bool // calldata_size,intrinsic_gas columns
+	maxTxBytes     int
+	compressionPer map[string]string
+	txsShards      int    // number of txs files per bucket (0 or 1 = a single file)
+	compression    string // codec of the bucket files, unless overridden for their subdirectory in compressionPer
+	writeHeader    bool   // whether to write a header row to new bucket files
+	bucketSec      int64  // size of the buckets (bucketMinutes, or bucketMinutesDaily)
+	sinks          []Sink // the txs file, followed by the additional sinks
+	sightingSinks  []SightingSink
+	origin         string // appended to the txs and sourcelog rows, if set
+
+	filterTxs         bool             // whether the selector and contract creation filter is enabled
+	selectors         map[[4]byte]bool // function selectors of the calls which pass the filter
+	contractCreations bool             // whether contract creations pass the filter
+
+	onWriteError    string
+	writeErrCnt     atomic.Uint64 // failed writes since the start (never reset)
+	writeErrBackoff time.Duration // current pause after a failed write (OnWriteErrorPause), 0 after a successful write
+
+	writePausedUntil time.Time     // new txs are dropped until then, after a failed write (OnWriteErrorPause)
+	pausedDropCnt    atomic.Uint64 // txs dropped while paused since the start (never reset)
+
+	trackReplacements bool
+	pendingTxs        map[senderNonce]pendingTx // latest tx seen for a given sender+nonce
+	pendingTxsLock    sync.Mutex
+	replacementCnt    atomic.Uint64
+
+	statsInterval      time.Duration
+	statsResetInterval time.Duration
+	statsLastReset     time.Time
+	warmupUntil        time.Time // txs received before are not counted as first-seen (zero if no warmup)
+
+	reentryWindow  time.Duration
+	reentryTxs     map[ethcommon.Hash]time.Time // hashes seen within reentryWindow
+	reentryTxsLock sync.Mutex
+	reentryCnt     atomic.Uint64
 }
 
-func NewTxProcessor(log *zap.SugaredLogger, outDir, uid string, writeSourcelog bool) *TxProcessor {
-	return &TxProcessor{ //nolint:exhaustruct
-		log: log, // .With("uid", uid),
-		txC: make(chan TxIn, 100),
-		uid: uid,
+func NewTxProcessor(opts TxProcessorOpts) *TxProcessor {
+	chainID := opts.ChainID
+	if chainID == 0 {
+		chainID = defaultChainID
+	}
+
+	fileMode := opts.FileMode
+	if fileMode == 0 {
+		fileMode = defaultFileMode
+	}
+	dirMode := opts.DirMode
+	if dirMode == 0 {
+		dirMode = os.ModePerm
+	}
+
+	txChannelSize := opts.TxChannelSize
+	if txChannelSize == 0 {
+		txChannelSize = defaultTxChannelSize
+	}
+
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	statsInterval := opts.StatsInterval
+	if statsInterval == 0 {
+		statsInterval = defaultStatsInterval
+	}
+
+	p := &TxProcessor{ //nolint:exhaustruct
+		log:   opts.Log, // .With("uid", uid),
+		txC:   make(chan TxIn, txChannelSize),
+		uid:   opts.UID,
+		clock: clock,
+
+		outDir:    opts.OutDir,
+		outFiles:  make(map[int64]*OutFiles),
+		rotations: make(map[int64]int),
+		rotateC:   make(chan chan struct{}),
+		stopC:     make(chan struct{}),
+		doneC:     make(chan struct{}),
+		fileMode:  fileMode,
+		dirMode:   dirMode,
+
+		closeExpiredC: make(chan chan struct{}),
 
-		outDir:            outDir,
-		outFilesTxs:       make(map[int64]*os.File),
-		outFilesSourcelog: make(map[int64]*os.File),
+		chmodFiles: opts.FileMode != 0,
+		chmodDirs:  opts.DirMode != 0,
+
+		bufferFlushInterval: opts.BufferFlushInterval,
+		retention:           opts.Retention,
+
+		gcsURL:         opts.GCSURL,
+		gcsDeleteLocal: opts.GCSDeleteLocal,
+		gsutil:         "gsutil",
 
 		txn:            make(map[ethcommon.Hash]time.Time),
 		srcCntFirst:    make(map[string]uint64),
 		srcCntAll:      make(map[string]uint64),
 		srcCntUnique:   make(map[string]map[string]bool),
-		writeSourcelog: writeSourcelog,
+		leaderboard:    newLatencyLeaderboard(),
+		rxBytes:        newRxBytesCounter(),
+		writeSourcelog: opts.WriteSourcelog,
+		writeSourceTxs: opts.WriteSourceTxs,
+		sourcelogFirst: opts.SourcelogFirstOnly,
+		trashEnabled:   opts.WriteTrash,
+		writePerSource: opts.WritePerSource,
+		txnPerSource:   make(map[hashSource]time.Time),
+		sourcelogTSRes: opts.SourcelogTimestampResolution,
+		signer:         types.LatestSignerForChainID(big.NewInt(chainID)),
+		verifySigs:     opts.VerifySignatures,
+		writeSignature: opts.WriteSignature,
+		writeCalldata:  opts.WriteCalldataGas,
+		maxTxBytes:     opts.MaxTxBytes,
+		txsShards:      opts.TxsShards,
+		compression:    opts.Compression,
+		compressionPer: opts.CompressionPerFile,
+		writeHeader:    opts.WriteHeader,
+		bucketSec:      bucketMinutes * 60,
+		origin:         opts.Origin,
+		onWriteError:   opts.OnWriteError,
+
+		trackReplacements: opts.TrackReplacements,
+		pendingTxs:        make(map[senderNonce]pendingTx),
+
+		filterTxs:         len(opts.Selectors) > 0 || opts.ContractCreations,
+		selectors:         make(map[[4]byte]bool),
+		contractCreations: opts.ContractCreations,
+
+		statsInterval:      statsInterval,
+		statsResetInterval: opts.StatsResetInterval,
+		statsLastReset:     clock.Now(),
+
+		reentryWindow: opts.ReentryWindow,
+		reentryTxs:    make(map[ethcommon.Hash]time.Time),
+	}
+	if opts.Warmup > 0 {
+		p.warmupUntil = clock.Now().Add(opts.Warmup)
 	}
+	for _, selector := range opts.Selectors {
+		p.selectors[selector] = true
+	}
+	if opts.DailyFiles {
+		p.bucketSec = bucketMinutesDaily * 60
+	}
+
+	p.sinks = append([]Sink{&txsFileSink{p}}, opts.Sinks...)
+	for _, sink := range opts.Sinks {
+		if sightingSink, ok := sink.(SightingSink); ok {
+			p.sightingSinks = append(p.sightingSinks, sightingSink)
+		}
+	}
+	return p
 }
 
 func (p *TxProcessor) Start() {
-	// Ensure output directory exists
-	err := os.MkdirAll(p.outDir, os.ModePerm)
+	p.running.Store(true)
+	defer close(p.doneC)
+
+	stream, err := openOutputStream(p.outDir)
 	if err != nil {
-		p.log.Error(err)
+		p.log.Errorw("failed to open output stream", "error", err)
 		return
 	}
 
+	if stream != nil {
+		p.log.Infow("writing transactions to stream, sourcelog/trash/replacements/source txs are disabled", "out", p.outDir)
+		p.streamFiles = &OutFiles{FTxs: stream} //nolint:exhaustruct
+		p.writeSourcelog = false
+		p.writeSourceTxs = false
+		p.trashEnabled = false
+		p.writePerSource = false
+		p.trackReplacements = false
+	} else {
+		// Ensure output directory exists
+		err = p.mkdirAll(p.outDir)
+		if err != nil {
+			p.log.Error(err)
+			return
+		}
+	}
+
 	p.log.Debug("Waiting for transactions...")
 
 	// start the txn map cleaner background task
 	go p.cleanupBackgroundTask()
 
-	// start listening for transactions coming in through the channel
-	for txIn := range p.txC {
-		p.processTx(txIn)
+	// periodically flush the buffered output files (a nil channel never fires, if unbuffered)
+	var flushC <-chan time.Time
+	if p.bufferFlushInterval > 0 && p.streamFiles == nil {
+		ticker := time.NewTicker(p.bufferFlushInterval)
+		defer ticker.Stop()
+		flushC = ticker.C
+	}
+
+	// start listening for transactions coming in through the channel (and rotation, flush and close requests, which are
+	// handled here so no file is closed or flushed while a tx is being written)
+	for {
+		select {
+		case txIn := <-p.txC:
+			p.processTx(txIn)
+		case ack := <-p.rotateC:
+			p.rotate()
+			close(ack)
+		case ack := <-p.closeExpiredC:
+			p.closeExpiredBuckets(p.clock.Now())
+			close(ack)
+		case <-flushC:
+			p.flush()
+		case <-p.stopC:
+			p.drain()
+			return
+		}
 	}
 }
 
+// drain processes the txs which are still queued in the channel
+func (p *TxProcessor) drain() {
+	for {
+		select {
+		case txIn := <-p.txC:
+			p.processTx(txIn)
+		default:
+			return
+		}
+	}
+}
+
+// Rotate closes all open files (i.e. to get a consistent snapshot for a backup). New txs are written to new files,
+// with the rotation count as filename suffix. Returns after the files are closed, or right away if the processor is
+// closed (which closes the files anyway).
+func (p *TxProcessor) Rotate() {
+	ack := make(chan struct{})
+	select {
+	case p.rotateC <- ack:
+		<-ack
+	case <-p.stopC:
+	case <-p.doneC:
+	}
+}
+
+// Close stops the processing loop, closes all sinks, and closes the output files, which flushes the buffers and
+// finishes the compressed streams (i.e. on shutdown). With GCSURL, it uploads the files and returns once all uploads
+// are finished.
+func (p *TxProcessor) Close() {
+	close(p.stopC)
+	if p.running.Load() {
+		<-p.doneC
+	}
+
+	for _, sink := range p.sinks {
+		if err := sink.Close(); err != nil {
+			p.log.Errorw("sink.Close", "error", err)
+		}
+	}
+	p.closeFiles()
+	p.uploads.Wait()
+}
+
+// closeFiles closes (and uploads) the files of all open buckets, and closes the output stream
+func (p *TxProcessor) closeFiles() {
+	p.outFilesLock.Lock()
+	defer p.outFilesLock.Unlock()
+
+	for timestamp, outFiles := range p.outFiles {
+		delete(p.outFiles, timestamp)
+		p.closeBucket(timestamp, outFiles)
+	}
+	if p.streamFiles != nil {
+		for _, file := range p.streamFiles.all() {
+			if err := file.Close(); err != nil {
+				p.log.Errorw("failed to close file", "filename", file.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// flush writes the buffers of all open output files to disk (a noop if the files are unbuffered)
+func (p *TxProcessor) flush() {
+	p.outFilesLock.RLock()
+	defer p.outFilesLock.RUnlock()
+	for _, outFiles := range p.outFiles {
+		for _, file := range outFiles.all() {
+			bf, ok := file.(*bufferedFile)
+			if !ok {
+				continue
+			}
+			if err := bf.Flush(); err != nil {
+				p.log.Errorw("failed to flush file", "filename", bf.Name(), "error", err)
+			}
+		}
+	}
+}
+
+func (p *TxProcessor) rotate() {
+	if p.streamFiles != nil {
+		return
+	}
+
+	p.outFilesLock.Lock()
+	defer p.outFilesLock.Unlock()
+	for timestamp, outFiles := range p.outFiles {
+		p.closeBucket(timestamp, outFiles)
+		delete(p.outFiles, timestamp)
+		p.rotations[timestamp] = outFiles.rotation + 1
+	}
+	p.log.Infow("rotated output files")
+}
+
 func (p *TxProcessor) processTx(txIn TxIn) {
 	txHash := txIn.Tx.Hash()
 	log := p.log.With("tx_hash", txHash.Hex())
@@ -83,50 +565,96 @@ func (p *TxProcessor) processTx(txIn TxIn) {
 
 	// count all transactions per source
 	p.srcCntAllLock.Lock()
-	p.srcCntAll[txIn.Source]++
-	if p.srcCntUnique[txIn.Source] == nil {
-		p.srcCntUnique[txIn.Source] = make(map[string]bool)
+	p.srcCntAll[txIn.Source.Name]++
+	if p.srcCntUnique[txIn.Source.Name] == nil {
+		p.srcCntUnique[txIn.Source.Name] = make(map[string]bool)
 	}
-	p.srcCntUnique[txIn.Source][txHash.Hex()] = true
+	p.srcCntUnique[txIn.Source.Name][txHash.Hex()] = true
 	p.srcCntAllLock.Unlock()
 
+	// drop the tx while the output is paused after a failed write (it's still written if sent by another source after
+	// the pause, as it's not marked as processed)
+	if p.clock.Now().Before(p.writePausedUntil) {
+		p.pausedDropCnt.Inc()
+		return
+	}
+
 	// get output file handles
-	fTx, fSourcelog, isCreated, err := p.getOutputCSVFiles(txIn.T.Unix())
+	outFiles, isCreated, err := p.getOutputCSVFiles(txIn.T.Unix())
 	if err != nil {
-		log.Errorw("getOutputCSVFiles", "error", err)
+		p.writeError(log, "getOutputCSVFiles", err)
 		return
 	} else if isCreated {
-		p.log.Infof("new file created: %s", fTx.Name())
-		if p.writeSourcelog {
-			p.log.Infof("new file created: %s", fSourcelog.Name())
+		for _, f := range outFiles.all() {
+			p.log.Infof("new file created: %s", f.Name())
 		}
 	}
 
+	// remember the first sighting of this tx by this source (only needed for the sourcelog filter and the source txs)
+	firstBySource := true
+	if p.writeSourceTxs || p.writePerSource || (p.sourcelogFirst && (p.writeSourcelog || len(p.sightingSinks) > 0)) {
+		firstBySource = p.markSeenBySource(hashSource{txHash, txIn.Source.Name}, txIn.T)
+	}
+
 	// record source stats
-	if p.writeSourcelog {
-		_, err = fmt.Fprintf(fSourcelog, "%d,%s,%s\n", txIn.T.UnixMilli(), txHash.Hex(), txIn.Source)
+	if p.writeSourcelog && (firstBySource || !p.sourcelogFirst) {
+		if p.origin != "" {
+			_, err = fmt.Fprintf(outFiles.FSourcelog, "%d,%s,%s,%s\n", common.SourcelogTimestamp(txIn.T, p.sourcelogTSRes), txHash.Hex(), txIn.Source.Name, p.origin)
+		} else {
+			_, err = fmt.Fprintf(outFiles.FSourcelog, "%d,%s,%s\n", common.SourcelogTimestamp(txIn.T, p.sourcelogTSRes), txHash.Hex(), txIn.Source.Name)
+		}
 		if err != nil {
-			log.Errorw("fmt.Fprintf", "error", err)
+			p.writeError(log, "fmt.Fprintf", err)
 			return
 		}
+		outFiles.cntSourcelog.Inc()
+	}
+
+	// pass the sighting to the sinks which want them (with the same filter as the sourcelog)
+	if len(p.sightingSinks) > 0 && (firstBySource || !p.sourcelogFirst) {
+		sighting := Sighting{Timestamp: txIn.T.UnixMilli(), Hash: txHash.Hex(), Source: txIn.Source.Name, Origin: p.origin}
+		for _, sink := range p.sightingSinks {
+			if err = sink.WriteSighting(sighting); err != nil {
+				p.writeError(log, "sink.WriteSighting", err)
+			}
+		}
 	}
 
+	// record the raw tx once per source
+	if p.writeSourceTxs && firstBySource {
+		p.writeSourceTx(log, outFiles, txIn)
+	}
+
+	// write the tx to the file of the source (once per source, without dedup across sources)
+	if p.writePerSource && firstBySource {
+		p.writePerSourceTx(log, outFiles, txIn)
+	}
+
+	// txs received during the warmup are not counted as first-seen, as not all sources are connected yet
+	inWarmup := txIn.T.Before(p.warmupUntil)
+
 	// process transactions only once
 	p.txnLock.RLock()
 	_, ok := p.txn[txHash]
 	p.txnLock.RUnlock()
 	if ok {
 		log.Debug("transaction already processed")
+		if !inWarmup {
+			p.leaderboard.later(txHash, txIn.Source.Name, txIn.T)
+		}
 		return
 	}
 
-	// Total unique tx count
-	p.txCnt.Inc()
-
-	// count first transactions per source (i.e. who delivers a given tx first)
-	p.srcCntFirstLock.Lock()
-	p.srcCntFirst[txIn.Source]++
-	p.srcCntFirstLock.Unlock()
+	// count txs which were already seen before, but removed from the tx cache
+	if p.reentryWindow > 0 {
+		p.reentryTxsLock.Lock()
+		if _, seen := p.reentryTxs[txHash]; seen {
+			p.reentryCnt.Inc()
+			log.Debug("tx seen again after it was removed from the tx cache")
+		}
+		p.reentryTxs[txHash] = txIn.T
+		p.reentryTxsLock.Unlock()
+	}
 
 	// create tx rlp
 	rlpHex, err := common.TxToRLPString(txIn.Tx)
@@ -135,174 +663,809 @@ func (p *TxProcessor) processTx(txIn TxIn) {
 		return
 	}
 
+	// don't write huge transactions (i.e. with giant calldata) to the txs file
+	rawTxSize := (len(rlpHex) - 2) / 2 // hex encoded, with 0x prefix
+	if p.maxTxBytes > 0 && rawTxSize > p.maxTxBytes {
+		log.Debugw("tx too large, trashing", "size", rawTxSize)
+		if outFiles.FTrash != nil {
+			p.writeTrash(log, outFiles, txIn, common.TrashTxTooLarge, fmt.Sprintf("size=%d", rawTxSize))
+		}
+		p.markProcessed(txHash, txIn.T)
+		return
+	}
+
+	// only write txs which pass the selector and contract creation filter to the txs file
+	if ok, notes := p.filterTx(txIn.Tx); !ok {
+		if outFiles.FTrash != nil {
+			p.writeTrash(log, outFiles, txIn, common.TrashFiltered, notes)
+		}
+		p.markProcessed(txHash, txIn.T)
+		return
+	}
+
+	// recover the sender only once, for all features which need it
+	var from ethcommon.Address
+	var senderErr error
+	if p.needsSender() {
+		from, senderErr = types.Sender(p.signer, txIn.Tx)
+		if senderErr != nil {
+			log.Debugw("failed to recover sender", "error", senderErr)
+		}
+	}
+
+	// don't write txs with an invalid signature (i.e. injected by a bad source) to the txs file
+	if p.verifySigs && senderErr != nil {
+		if outFiles.FTrash != nil {
+			p.writeTrash(log, outFiles, txIn, common.TrashInvalidSignature, "")
+		}
+		p.markProcessed(txHash, txIn.T)
+		return
+	}
+
+	// Total unique tx count, and the first transactions per source (i.e. who delivers a given tx first). Trashed txs
+	// are only counted in the trash file.
+	p.txCnt.Inc()
+	if !inWarmup {
+		p.leaderboard.first(txHash, txIn.Source.Name, txIn.T)
+		p.srcCntFirstLock.Lock()
+		p.srcCntFirst[txIn.Source.Name]++
+		p.srcCntFirstLock.Unlock()
+	}
+
 	// build the summary
 	txDetail := TxDetail{
 		Timestamp: txIn.T.UnixMilli(),
 		Hash:      txHash.Hex(),
 		RawTx:     rlpHex,
+		Origin:    p.origin,
 	}
 
-	_, err = fmt.Fprintf(fTx, "%d,%s,%s\n", txDetail.Timestamp, txDetail.Hash, txDetail.RawTx)
-	if err != nil {
-		log.Errorw("fmt.Fprintf", "error", err)
-		return
+	if p.writeSignature {
+		yParity, r, s := common.TxSignature(txIn.Tx)
+		txDetail.YParity = hexutil.EncodeUint64(yParity)
+		txDetail.R = hexutil.EncodeBig(r)
+		txDetail.S = hexutil.EncodeBig(s)
+	}
+
+	if p.writeCalldata {
+		calldataSize, intrinsicGas := uint64(len(txIn.Tx.Data())), common.IntrinsicGas(txIn.Tx)
+		txDetail.CalldataSize = &calldataSize
+		txDetail.IntrinsicGas = &intrinsicGas
+	}
+
+	// write the tx to all sinks (the txs file, and i.e. the live stream)
+	writeOK := true
+	for _, sink := range p.sinks {
+		if err = sink.Write(txDetail); err != nil {
+			writeOK = false
+			p.writeError(log, "sink.Write", err)
+		}
+	}
+	if writeOK {
+		p.writeErrBackoff = 0
 	}
 
 	// Remember that this transaction was processed
+	p.markProcessed(txHash, txIn.T)
+
+	if p.trackReplacements && senderErr == nil {
+		p.recordReplacement(log, txIn, from, outFiles)
+	}
+}
+
+// writeError handles a failed write to an output file (the record is dropped) according to the write error policy
+func (p *TxProcessor) writeError(log *zap.SugaredLogger, msg string, err error) {
+	p.writeErrCnt.Inc()
+	switch p.onWriteError {
+	case OnWriteErrorExit:
+		log.Fatalw(msg, "error", err)
+	case OnWriteErrorPause:
+		p.writeErrBackoff *= 2
+		if p.writeErrBackoff == 0 {
+			p.writeErrBackoff = initialBackoffSec * time.Second
+		} else if p.writeErrBackoff > maxBackoffSec*time.Second {
+			p.writeErrBackoff = maxBackoffSec * time.Second
+		}
+		p.writePausedUntil = p.clock.Now().Add(p.writeErrBackoff)
+		log.Errorw(msg, "error", err, "pause", p.writeErrBackoff.String())
+	default:
+		log.Errorw(msg, "error", err)
+	}
+}
+
+// needsSender returns whether any enabled feature needs the sender of new txs (the ECDSA recovery is expensive)
+func (p *TxProcessor) needsSender() bool {
+	return p.trackReplacements || p.verifySigs
+}
+
+// markProcessed remembers that a transaction was processed, so it's not processed again
+func (p *TxProcessor) markProcessed(txHash ethcommon.Hash, t time.Time) {
 	p.txnLock.Lock()
-	p.txn[txHash] = txIn.T
+	p.txn[txHash] = t
 	p.txnLock.Unlock()
 }
 
-// getOutputCSVFiles returns two file handles - one for the transactions and one for source stats, if needed - and a boolean indicating whether the file was created
-func (p *TxProcessor) getOutputCSVFiles(timestamp int64) (fTx, fSourcelog *os.File, isCreated bool, err error) {
-	// bucketTS := timestamp / secPerDay * secPerDay // down-round timestamp to start of bucket
-	sec := int64(bucketMinutes * 60)
-	bucketTS := timestamp / sec * sec // timestamp down-round to start of bucket
+// markSeenBySource remembers a sighting of a tx by a source, and returns whether it's the first one
+func (p *TxProcessor) markSeenBySource(key hashSource, t time.Time) bool {
+	p.txnPerSourceLock.Lock()
+	defer p.txnPerSourceLock.Unlock()
+	if _, ok := p.txnPerSource[key]; ok {
+		return false
+	}
+	p.txnPerSource[key] = t
+	return true
+}
+
+// writeSourceTx records the raw tx as received from a source
+func (p *TxProcessor) writeSourceTx(log *zap.SugaredLogger, outFiles *OutFiles, txIn TxIn) {
+	rlpHex, err := common.TxToRLPString(txIn.Tx)
+	if err != nil {
+		log.Errorw("failed to encode rlp", "error", err)
+		return
+	}
+
+	_, err = fmt.Fprintf(outFiles.FSourceTxs, "%d,%s,%s,%s\n", txIn.T.UnixMilli(), txIn.Tx.Hash().Hex(), txIn.Source.Name, rlpHex)
+	if err != nil {
+		p.writeError(log, "fmt.Fprintf", err)
+		return
+	}
+	outFiles.cntSourceTxs.Inc()
+}
+
+// writePerSourceTx writes a tx to the per-source txs file of its source
+func (p *TxProcessor) writePerSourceTx(log *zap.SugaredLogger, outFiles *OutFiles, txIn TxIn) {
+	f, err := p.perSourceFile(outFiles, txIn.Source.Name)
+	if err != nil {
+		p.writeError(log, "perSourceFile", err)
+		return
+	}
+
+	rlpHex, err := common.TxToRLPString(txIn.Tx)
+	if err != nil {
+		log.Errorw("failed to encode rlp", "error", err)
+		return
+	}
+
+	_, err = fmt.Fprintf(f, "%d,%s,%s\n", txIn.T.UnixMilli(), txIn.Tx.Hash().Hex(), rlpHex)
+	if err != nil {
+		p.writeError(log, "fmt.Fprintf", err)
+		return
+	}
+	outFiles.cntPerSource.Inc()
+}
+
+// perSourceFile returns the txs file of a source in the bucket, and opens it on the first tx of the source. The
+// files are named after the source, with the characters which are not safe in filenames replaced (i.e. of node URIs).
+func (p *TxProcessor) perSourceFile(outFiles *OutFiles, src string) (OutputFile, error) {
+	outFiles.perSourceLock.Lock()
+	defer outFiles.perSourceLock.Unlock()
+	if f, ok := outFiles.fPerSource[src]; ok {
+		return f, nil
+	}
+
+	f, err := p.openOutputCSVFile(outFiles.bucketTime, outFiles.rotation, "persource", "txs-"+safeFilename(src))
+	if err != nil {
+		return nil, err
+	}
+	p.log.Infof("new file created: %s", f.Name())
+	outFiles.fPerSource[src] = f
+	return f, nil
+}
+
+// safeFilename replaces all characters of s except letters, digits, '.' and '-' with '-'
+func safeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, s)
+}
+
+// writeTrash records a tx that is not written to the txs file, with the reason (and optional notes, without commas)
+func (p *TxProcessor) writeTrash(log *zap.SugaredLogger, outFiles *OutFiles, txIn TxIn, reason, notes string) {
+	_, err := fmt.Fprintf(outFiles.FTrash, "%d,%s,%s,%s,%s\n", txIn.T.UnixMilli(), txIn.Tx.Hash().Hex(), txIn.Source.Name, reason, notes)
+	if err != nil {
+		p.writeError(log, "fmt.Fprintf", err)
+		return
+	}
+	outFiles.cntTrash.Inc()
+}
+
+// recordReplacement remembers the tx as the latest one for its sender+nonce, and writes a replacements
+// CSV line if it supersedes a different pending tx (speed-up or cancel). A rebroadcast of the identical
+// tx (same hash) is not a replacement.
+func (p *TxProcessor) recordReplacement(log *zap.SugaredLogger, txIn TxIn, from ethcommon.Address, outFiles *OutFiles) {
+	txHash := txIn.Tx.Hash()
+	key := senderNonce{from, txIn.Tx.Nonce()}
+
+	p.pendingTxsLock.Lock()
+	prev, ok := p.pendingTxs[key]
+	p.pendingTxs[key] = pendingTx{txHash, txIn.T}
+	p.pendingTxsLock.Unlock()
+
+	if !ok || prev.hash == txHash {
+		return
+	}
+
+	p.replacementCnt.Inc()
+	_, err := fmt.Fprintf(outFiles.FReplacements, "%d,%s,%d,%s,%s,%s\n", txIn.T.UnixMilli(), from.Hex(), key.nonce, prev.hash.Hex(), txHash.Hex(), txIn.Source.Name)
+	if err != nil {
+		p.writeError(log, "fmt.Fprintf", err)
+		return
+	}
+	outFiles.cntReplacements.Inc()
+}
+
+// getOutputCSVFiles returns the file handles for the bucket of the given timestamp - transactions, and trash, sourcelog, replacements and source txs if needed - and a boolean indicating whether the files were created
+func (p *TxProcessor) getOutputCSVFiles(timestamp int64) (outFiles *OutFiles, isCreated bool, err error) {
+	if p.streamFiles != nil {
+		return p.streamFiles, false, nil
+	}
+
+	bucketTS := p.bucketTimestamp(timestamp)
 	t := time.Unix(bucketTS, 0).UTC()
 
 	// files may already be opened
-	var fTxOk, fSourcelogOk bool
 	p.outFilesLock.RLock()
-	fTx, fTxOk = p.outFilesTxs[bucketTS]
-	fSourcelog, fSourcelogOk = p.outFilesSourcelog[bucketTS]
+	outFiles, outFilesOk := p.outFiles[bucketTS]
 	p.outFilesLock.RUnlock()
+	if outFilesOk {
+		return outFiles, false, nil
+	}
 
-	if !fTxOk {
-		// open transaction file for writing
-		dir := filepath.Join(p.outDir, t.Format(time.DateOnly), "transactions")
-		err = os.MkdirAll(dir, os.ModePerm)
+	// check again and create the files under the write lock, so concurrent callers don't open the same files twice
+	p.outFilesLock.Lock()
+	defer p.outFilesLock.Unlock()
+	outFiles, outFilesOk = p.outFiles[bucketTS]
+	if outFilesOk {
+		return outFiles, false, nil
+	}
+	rotation := p.rotations[bucketTS]
+
+	// open transaction file(s) for writing
+	outFiles = &OutFiles{bucketTime: t, rotation: rotation, fPerSource: make(map[string]OutputFile)} //nolint:exhaustruct
+	if p.txsShards > 1 {
+		for shard := 0; shard < p.txsShards; shard++ {
+			f, err := p.openOutputCSVFile(t, rotation, "transactions", fmt.Sprintf("txs-shard%d", shard))
+			if err != nil {
+				return nil, false, err
+			}
+			outFiles.FTxsShards = append(outFiles.FTxsShards, f)
+		}
+		outFiles.FTxs = outFiles.FTxsShards[0]
+	} else {
+		outFiles.FTxs, err = p.openOutputCSVFile(t, rotation, "transactions", "txs")
 		if err != nil {
-			p.log.Error(err)
-			return nil, nil, false, err
+			return nil, false, err
 		}
+	}
 
-		fn := filepath.Join(dir, p.getFilename("txs", bucketTS))
-		fTx, err = os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if p.trashEnabled {
+		outFiles.FTrash, err = p.openOutputCSVFile(t, rotation, "trash", "trash")
 		if err != nil {
-			p.log.Errorw("os.Create", "error", err)
-			return nil, nil, false, err
+			return nil, false, err
 		}
 	}
 
-	if p.writeSourcelog && !fSourcelogOk {
-		// open sourcelog for writing
-		dir := filepath.Join(p.outDir, t.Format(time.DateOnly), "sourcelog")
-		err = os.MkdirAll(dir, os.ModePerm)
+	if p.writeSourcelog {
+		outFiles.FSourcelog, err = p.openOutputCSVFile(t, rotation, "sourcelog", "src")
 		if err != nil {
-			p.log.Error(err)
-			return nil, nil, false, err
+			return nil, false, err
 		}
+	}
 
-		fn := filepath.Join(dir, p.getFilename("src", bucketTS))
-		fSourcelog, err = os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if p.trackReplacements {
+		outFiles.FReplacements, err = p.openOutputCSVFile(t, rotation, "replacements", "repl")
 		if err != nil {
-			p.log.Errorw("os.Create", "error", err)
-			return nil, nil, false, err
+			return nil, false, err
+		}
+	}
+
+	if p.writeSourceTxs {
+		outFiles.FSourceTxs, err = p.openOutputCSVFile(t, rotation, "sourcetxs", "srctxs")
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	// record the opened files
+	p.outFiles[bucketTS] = outFiles
+	return outFiles, true, nil
+}
+
+// bucketTimestamp returns the start timestamp of the bucket for the given timestamp (in seconds)
+func (p *TxProcessor) bucketTimestamp(timestamp int64) int64 {
+	return timestamp / p.bucketSec * p.bucketSec // timestamp down-round to start of bucket
+}
+
+// bucketExpired returns whether the files of the bucket starting at the given timestamp are to be closed
+func (p *TxProcessor) bucketExpired(bucketTS int64, now time.Time) bool {
+	return now.Unix()-bucketTS > p.bucketSec+int64(bucketCloseDelay.Seconds())
+}
+
+// CurrentBucketLineCounts returns the number of lines written so far to the files of the current bucket
+// (or to the output stream, if not writing to files)
+func (p *TxProcessor) CurrentBucketLineCounts() (bucketTS int64, txs, sourcelog uint64) {
+	bucketTS = p.bucketTimestamp(p.clock.Now().UTC().Unix())
+	if p.streamFiles != nil {
+		return bucketTS, p.streamFiles.cntTxs.Load(), 0
+	}
+
+	p.outFilesLock.RLock()
+	defer p.outFilesLock.RUnlock()
+	if outFiles, ok := p.outFiles[bucketTS]; ok {
+		return bucketTS, outFiles.cntTxs.Load(), outFiles.cntSourcelog.Load()
+	}
+	return bucketTS, 0, 0
+}
+
+// SourceMetrics are the counters of a source, as logged with every stats log (source_stats_all/first/unique)
+type SourceMetrics struct {
+	All    uint64 // txs received, including repeats (since the last counter reset)
+	First  uint64 // txs received first of all sources (since the last counter reset)
+	Unique uint64 // distinct txs received (since the last stats log)
+}
+
+// SourceMetrics returns a snapshot of the current counters of all sources, without resetting them (i.e. for
+// tooling which polls them in between the stats logs)
+func (p *TxProcessor) SourceMetrics() map[string]SourceMetrics {
+	metrics := make(map[string]SourceMetrics)
+
+	p.srcCntAllLock.RLock()
+	for src, cnt := range p.srcCntAll {
+		m := metrics[src]
+		m.All = cnt
+		m.Unique = uint64(len(p.srcCntUnique[src]))
+		metrics[src] = m
+	}
+	p.srcCntAllLock.RUnlock()
+
+	p.srcCntFirstLock.RLock()
+	for src, cnt := range p.srcCntFirst {
+		m := metrics[src]
+		m.First = cnt
+		metrics[src] = m
+	}
+	p.srcCntFirstLock.RUnlock()
+
+	return metrics
+}
+
+// sortedSources returns the sources of a per-source map sorted alphabetically, so the stats logs list the sources in
+// the same order every time (easy to diff and parse)
+func sortedSources[V any](m map[string]V) []string {
+	sources := make([]string, 0, len(m))
+	for src := range m {
+		sources = append(sources, src)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// ValidateOutputModes checks that the output file mode only has permission bits and lets the owner read and write,
+// and that the directory mode has only permission bits (and optionally setgid, to inherit the group) and lets the owner
+// read, write and enter the directory. Zero values are valid (defaults).
+func ValidateOutputModes(fileMode, dirMode os.FileMode) error {
+	if fileMode != 0 && (fileMode&^os.ModePerm != 0 || fileMode&0o600 != 0o600) {
+		return fmt.Errorf("%w: file mode %v (must be a permission mode with owner read+write)", ErrInvalidOutputMode, fileMode)
+	}
+	if dirMode != 0 && (dirMode&^(os.ModePerm|os.ModeSetgid) != 0 || dirMode&0o700 != 0o700) {
+		return fmt.Errorf("%w: directory mode %v (must be a permission mode with owner read+write+execute)", ErrInvalidOutputMode, dirMode)
+	}
+	return nil
+}
+
+// openOutputStream returns stdout for OutStdout, or the opened named pipe if outDir is one. Otherwise it returns nil.
+func openOutputStream(outDir string) (*os.File, error) {
+	if outDir == OutStdout {
+		return os.Stdout, nil
+	}
+
+	fi, err := os.Stat(outDir)
+	if err != nil || fi.Mode()&os.ModeNamedPipe == 0 {
+		return nil, nil //nolint:nilerr // not a named pipe, write to files in the directory
+	}
+
+	// note: blocks until the reading end is opened
+	return os.OpenFile(outDir, os.O_WRONLY, 0)
+}
+
+// openOutputCSVFile opens (or creates) a CSV file for appending, in <outDir>/<date>/<subDir>/
+func (p *TxProcessor) openOutputCSVFile(bucketTime time.Time, rotation int, subDir, prefix string) (OutputFile, error) {
+	dir := filepath.Join(p.outDir, bucketTime.Format(time.DateOnly), subDir)
+	err := p.mkdirAll(dir)
+	if err != nil {
+		p.log.Error(err)
+		return nil, err
+	}
+
+	codec := p.compressionOf(subDir)
+	fn := filepath.Join(dir, p.getFilename(prefix, bucketTime.Unix(), rotation, codec))
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, p.fileMode)
+	if err != nil {
+		p.log.Errorw("os.Create", "error", err)
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if p.chmodFiles && fi.Mode().Perm() != p.fileMode {
+		if err = f.Chmod(p.fileMode); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	}
+
+	outFile, err := newOutputFile(f, codec)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if p.bufferFlushInterval > 0 {
+		outFile = newBufferedFile(outFile)
+	}
+
+	// only new files get a header, a reopened file already has one
+	if p.writeHeader && fi.Size() == 0 {
+		if _, err = fmt.Fprintln(outFile, p.csvHeader(subDir)); err != nil {
+			_ = outFile.Close()
+			return nil, err
+		}
+	}
+	return outFile, nil
+}
+
+// mkdirAll creates the directory and its missing parents. If the mode is configured, the created directories are
+// chmod'ed to the directory mode (the umask masks the mode of mkdir, which also ignores the setgid bit on Linux).
+func (p *TxProcessor) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, p.dirMode.Perm()); err != nil {
+		return err
+	}
+	if !p.chmodDirs {
+		return nil
+	}
+	for _, d := range missing {
+		if err := os.Chmod(d, p.dirMode); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// if one file was opened, record it now
-	if !fTxOk {
-		p.outFilesLock.Lock()
-		p.outFilesTxs[bucketTS] = fTx
-		if p.writeSourcelog && !fSourcelogOk {
-			p.outFilesSourcelog[bucketTS] = fSourcelog
+// csvHeader returns the header row of the files in the given subdirectory, with the enabled optional columns
+func (p *TxProcessor) csvHeader(subDir string) string {
+	switch subDir {
+	case "transactions":
+		header := "timestamp_ms,hash,raw_tx"
+		if p.writeSignature {
+			header += ",y_parity,r,s"
+		}
+		if p.writeCalldata {
+			header += ",calldata_size,intrinsic_gas"
+		}
+		if p.origin != "" {
+			header += ",origin"
+		}
+		return header
+	case "sourcelog":
+		res := p.sourcelogTSRes
+		if res == "" {
+			res = common.SourcelogTimestampMs
 		}
-		p.outFilesLock.Unlock()
-		isCreated = true
+		header := "timestamp_" + res + ",hash,source"
+		if p.origin != "" {
+			header += ",origin"
+		}
+		return header
+	case "trash":
+		return "timestamp_ms,hash,source,reason,notes"
+	case "replacements":
+		return "timestamp_ms,from,nonce,prev_hash,hash,source"
+	case "sourcetxs":
+		return "timestamp_ms,hash,source,raw_tx"
+	case "persource":
+		return "timestamp_ms,hash,raw_tx"
 	}
-	return fTx, fSourcelog, isCreated, nil
+	return ""
 }
 
-func (p *TxProcessor) getFilename(prefix string, timestamp int64) string {
+// compressionOf returns the codec of the files in the given subdirectory
+func (p *TxProcessor) compressionOf(subDir string) string {
+	if codec, ok := p.compressionPer[subDir]; ok {
+		return codec
+	}
+	return p.compression
+}
+
+func (p *TxProcessor) getFilename(prefix string, timestamp int64, rotation int, codec string) string {
 	t := time.Unix(timestamp, 0).UTC()
 	if prefix != "" {
 		prefix += "_"
 	}
-	return fmt.Sprintf("%s%s_%s.csv", prefix, t.Format("2006-01-02_15-04"), p.uid)
+	if rotation > 0 {
+		return fmt.Sprintf("%s%s_%s_%d.csv%s", prefix, t.Format("2006-01-02_15-04"), p.uid, rotation, common.CompressionExt(codec))
+	}
+	return fmt.Sprintf("%s%s_%s.csv%s", prefix, t.Format("2006-01-02_15-04"), p.uid, common.CompressionExt(codec))
+}
+
+// txsFile returns the txs file for a tx: the shard hash % number of shards, if the txs file is sharded
+func (f *OutFiles) txsFile(txHash ethcommon.Hash) OutputFile {
+	if len(f.FTxsShards) == 0 {
+		return f.FTxs
+	}
+	return f.FTxsShards[txsShard(txHash, len(f.FTxsShards))]
 }
 
+// txsShard returns the shard of a tx hash (the last 8 bytes of the hash, modulo the number of shards)
+func txsShard(txHash ethcommon.Hash, shards int) int {
+	return int(binary.BigEndian.Uint64(txHash[ethcommon.HashLength-8:]) % uint64(shards))
+}
+
+// all returns all opened file handles
+func (f *OutFiles) all() []OutputFile {
+	files := []OutputFile{f.FTxs}
+	if len(f.FTxsShards) > 1 {
+		files = append(files, f.FTxsShards[1:]...)
+	}
+	if f.FTrash != nil {
+		files = append(files, f.FTrash)
+	}
+	if f.FSourcelog != nil {
+		files = append(files, f.FSourcelog)
+	}
+	if f.FReplacements != nil {
+		files = append(files, f.FReplacements)
+	}
+	if f.FSourceTxs != nil {
+		files = append(files, f.FSourceTxs)
+	}
+	f.perSourceLock.Lock()
+	for _, src := range sortedSources(f.fPerSource) {
+		files = append(files, f.fPerSource[src])
+	}
+	f.perSourceLock.Unlock()
+	return files
+}
+
+// closeBucket closes all files of a bucket, logging the number of lines written to each, and uploads them if
+// configured. Must be called with outFilesLock held.
+func (p *TxProcessor) closeBucket(timestamp int64, outFiles *OutFiles) {
+	p.log.Infow("closing bucket",
+		"timestamp", timestamp,
+		"lines_txs", common.Printer.Sprint(outFiles.cntTxs.Load()),
+		"lines_sourcelog", common.Printer.Sprint(outFiles.cntSourcelog.Load()),
+		"lines_replacements", common.Printer.Sprint(outFiles.cntReplacements.Load()),
+		"lines_sourcetxs", common.Printer.Sprint(outFiles.cntSourceTxs.Load()),
+		"lines_persource", common.Printer.Sprint(outFiles.cntPerSource.Load()),
+		"lines_trash", common.Printer.Sprint(outFiles.cntTrash.Load()),
+	)
+	for _, file := range outFiles.all() {
+		p.log.Infow("closing file", "timestamp", timestamp, "filename", file.Name())
+		if err := file.Close(); err != nil {
+			p.log.Errorw("failed to close file", "filename", file.Name(), "error", err)
+		}
+	}
+	if p.gcsURL == "" {
+		return
+	}
+
+	// late txs of the bucket go to new files (with the next rotation suffix), so an uploaded file is never appended to
+	p.rotations[timestamp] = outFiles.rotation + 1
+	for _, file := range outFiles.all() {
+		p.uploads.Add(1)
+		go func(fn string) {
+			defer p.uploads.Done()
+			p.uploadToGCS(fn)
+		}(file.Name())
+	}
+}
+
+// cleanupBackgroundTask periodically has the processing loop close the files of expired buckets, and cleans up the
+// caches. It returns when the processing loop returned.
 func (p *TxProcessor) cleanupBackgroundTask() {
 	for {
-		time.Sleep(time.Minute)
-
-		// Remove old transactions from cache
-		cachedBefore := len(p.txn)
-		p.txnLock.Lock()
-		for k, v := range p.txn {
-			if time.Since(v) > txCacheTime {
-				delete(p.txn, k)
-			}
+		select {
+		case <-time.After(p.statsInterval):
+		case <-p.doneC:
+			return
 		}
-		p.txnLock.Unlock()
-
-		// Remove old files from cache
-		filesBefore := len(p.outFilesTxs)
-		p.outFilesLock.Lock()
-		for timestamp, file := range p.outFilesTxs {
-			usageSec := bucketMinutes * 60 * 2
-			if time.Now().UTC().Unix()-timestamp > int64(usageSec) { // remove all handles from 2x usage seconds ago
-				p.log.Infow("closing tx file", "timestamp", timestamp, "filename", file.Name())
-				delete(p.outFilesTxs, timestamp)
-				_ = file.Close()
-			}
+
+		ack := make(chan struct{})
+		select {
+		case p.closeExpiredC <- ack:
+			<-ack
+		case <-p.doneC:
+			return
 		}
-		for timestamp, file := range p.outFilesSourcelog {
-			usageSec := bucketMinutes * 60 * 2
-			if time.Now().UTC().Unix()-timestamp > int64(usageSec) { // remove all handles from 2x usage seconds ago
-				p.log.Infow("closing sourcelog file", "timestamp", timestamp, "filename", file.Name())
-				delete(p.outFilesSourcelog, timestamp)
-				_ = file.Close()
-			}
+		p.cleanup()
+	}
+}
+
+// closeExpiredBuckets closes (and uploads) the files of the buckets which ended more than bucketCloseDelay ago, and
+// removes expired date directories. It must only be called by the processing loop (or while it's not running), as
+// compressed files are corrupted if closed during a write.
+func (p *TxProcessor) closeExpiredBuckets(now time.Time) {
+	p.outFilesLock.Lock()
+	defer p.outFilesLock.Unlock()
+	for timestamp, outFiles := range p.outFiles {
+		if p.bucketExpired(timestamp, now) {
+			delete(p.outFiles, timestamp)
+			p.closeBucket(timestamp, outFiles)
+			p.closedBuckets.Inc()
+		}
+	}
+	// the rotations are kept for another bucket, so late txs of a closed and uploaded bucket go to new files
+	for timestamp := range p.rotations {
+		if _, ok := p.outFiles[timestamp]; !ok && p.bucketExpired(timestamp+p.bucketSec, now) {
+			delete(p.rotations, timestamp)
 		}
-		p.outFilesLock.Unlock()
-
-		// Get memory stats
-		var m runtime.MemStats
-		runtime.ReadMemStats(&m)
-
-		// Print stats
-		p.log.Infow("stats",
-			"txcache_before", common.Printer.Sprint(cachedBefore),
-			"txcache_after", common.Printer.Sprint(len(p.txn)),
-			"txcache_removed", common.Printer.Sprint(cachedBefore-len(p.txn)),
-			"files_before", filesBefore,
-			"files_after", len(p.outFilesTxs),
-			"goroutines", common.Printer.Sprint(runtime.NumGoroutine()),
-			"alloc_mb", m.Alloc/1024/1024,
-			"num_gc", common.Printer.Sprint(m.NumGC),
-			"tx_per_min", common.Printer.Sprint(p.txCnt.Load()),
+	}
+	if p.retention > 0 && p.streamFiles == nil {
+		p.removeExpiredDateDirs(now)
+	}
+}
+
+// cleanup removes expired entries from the caches, and logs the stats (and resets the counters, if the reset interval
+// has passed). The files of old buckets are closed before, by closeExpiredBuckets.
+func (p *TxProcessor) cleanup() {
+	now := p.clock.Now()
+	reset := p.statsResetInterval == 0 || (p.statsResetInterval > 0 && now.Sub(p.statsLastReset) >= p.statsResetInterval)
+	statsSince := p.statsLastReset
+	if reset {
+		p.statsLastReset = now
+	}
+
+	// Remove old transactions from cache
+	cachedBefore := len(p.txn)
+	p.txnLock.Lock()
+	for k, v := range p.txn {
+		if now.Sub(v) > txCacheTime {
+			delete(p.txn, k)
+		}
+	}
+	p.txnLock.Unlock()
+
+	// Remove old per-source sightings
+	p.txnPerSourceLock.Lock()
+	for k, v := range p.txnPerSource {
+		if now.Sub(v) > txCacheTime {
+			delete(p.txnPerSource, k)
+		}
+	}
+	p.txnPerSourceLock.Unlock()
+
+	// Remove old pending sender+nonce entries
+	p.pendingTxsLock.Lock()
+	for k, v := range p.pendingTxs {
+		if now.Sub(v.t) > txCacheTime {
+			delete(p.pendingTxs, k)
+		}
+	}
+	pendingTxsCnt := len(p.pendingTxs)
+	p.pendingTxsLock.Unlock()
+
+	// Remove old entries of the reentry tracker
+	p.reentryTxsLock.Lock()
+	for k, v := range p.reentryTxs {
+		if now.Sub(v) > p.reentryWindow {
+			delete(p.reentryTxs, k)
+		}
+	}
+	reentryTxsCnt := len(p.reentryTxs)
+	p.reentryTxsLock.Unlock()
+
+	// Open buckets, and the ones closed since the last stats log
+	p.outFilesLock.RLock()
+	filesAfter := len(p.outFiles)
+	p.outFilesLock.RUnlock()
+	filesBefore := filesAfter + int(p.closedBuckets.Swap(0))
+
+	// Lines written to the current bucket so far
+	_, bucketLinesTxs, bucketLinesSourcelog := p.CurrentBucketLineCounts()
+
+	// Get memory stats
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	// Print stats
+	p.log.Infow("stats",
+		"txcache_before", common.Printer.Sprint(cachedBefore),
+		"txcache_after", common.Printer.Sprint(len(p.txn)),
+		"txcache_removed", common.Printer.Sprint(cachedBefore-len(p.txn)),
+		"files_before", filesBefore,
+		"files_after", filesAfter,
+		"goroutines", common.Printer.Sprint(runtime.NumGoroutine()),
+		"alloc_mb", m.Alloc/1024/1024,
+		"num_gc", common.Printer.Sprint(m.NumGC),
+		"tx_per_min", common.Printer.Sprint(p.txCnt.Load()),
+		"counters_since", statsSince.UTC().Format(time.RFC3339),
+		"bucket_lines_txs", common.Printer.Sprint(bucketLinesTxs),
+		"bucket_lines_sourcelog", common.Printer.Sprint(bucketLinesSourcelog),
+		"write_errors_total", common.Printer.Sprint(p.writeErrCnt.Load()),
+		"paused_drops_total", common.Printer.Sprint(p.pausedDropCnt.Load()),
+	)
+
+	if p.trackReplacements {
+		p.log.Infow("replacement_stats",
+			"replacements_per_min", common.Printer.Sprint(resetCounter(&p.replacementCnt, reset)),
+			"pending_sender_nonces", common.Printer.Sprint(pendingTxsCnt),
 		)
+	}
 
-		// print and reset stats about who got a tx first
-		srcStatsLog := p.log
-		p.srcCntFirstLock.Lock()
-		for k, v := range p.srcCntFirst {
-			srcStatsLog = srcStatsLog.With(k, common.Printer.Sprint(v))
+	if p.reentryWindow > 0 {
+		p.log.Infow("reentry_stats",
+			"reentries_per_min", common.Printer.Sprint(resetCounter(&p.reentryCnt, reset)),
+			"reentry_window", p.reentryWindow.String(),
+			"reentry_tracked_txs", common.Printer.Sprint(reentryTxsCnt),
+		)
+	}
+
+	// print and reset stats about who got a tx first
+	srcStatsLog := p.log
+	p.srcCntFirstLock.Lock()
+	for _, k := range sortedSources(p.srcCntFirst) {
+		srcStatsLog = srcStatsLog.With(k, common.Printer.Sprint(p.srcCntFirst[k]))
+		if reset {
 			p.srcCntFirst[k] = 0
 		}
-		p.srcCntFirstLock.Unlock()
-		srcStatsLog.Info("source_stats_first")
-
-		// print and reset stats about overall number of tx per source
-		srcStatsAllLog := p.log
-		srcStatsUniqueLog := p.log
-		p.srcCntAllLock.Lock()
-		for k, v := range p.srcCntAll {
-			srcStatsAllLog = srcStatsAllLog.With(k, common.Printer.Sprint(v))
+	}
+	p.srcCntFirstLock.Unlock()
+	srcStatsLog.Info("source_stats_first")
+
+	// print and reset who received multi-source txs first
+	p.leaderboard.logAndReset(p.log, now, reset)
+
+	// print and reset stats about overall number of tx per source
+	srcStatsAllLog := p.log
+	srcStatsUniqueLog := p.log
+	p.srcCntAllLock.Lock()
+	for _, k := range sortedSources(p.srcCntAll) {
+		srcStatsAllLog = srcStatsAllLog.With(k, common.Printer.Sprint(p.srcCntAll[k]))
+		if reset {
 			p.srcCntAll[k] = 0
 		}
-		for k, v := range p.srcCntUnique {
-			srcStatsUniqueLog = srcStatsUniqueLog.With(k, common.Printer.Sprint(len(v)))
-			p.srcCntUnique[k] = make(map[string]bool)
-		}
-		p.srcCntAllLock.Unlock()
+	}
+	for _, k := range sortedSources(p.srcCntUnique) {
+		srcStatsUniqueLog = srcStatsUniqueLog.With(k, common.Printer.Sprint(len(p.srcCntUnique[k])))
+		p.srcCntUnique[k] = make(map[string]bool)
+	}
+	p.srcCntAllLock.Unlock()
+
+	srcStatsAllLog.Info("source_stats_all")
+	srcStatsUniqueLog.Info("source_stats_unique")
 
-		srcStatsAllLog.Info("source_stats_all")
-		srcStatsUniqueLog.Info("source_stats_unique")
+	// print and reset the number of bytes received per source
+	srcStatsBytesLog := p.log
+	rxBytes := p.rxBytes.read(reset)
+	for _, src := range sortedSources(rxBytes) {
+		srcStatsBytesLog = srcStatsBytesLog.With(src, common.Printer.Sprint(rxBytes[src]))
+	}
+	srcStatsBytesLog.Info("source_stats_bytes")
 
-		// reset overall counter
+	// reset overall counter
+	if reset {
 		p.txCnt.Store(0)
 	}
 }
+
+// resetCounter returns the value of the counter, and sets it to 0 if reset is set
+func resetCounter(cnt *atomic.Uint64, reset bool) uint64 {
+	if reset {
+		return cnt.Swap(0)
+	}
+	return cnt.Load()
+}
@@ -1,5 +1,27 @@
 package collector
 
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/mempool-dumpster/common"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
 // var testLog = common.GetLogger(true, false)
 
 // func TestBuilderAliases(t *testing.T) {
@@ -7,3 +29,878 @@ package collector
 // 	txp := NewTxProcessor(testLog, tempDir, "test1")
 // 	require.Equal(t, "collector", "collector")
 // }
+
+var testTxRlp = "0x02f873018305643b840f2c19f08503f8bfbbb2832ab980940ed1bcc400acd34593451e76f854992198995f52808498e5b12ac080a051eb99ae13fd1ace55dd93a4b36eefa5d34e115cd7b9fd5d0ffac07300cbaeb2a0782d9ad12490b45af932d8c98cb3c2fd8c02cdd6317edb36bde2df7556fa9132"
+
+// testClock is a Clock which only moves when the test sets it
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.t
+}
+
+func TestBucketsAndCacheExpiry(t *testing.T) {
+	clock := &testClock{time.Date(2023, 8, 7, 10, 59, 59, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.NewNop().Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+		Clock:  clock,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	// the second sighting falls into the next bucket, but the tx is only written once
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: clock.t.Add(time.Second), Tx: tx, Source: common.Source{Name: "b"}})
+	bucket10 := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC).Unix()
+	bucket11 := time.Date(2023, 8, 7, 11, 0, 0, 0, time.UTC).Unix()
+	require.Len(t, p.outFiles, 2)
+	require.Equal(t, uint64(1), p.outFiles[bucket10].cntTxs.Load())
+	require.Equal(t, uint64(0), p.outFiles[bucket11].cntTxs.Load())
+
+	// the tx is removed from the cache after txCacheTime
+	clock.t = clock.t.Add(txCacheTime)
+	p.cleanup()
+	require.Len(t, p.txn, 1)
+	clock.t = clock.t.Add(time.Second)
+	p.cleanup()
+	require.Empty(t, p.txn)
+
+	// bucket files are closed 2 buckets after the bucket start
+	clock.t = time.Unix(bucket10, 0).Add(2*bucketMinutes*time.Minute + time.Second)
+	p.closeExpiredBuckets(clock.t)
+	require.Len(t, p.outFiles, 1)
+	require.Contains(t, p.outFiles, bucket11)
+}
+
+func TestDailyFiles(t *testing.T) {
+	clock := &testClock{time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         t.TempDir(),
+		UID:            "test",
+		Clock:          clock,
+		WriteSourcelog: true,
+		DailyFiles:     true,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	// sightings of the whole day go into the same files
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: time.Date(2023, 8, 7, 23, 30, 0, 0, time.UTC), Tx: tx, Source: common.Source{Name: "b"}})
+	day := time.Date(2023, 8, 7, 0, 0, 0, 0, time.UTC).Unix()
+	require.Len(t, p.outFiles, 1)
+	require.Equal(t, uint64(2), p.outFiles[day].cntSourcelog.Load())
+	require.Contains(t, p.outFiles[day].FTxs.Name(), "txs_2023-08-07_00-00_test.csv")
+
+	// the files stay open all day, and are closed bucketCloseDelay after its end
+	clock.t = time.Date(2023, 8, 8, 0, 30, 0, 0, time.UTC)
+	p.closeExpiredBuckets(clock.t)
+	require.Len(t, p.outFiles, 1)
+	clock.t = time.Unix(day, 0).Add(bucketMinutesDaily*time.Minute + bucketCloseDelay + time.Second)
+	p.closeExpiredBuckets(clock.t)
+	require.Empty(t, p.outFiles)
+}
+
+func TestGetOutputCSVFilesConcurrent(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         t.TempDir(),
+		UID:            "test",
+		WriteSourcelog: true,
+	})
+
+	// all goroutines requesting a new bucket at the same time get the same files, which are created only once
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC).Unix()
+	results := make([]*OutFiles, 20)
+	var cntCreated atomic.Int64
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			outFiles, isCreated, err := p.getOutputCSVFiles(ts)
+			require.NoError(t, err)
+			if isCreated {
+				cntCreated.Inc()
+			}
+			results[i] = outFiles
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, int64(1), cntCreated.Load())
+	for _, outFiles := range results {
+		require.Same(t, results[0], outFiles)
+	}
+}
+
+func TestSourcelogFirstOnly(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:                zap.NewNop().Sugar(),
+		OutDir:             t.TempDir(),
+		UID:                "test",
+		WriteSourcelog:     true,
+		SourcelogFirstOnly: true,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: ts.Add(time.Second), Tx: tx, Source: common.Source{Name: "a"}}) // repeated, not recorded
+	p.processTx(TxIn{T: ts.Add(time.Second), Tx: tx, Source: common.Source{Name: "b"}})
+	require.Equal(t, uint64(2), p.outFiles[ts.Unix()].cntSourcelog.Load())
+}
+
+func TestStatsResetInterval(t *testing.T) {
+	clock := &testClock{time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:                zap.NewNop().Sugar(),
+		OutDir:             t.TempDir(),
+		UID:                "test",
+		Clock:              clock,
+		StatsResetInterval: 5 * time.Minute,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "a"}})
+
+	// counters accumulate until the reset interval has passed
+	clock.t = clock.t.Add(time.Minute)
+	p.cleanup()
+	require.Equal(t, uint64(1), p.txCnt.Load())
+	require.Equal(t, uint64(1), p.srcCntAll["a"])
+
+	clock.t = clock.t.Add(4 * time.Minute)
+	p.cleanup()
+	require.Equal(t, uint64(0), p.txCnt.Load())
+	require.Equal(t, uint64(0), p.srcCntAll["a"])
+}
+
+func TestTxsShards(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:       zap.NewNop().Sugar(),
+		OutDir:    t.TempDir(),
+		UID:       "test",
+		TxsShards: 4,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	outFiles := p.outFiles[ts.Unix()]
+	require.Len(t, outFiles.FTxsShards, 4)
+	require.Len(t, outFiles.all(), 4)
+
+	// the tx is written only to its shard
+	for shard, f := range outFiles.FTxsShards {
+		content, err := os.ReadFile(f.Name())
+		require.NoError(t, err)
+		require.Equal(t, shard == txsShard(tx.Hash(), 4), len(content) > 0, f.Name())
+	}
+}
+
+// testSink records the transactions written to it
+type testSink struct {
+	txs    []TxDetail
+	closed bool
+}
+
+func (s *testSink) Write(tx TxDetail) error {
+	s.txs = append(s.txs, tx)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestSinks(t *testing.T) {
+	sink := &testSink{}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.NewNop().Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+		Sinks:  []Sink{sink},
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	// every new tx is written to the txs file and all sinks, only once
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: ts.Add(time.Second), Tx: tx, Source: common.Source{Name: "b"}})
+	require.Equal(t, uint64(1), p.outFiles[ts.Unix()].cntTxs.Load())
+	require.Equal(t, []TxDetail{{Timestamp: ts.UnixMilli(), Hash: tx.Hash().Hex(), RawTx: testTxRlp}}, sink.txs)
+
+	p.Close()
+	require.True(t, sink.closed)
+}
+
+func TestClickHouseSink(t *testing.T) {
+	// the server records the inserted rows per table
+	var insertsLock sync.Mutex
+	inserts := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.Equal(t, "mempool", r.URL.Query().Get("database"))
+		require.Equal(t, "user", r.Header.Get("X-ClickHouse-User"))
+		insertsLock.Lock()
+		inserts[r.URL.Query().Get("query")] += string(body)
+		insertsLock.Unlock()
+	}))
+	defer srv.Close()
+
+	sink, err := NewClickHouseSink(ClickHouseSinkOpts{ //nolint:exhaustruct
+		Log: zap.NewNop().Sugar(),
+		DSN: strings.Replace(srv.URL, "http://", "http://user:pw@", 1) + "/mempool",
+	})
+	require.NoError(t, err)
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.NewNop().Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+		Sinks:  []Sink{sink},
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: ts.Add(time.Second), Tx: tx, Source: common.Source{Name: "b"}})
+
+	// the remaining rows are inserted on close
+	p.Close()
+	hash := tx.Hash().Hex()
+	require.Equal(t, map[string]string{
+		"INSERT INTO txs FORMAT JSONEachRow": fmt.Sprintf(`{"timestamp_ms":%d,"hash":"%s","raw_tx":"%s","origin":""}`+"\n", ts.UnixMilli(), hash, testTxRlp),
+		"INSERT INTO sourcelog FORMAT JSONEachRow": fmt.Sprintf(`{"timestamp_ms":%d,"hash":"%s","source":"a","origin":""}`+"\n"+`{"timestamp_ms":%d,"hash":"%s","source":"b","origin":""}`+"\n",
+			ts.UnixMilli(), hash, ts.Add(time.Second).UnixMilli(), hash),
+	}, inserts)
+
+	_, err = NewClickHouseSink(ClickHouseSinkOpts{Log: zap.NewNop().Sugar(), DSN: "localhost:8123"}) //nolint:exhaustruct
+	require.ErrorIs(t, err, ErrInvalidClickHouseDSN)
+}
+
+func TestOrigin(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         t.TempDir(),
+		UID:            "test",
+		WriteSourcelog: true,
+		Origin:         "test@host1",
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	outFiles := p.outFiles[ts.Unix()]
+
+	txs, err := os.ReadFile(outFiles.FTxs.Name())
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d,%s,%s,test@host1\n", ts.UnixMilli(), tx.Hash().Hex(), testTxRlp), string(txs))
+
+	sourcelog, err := os.ReadFile(outFiles.FSourcelog.Name())
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d,%s,a,test@host1\n", ts.UnixMilli(), tx.Hash().Hex()), string(sourcelog))
+}
+
+func TestCalldataGas(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:              zap.NewNop().Sugar(),
+		OutDir:           t.TempDir(),
+		UID:              "test",
+		WriteCalldataGas: true,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	// 4 non-zero calldata bytes: 21,000 + 4 * 16 gas
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	txs, err := os.ReadFile(p.outFiles[ts.Unix()].FTxs.Name())
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d,%s,%s,4,21064\n", ts.UnixMilli(), tx.Hash().Hex(), testTxRlp), string(txs))
+}
+
+// failingSink fails every write
+type failingSink struct{}
+
+func (failingSink) Write(tx TxDetail) error { return os.ErrClosed }
+func (failingSink) Close() error            { return nil }
+
+func TestWriteErrors(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.NewNop().Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+		Sinks:  []Sink{failingSink{}},
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	// the failed write is counted, the other sinks still get the tx
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	require.Equal(t, uint64(1), p.writeErrCnt.Load())
+	require.Equal(t, uint64(1), p.outFiles[ts.Unix()].cntTxs.Load())
+}
+
+func TestWriteErrorPause(t *testing.T) {
+	clock := &testClock{time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:          zap.NewNop().Sugar(),
+		OutDir:       t.TempDir(),
+		UID:          "test",
+		Clock:        clock,
+		Sinks:        []Sink{failingSink{}},
+		OnWriteError: OnWriteErrorPause,
+	})
+
+	tx1, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	to := ethcommon.HexToAddress("0x01")
+	tx2 := types.NewTx(&types.LegacyTx{To: &to}) //nolint:exhaustruct
+
+	// the failed write pauses the output, without blocking the processing
+	p.processTx(TxIn{T: clock.t, Tx: tx1, Source: common.Source{Name: "a"}})
+	require.Equal(t, uint64(1), p.writeErrCnt.Load())
+	require.Equal(t, initialBackoffSec*time.Second, p.writeErrBackoff)
+
+	// new txs are dropped until the pause is over
+	p.processTx(TxIn{T: clock.t, Tx: tx2, Source: common.Source{Name: "a"}})
+	require.Equal(t, uint64(1), p.pausedDropCnt.Load())
+	outFiles := p.outFiles[clock.t.Unix()]
+	require.Equal(t, uint64(1), outFiles.cntTxs.Load())
+
+	// then the next tx is written again, and another failure doubles the pause
+	clock.t = clock.t.Add(initialBackoffSec * time.Second)
+	p.processTx(TxIn{T: clock.t, Tx: tx2, Source: common.Source{Name: "b"}})
+	require.Equal(t, uint64(2), outFiles.cntTxs.Load())
+	require.Equal(t, uint64(2), p.writeErrCnt.Load())
+	require.Equal(t, 2*initialBackoffSec*time.Second, p.writeErrBackoff)
+}
+
+func TestCompression(t *testing.T) {
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+
+	for _, codec := range []string{common.CompressionGzip, common.CompressionZstd, common.CompressionSnappy} {
+		p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+			Log:            zap.NewNop().Sugar(),
+			OutDir:         t.TempDir(),
+			UID:            "test",
+			WriteSourcelog: true,
+			Compression:    codec,
+		})
+
+		p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+		fn := p.outFiles[ts.Unix()].FSourcelog.Name()
+		require.True(t, strings.HasSuffix(fn, ".csv"+common.CompressionExt(codec)), fn)
+
+		// the data is complete once the bucket is closed, and a reopened bucket file gets a new stream appended
+		p.rotate()
+		p.rotations[ts.Unix()] = 0
+		p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "b"}})
+		p.rotate()
+
+		rows, err := common.GetCSV(fn)
+		require.NoError(t, err, codec)
+		require.Equal(t, [][]string{
+			{fmt.Sprint(ts.UnixMilli()), tx.Hash().Hex(), "a"},
+			{fmt.Sprint(ts.UnixMilli()), tx.Hash().Hex(), "b"},
+		}, rows, codec)
+	}
+
+	require.ErrorIs(t, ValidateCompression("lz4"), ErrInvalidCompression)
+}
+
+func TestCompressionPerFile(t *testing.T) {
+	perFile, err := ParseCompressionPerFile("sourcelog=gzip")
+	require.NoError(t, err)
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:                zap.NewNop().Sugar(),
+		OutDir:             t.TempDir(),
+		UID:                "test",
+		WriteSourcelog:     true,
+		CompressionPerFile: perFile,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	require.True(t, strings.HasSuffix(p.outFiles[ts.Unix()].FSourcelog.Name(), ".csv.gz"))
+	require.True(t, strings.HasSuffix(p.outFiles[ts.Unix()].FTxs.Name(), ".csv"))
+
+	_, err = ParseCompressionPerFile("txs=gzip")
+	require.ErrorIs(t, err, ErrInvalidCompression)
+	_, err = ParseCompressionPerFile("sourcelog=lz4")
+	require.ErrorIs(t, err, ErrInvalidCompression)
+}
+
+func TestCloseCompressedFiles(t *testing.T) {
+	outDir := t.TempDir()
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         outDir,
+		UID:            "test",
+		WriteSourcelog: true,
+		Compression:    common.CompressionGzip,
+	})
+	go p.Start()
+	require.Eventually(t, p.running.Load, time.Second, time.Millisecond)
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.txC <- TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}}
+
+	// the queued tx is processed, and the gzip stream is complete once the processor is closed
+	p.Close()
+	require.Empty(t, p.outFiles)
+	files, err := filepath.Glob(filepath.Join(outDir, "2023-08-07", "sourcelog", "*.csv.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	rows, err := common.GetCSV(files[0])
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{fmt.Sprint(ts.UnixMilli()), tx.Hash().Hex(), "a"}}, rows)
+}
+
+func TestCloseExpiredConcurrent(t *testing.T) {
+	outDir := t.TempDir()
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         outDir,
+		UID:            "test",
+		WriteSourcelog: true,
+		Compression:    common.CompressionGzip,
+		StatsInterval:  time.Millisecond,
+	})
+	go p.Start()
+
+	// the bucket is long expired, so the cleanup closes it while txs are written, which reopen it (a new gzip member)
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	nTxs := 2000
+	for i := 0; i < nTxs; i++ {
+		p.txC <- TxIn{T: ts, Tx: tx, Source: common.Source{Name: fmt.Sprint(i)}}
+		if i%100 == 0 {
+			time.Sleep(2 * time.Millisecond)
+		}
+	}
+	p.Close()
+
+	files, err := filepath.Glob(filepath.Join(outDir, "2023-08-07", "sourcelog", "*.csv.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	rows, err := common.GetCSV(files[0])
+	require.NoError(t, err)
+	require.Len(t, rows, nTxs)
+}
+
+func TestRotateAck(t *testing.T) {
+	outDir := t.TempDir()
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         outDir,
+		UID:            "test",
+		WriteSourcelog: true,
+		Compression:    common.CompressionGzip,
+	})
+	p.txC = make(chan TxIn) // unbuffered, so the tx is written before the rotation request is received
+	go p.Start()
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	p.txC <- TxIn{T: time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC), Tx: tx, Source: common.Source{Name: "a"}}
+	p.Rotate()
+
+	// when Rotate returns, the files are closed, i.e. the gzip stream is complete
+	files, err := filepath.Glob(filepath.Join(outDir, "2023-08-07", "sourcelog", "*.csv.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	rows, err := common.GetCSV(files[0])
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+
+	// after Close, Rotate doesn't block
+	p.Close()
+	rotated := make(chan struct{})
+	go func() {
+		p.Rotate()
+		close(rotated)
+	}()
+	select {
+	case <-rotated:
+	case <-time.After(time.Second):
+		t.Fatal("Rotate blocked after Close")
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "out")
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:      zap.NewNop().Sugar(),
+		OutDir:   outDir,
+		UID:      "test",
+		FileMode: 0o660,
+		DirMode:  0o770 | os.ModeSetgid,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+
+	// the configured modes are applied exactly, regardless of the umask (and with setgid on the directories)
+	for _, dir := range []string{outDir, filepath.Join(outDir, "2023-08-07"), filepath.Join(outDir, "2023-08-07", "transactions")} {
+		fi, err := os.Stat(dir)
+		require.NoError(t, err)
+		require.Equal(t, os.ModeDir|os.ModeSetgid|0o770, fi.Mode(), dir)
+	}
+	fi, err := os.Stat(p.outFiles[ts.Unix()].FTxs.Name())
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o660), fi.Mode())
+}
+
+func TestCSVHeader(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         t.TempDir(),
+		UID:            "test",
+		WriteSourcelog: true,
+		WriteSignature: true,
+		WriteHeader:    true,
+		Origin:         "test@host1",
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+
+	// a reopened file doesn't get a second header
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	fnSourcelog := p.outFiles[ts.Unix()].FSourcelog.Name()
+	p.rotate()
+	p.rotations[ts.Unix()] = 0
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "b"}})
+	outFiles := p.outFiles[ts.Unix()]
+
+	txs, err := os.ReadFile(outFiles.FTxs.Name())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(txs), "timestamp_ms,hash,raw_tx,y_parity,r,s,origin\n"), string(txs))
+
+	sourcelog, err := os.ReadFile(fnSourcelog)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(sourcelog), "timestamp_ms,hash,source,origin\n"))
+
+	// the loader skips the header
+	loaded, cnt := common.LoadSourceLogFiles(zap.NewNop().Sugar(), []string{fnSourcelog})
+	require.Equal(t, int64(2), cnt)
+	require.Len(t, loaded, 1)
+}
+
+func TestSourceMetrics(t *testing.T) {
+	clock := &testClock{time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.NewNop().Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+		Clock:  clock,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "b"}})
+
+	expected := map[string]SourceMetrics{
+		"a": {All: 2, First: 1, Unique: 1},
+		"b": {All: 1, First: 0, Unique: 1},
+	}
+	require.Equal(t, expected, p.SourceMetrics())
+	require.Equal(t, expected, p.SourceMetrics()) // reading doesn't reset
+
+	// the stats log resets the counters
+	p.cleanup()
+	require.Equal(t, map[string]SourceMetrics{"a": {}, "b": {}}, p.SourceMetrics())
+}
+
+func TestSourceStatsOrder(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.New(core).Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	for _, src := range []string{"c", "a", "d", "b"} {
+		p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: src}})
+	}
+
+	// the sources of the stats logs are sorted, for diffable logs
+	p.cleanup()
+	for _, msg := range []string{"source_stats_all", "source_stats_unique"} {
+		entries := logs.FilterMessage(msg).All()
+		require.Len(t, entries, 1)
+		keys := []string{}
+		for _, field := range entries[0].Context {
+			keys = append(keys, field.Key)
+		}
+		require.Equal(t, []string{"a", "b", "c", "d"}, keys)
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	clock := &testClock{time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:    zap.NewNop().Sugar(),
+		OutDir: t.TempDir(),
+		UID:    "test",
+		Clock:  clock,
+		Warmup: 30 * time.Second,
+	})
+
+	// the tx within the warmup is recorded, but not counted as first-seen
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	tx2 := types.NewTx(&types.LegacyTx{Nonce: 1}) //nolint:exhaustruct
+	p.processTx(TxIn{T: clock.t.Add(10 * time.Second), Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: clock.t.Add(40 * time.Second), Tx: tx2, Source: common.Source{Name: "a"}})
+	require.Equal(t, map[string]SourceMetrics{"a": {All: 2, First: 1, Unique: 2}}, p.SourceMetrics())
+	require.Equal(t, uint64(2), p.outFiles[clock.t.Unix()].cntTxs.Load())
+}
+
+func TestPerSourceTxs(t *testing.T) {
+	outDir := t.TempDir()
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         outDir,
+		UID:            "test",
+		WritePerSource: true,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: ts.Add(time.Second), Tx: tx, Source: common.Source{Name: "a"}}) // repeated by the same source
+	p.processTx(TxIn{T: ts.Add(2 * time.Second), Tx: tx, Source: common.Source{Name: "ws://localhost:8546"}})
+	outFiles := p.outFiles[ts.Unix()]
+	require.Equal(t, uint64(1), outFiles.cntTxs.Load())
+	require.Equal(t, uint64(2), outFiles.cntPerSource.Load())
+	require.Len(t, outFiles.all(), 3)
+
+	// each source's file has the tx once, with the timestamp of that source
+	for i, src := range []string{"a", "ws---localhost-8546"} {
+		content, err := os.ReadFile(filepath.Join(outDir, "2023-08-07", "persource", "txs-"+src+"_2023-08-07_10-00_test.csv"))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("%d,%s,%s\n", ts.Add(time.Duration(2*i)*time.Second).UnixMilli(), tx.Hash().Hex(), testTxRlp), string(content))
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:              zap.NewNop().Sugar(),
+		OutDir:           t.TempDir(),
+		UID:              "test",
+		WriteTrash:       true,
+		VerifySignatures: true,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	badTx, err := tx.WithSignature(types.LatestSignerForChainID(tx.ChainId()), make([]byte, 65)) // r = s = 0
+	require.NoError(t, err)
+
+	// the tx with the valid signature is written to the txs file, the other one to the trash
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	p.processTx(TxIn{T: ts, Tx: badTx, Source: common.Source{Name: "b"}})
+	outFiles := p.outFiles[ts.Unix()]
+	require.Equal(t, uint64(1), outFiles.cntTxs.Load())
+	require.Equal(t, uint64(1), outFiles.cntTrash.Load())
+
+	// trashed txs are not counted as first-seen
+	require.Equal(t, uint64(1), p.txCnt.Load())
+	require.Equal(t, map[string]uint64{"a": 1}, p.srcCntFirst)
+
+	trash, err := os.ReadFile(outFiles.FTrash.Name())
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d,%s,b,%s,\n", ts.UnixMilli(), badTx.Hash().Hex(), common.TrashInvalidSignature), string(trash))
+}
+
+func TestSelectorFilter(t *testing.T) {
+	selectors, err := ParseSelectors("0x7ff36ab5, 0x38ed1739")
+	require.NoError(t, err)
+	require.Equal(t, [][4]byte{{0x7f, 0xf3, 0x6a, 0xb5}, {0x38, 0xed, 0x17, 0x39}}, selectors)
+	_, err = ParseSelectors("0x7ff36a")
+	require.ErrorIs(t, err, ErrInvalidSelector)
+
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:        zap.NewNop().Sugar(),
+		OutDir:     t.TempDir(),
+		UID:        "test",
+		WriteTrash: true,
+		Selectors:  selectors,
+	})
+
+	to := ethcommon.HexToAddress("0x01")
+	swap := types.NewTx(&types.LegacyTx{To: &to, Data: []byte{0x7f, 0xf3, 0x6a, 0xb5, 0x01}})      //nolint:exhaustruct
+	other := types.NewTx(&types.LegacyTx{Nonce: 1, To: &to, Data: []byte{0xa9, 0x05, 0x9c, 0xbb}}) //nolint:exhaustruct
+	transfer := types.NewTx(&types.LegacyTx{Nonce: 2, To: &to})                                    //nolint:exhaustruct
+	creation := types.NewTx(&types.LegacyTx{Nonce: 3, Data: []byte{0x7f, 0xf3, 0x6a, 0xb5}})       //nolint:exhaustruct
+
+	// only the call with an allowed selector is written to the txs file
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	for _, tx := range []*types.Transaction{swap, other, transfer, creation} {
+		p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	}
+	outFiles := p.outFiles[ts.Unix()]
+	require.Equal(t, uint64(1), outFiles.cntTxs.Load())
+	require.Equal(t, uint64(1), p.txCnt.Load())
+	require.Equal(t, map[string]uint64{"a": 1}, p.srcCntFirst)
+
+	trash, err := os.ReadFile(outFiles.FTrash.Name())
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d,%s,a,filtered,selector=0xa9059cbb\n", ts.UnixMilli(), other.Hash().Hex())+
+		fmt.Sprintf("%d,%s,a,filtered,calldata_size=0\n", ts.UnixMilli(), transfer.Hash().Hex())+
+		fmt.Sprintf("%d,%s,a,filtered,contract-creation\n", ts.UnixMilli(), creation.Hash().Hex()), string(trash))
+
+	// contract creations alone: only contract creations pass
+	p.contractCreations = true
+	ok, _ := p.filterTx(creation)
+	require.True(t, ok)
+}
+
+func TestBufferFlushInterval(t *testing.T) {
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:                 zap.NewNop().Sugar(),
+		OutDir:              t.TempDir(),
+		UID:                 "test",
+		WriteSourcelog:      true,
+		BufferFlushInterval: time.Second,
+	})
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	outFiles := p.outFiles[ts.Unix()]
+	expectedTxs := fmt.Sprintf("%d,%s,%s\n", ts.UnixMilli(), tx.Hash().Hex(), testTxRlp)
+
+	// the rows are buffered until the flush
+	txs, err := os.ReadFile(outFiles.FTxs.Name())
+	require.NoError(t, err)
+	require.Empty(t, txs)
+
+	p.flush()
+	txs, err = os.ReadFile(outFiles.FTxs.Name())
+	require.NoError(t, err)
+	require.Equal(t, expectedTxs, string(txs))
+
+	// closing the bucket flushes the rest
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "b"}})
+	p.closeBucket(ts.Unix(), outFiles)
+	sourcelog, err := os.ReadFile(outFiles.FSourcelog.Name())
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%d,%s,a\n%d,%s,b\n", ts.UnixMilli(), tx.Hash().Hex(), ts.UnixMilli(), tx.Hash().Hex()), string(sourcelog))
+}
+
+func TestRetention(t *testing.T) {
+	outDir := t.TempDir()
+	clock := &testClock{time.Date(2023, 8, 7, 23, 30, 0, 0, time.UTC)}
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:       zap.NewNop().Sugar(),
+		OutDir:    outDir,
+		UID:       "test",
+		Clock:     clock,
+		Retention: time.Minute,
+	})
+	require.NoError(t, os.Mkdir(outDir+"/other", 0o700))
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	p.processTx(TxIn{T: clock.t, Tx: tx, Source: common.Source{Name: "a"}})
+	require.DirExists(t, outDir+"/2023-08-07")
+
+	// the day ended longer ago than the retention, but its last bucket is still open
+	clock.t = time.Date(2023, 8, 8, 0, 30, 0, 0, time.UTC)
+	p.closeExpiredBuckets(clock.t)
+	require.DirExists(t, outDir+"/2023-08-07")
+
+	// removed once the bucket is closed, other directories are kept
+	clock.t = time.Date(2023, 8, 8, 1, 1, 0, 0, time.UTC)
+	p.closeExpiredBuckets(clock.t)
+	require.NoDirExists(t, outDir+"/2023-08-07")
+	require.DirExists(t, outDir+"/other")
+}
+
+func TestValidateGCSURL(t *testing.T) {
+	require.NoError(t, ValidateGCSURL("gs://bucket"))
+	require.NoError(t, ValidateGCSURL("gs://bucket/prefix/"))
+	for _, gcsURL := range []string{"", "gs://", "gs:///prefix", "s3://bucket", "bucket/prefix"} {
+		require.ErrorIs(t, ValidateGCSURL(gcsURL), ErrInvalidGCSURL, gcsURL)
+	}
+}
+
+func TestGCSUpload(t *testing.T) {
+	outDir := t.TempDir()
+	p := NewTxProcessor(TxProcessorOpts{ //nolint:exhaustruct
+		Log:            zap.NewNop().Sugar(),
+		OutDir:         outDir,
+		UID:            "test",
+		GCSURL:         "gs://bucket/prefix/",
+		GCSDeleteLocal: true,
+	})
+	p.gsutil = "true" // succeeds without uploading
+
+	// the objects have the path relative to the output directory
+	target, err := p.gcsObjectURL(filepath.Join(outDir, "2023-08-07", "transactions", "txs_2023-08-07_10-00_test.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "gs://bucket/prefix/2023-08-07/transactions/txs_2023-08-07_10-00_test.csv", target)
+
+	tx, err := common.RLPStringToTx(testTxRlp)
+	require.NoError(t, err)
+	ts := time.Date(2023, 8, 7, 10, 0, 0, 0, time.UTC)
+	p.processTx(TxIn{T: ts, Tx: tx, Source: common.Source{Name: "a"}})
+	uploaded := p.outFiles[ts.Unix()].FTxs.Name()
+
+	// a late tx of a closed bucket goes to a new file, so the uploaded file is never appended to
+	p.outFilesLock.Lock()
+	p.closeBucket(ts.Unix(), p.outFiles[ts.Unix()])
+	delete(p.outFiles, ts.Unix())
+	p.outFilesLock.Unlock()
+	p.processTx(TxIn{T: ts.Add(time.Second), Tx: tx, Source: common.Source{Name: "b"}})
+	late := p.outFiles[ts.Unix()].FTxs.Name()
+	require.Equal(t, "txs_2023-08-07_10-00_test_1.csv", filepath.Base(late))
+
+	// Close uploads the open buckets too, and returns once all uploads are finished (the local files are removed)
+	p.Close()
+	require.NoFileExists(t, uploaded)
+	require.NoFileExists(t, late)
+}
@@ -0,0 +1,155 @@
+package collector
+
+// Live stream of newly processed transactions for websocket clients (i.e. for a real-time monitoring dashboard)
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+const (
+	txStreamClientBufferSize = 1000 // messages buffered per client before it's dropped as too slow
+	txStreamWriteTimeout     = 5 * time.Second
+)
+
+// TxStream broadcasts newly processed transactions to all connected websocket clients. Slow clients are
+// dropped instead of blocking the processing of transactions.
+type TxStream struct {
+	log        *zap.SugaredLogger
+	listenAddr string
+	upgrader   websocket.Upgrader
+
+	clients     map[*txStreamClient]bool
+	clientsLock sync.Mutex
+}
+
+type txStreamClient struct {
+	conn  *websocket.Conn
+	sendC chan []byte
+}
+
+func NewTxStream(log *zap.SugaredLogger, listenAddr string) *TxStream {
+	return &TxStream{
+		log:        log.With("service", "txstream"),
+		listenAddr: listenAddr,
+		upgrader: websocket.Upgrader{ //nolint:exhaustruct
+			CheckOrigin: func(r *http.Request) bool { return true }, // read-only stream, allow dashboards on any origin
+		},
+		clients: make(map[*txStreamClient]bool),
+	}
+}
+
+// Start runs the websocket server (blocking)
+func (s *TxStream) Start() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", s.handleStream)
+
+	srv := &http.Server{ //nolint:exhaustruct
+		Addr:              s.listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	s.log.Infow("starting tx stream server", "listenAddr", s.listenAddr)
+	return srv.ListenAndServe()
+}
+
+func (s *TxStream) handleStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.Errorw("failed to upgrade connection", "error", err)
+		return
+	}
+
+	client := &txStreamClient{
+		conn:  conn,
+		sendC: make(chan []byte, txStreamClientBufferSize),
+	}
+
+	s.clientsLock.Lock()
+	s.clients[client] = true
+	numClients := len(s.clients)
+	s.clientsLock.Unlock()
+	s.log.Infow("client connected", "remoteAddr", conn.RemoteAddr().String(), "clients", numClients)
+
+	go s.writeLoop(client)
+	go s.readLoop(client)
+}
+
+// writeLoop sends the messages to the client until its send channel is closed or a write fails
+func (s *TxStream) writeLoop(client *txStreamClient) {
+	for msg := range client.sendC {
+		_ = client.conn.SetWriteDeadline(time.Now().Add(txStreamWriteTimeout))
+		if err := client.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			s.removeClient(client)
+			return
+		}
+	}
+}
+
+// readLoop discards incoming messages, but is needed to process control frames and detect closed connections
+func (s *TxStream) readLoop(client *txStreamClient) {
+	for {
+		if _, _, err := client.conn.ReadMessage(); err != nil {
+			s.removeClient(client)
+			return
+		}
+	}
+}
+
+func (s *TxStream) removeClient(client *txStreamClient) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	if !s.clients[client] {
+		return // already removed
+	}
+	delete(s.clients, client)
+	close(client.sendC)
+	_ = client.conn.Close()
+	s.log.Infow("client disconnected", "remoteAddr", client.conn.RemoteAddr().String(), "clients", len(s.clients))
+}
+
+// Write broadcasts the transaction (TxStream is a Sink)
+func (s *TxStream) Write(txDetail TxDetail) error {
+	s.Broadcast(txDetail)
+	return nil
+}
+
+// Close disconnects all clients
+func (s *TxStream) Close() error {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	for client := range s.clients {
+		delete(s.clients, client)
+		close(client.sendC)
+		_ = client.conn.Close()
+	}
+	return nil
+}
+
+// Broadcast sends the transaction to all clients, without blocking. Clients which can't keep up are dropped.
+func (s *TxStream) Broadcast(txDetail TxDetail) {
+	msg, err := json.Marshal(txDetail)
+	if err != nil {
+		s.log.Errorw("json.Marshal", "error", err)
+		return
+	}
+
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	for client := range s.clients {
+		select {
+		case client.sendC <- msg:
+		default:
+			s.log.Warnw("dropping slow client", "remoteAddr", client.conn.RemoteAddr().String())
+			delete(s.clients, client)
+			close(client.sendC)
+			_ = client.conn.Close()
+		}
+	}
+}
@@ -4,16 +4,29 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/flashbots/mempool-dumpster/common"
 )
 
 type TxIn struct {
-	T      time.Time
+	T      time.Time // time of receipt by the connection, taken before decoding and sending to the processor
 	Tx     *types.Transaction
-	Source string
+	Source common.Source
 }
 
 type TxDetail struct {
 	Timestamp int64  `json:"timestamp"`
 	Hash      string `json:"hash"`
 	RawTx     string `json:"rawTx"`
+
+	// signature, only set with TxProcessorOpts.WriteSignature (hex encoded, the y-parity is normalized for all tx types)
+	YParity string `json:"yParity,omitempty"`
+	R       string `json:"r,omitempty"`
+	S       string `json:"s,omitempty"`
+
+	// calldata size (bytes) and intrinsic gas, only set with TxProcessorOpts.WriteCalldataGas
+	CalldataSize *uint64 `json:"calldataSize,omitempty"`
+	IntrinsicGas *uint64 `json:"intrinsicGas,omitempty"`
+
+	// collector which recorded the tx, only set with TxProcessorOpts.Origin
+	Origin string `json:"origin,omitempty"`
 }
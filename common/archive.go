@@ -0,0 +1,124 @@
+package common
+
+import (
+	"archive/tar"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ArchiveExt is the file extension of a day archive (zstd-compressed tar of a collector date directory)
+const ArchiveExt = ".tar.zst"
+
+// ArchiveSubDirs are the subdirectories of a collector date directory which are added to the day archive
+var ArchiveSubDirs = []string{"transactions", "sourcelog", "trash", "replacements", "sourcetxs", "persource"}
+
+// IsArchive returns whether the file is a day archive
+func IsArchive(filename string) bool {
+	return strings.HasSuffix(filename, ArchiveExt)
+}
+
+// WriteArchive writes all CSV files of the ArchiveSubDirs of a collector date directory (i.e. out/2023-08-07) as
+// zstd-compressed tar to w. Entries are named <subDir>/<filename>. Returns the number of archived files.
+func WriteArchive(dateDir string, w io.Writer) (cntFiles int, err error) {
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return 0, err
+	}
+	tw := tar.NewWriter(zw)
+
+	for _, subDir := range ArchiveSubDirs {
+		files, err := filepath.Glob(filepath.Join(dateDir, subDir, "*.csv"))
+		if err != nil {
+			return cntFiles, err
+		}
+		for _, fn := range files {
+			err = addFileToArchive(tw, fn, path.Join(subDir, filepath.Base(fn)))
+			if err != nil {
+				return cntFiles, err
+			}
+			cntFiles += 1
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return cntFiles, err
+	}
+	return cntFiles, zw.Close()
+}
+
+func addFileToArchive(tw *tar.Writer, fn, name string) error {
+	f, err := os.Open(fn)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ReadArchive calls fn for every CSV file in the given subdirectory of a day archive (i.e. "sourcelog")
+func ReadArchive(filename, subDir string, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || path.Dir(hdr.Name) != subDir || path.Ext(hdr.Name) != ".csv" {
+			continue
+		}
+		if err = fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// GetCSVFromArchive returns the CSV content of all files in the given subdirectory of a day archive
+func GetCSVFromArchive(filename, subDir string) (rows [][]string, err error) {
+	rows = make([][]string, 0)
+	err = ReadArchive(filename, subDir, func(name string, r io.Reader) error {
+		_rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		rows = append(rows, _rows...)
+		return nil
+	})
+	return rows, err
+}
@@ -1,14 +1,24 @@
 package common
 
 import (
+	"bytes"
+	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stretchr/testify/require"
 	"github.com/xitongsys/parquet-go-source/local"
 	"github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/reader"
 	"github.com/xitongsys/parquet-go/writer"
+	"go.uber.org/zap"
 )
 
 var (
@@ -27,6 +37,32 @@ func TestParseTx(t *testing.T) {
 	require.Equal(t, test1Rlp, summary.RawTxHex())
 }
 
+func TestTxSignature(t *testing.T) {
+	tx, err := RLPStringToTx(test1Rlp)
+	require.NoError(t, err)
+	yParity, r, s := TxSignature(tx)
+	require.Equal(t, uint64(0), yParity)
+	require.Equal(t, "0x51eb99ae13fd1ace55dd93a4b36eefa5d34e115cd7b9fd5d0ffac07300cbaeb2", hexutil.EncodeBig(r))
+	require.Equal(t, "0x782d9ad12490b45af932d8c98cb3c2fd8c02cdd6317edb36bde2df7556fa9132", hexutil.EncodeBig(s))
+}
+
+func TestIntrinsicGas(t *testing.T) {
+	to := ethcommon.HexToAddress("0x01")
+	key := ethcommon.HexToHash("0x02")
+
+	// 21,000 base, 4 per zero byte, 16 per non-zero byte
+	tx := types.NewTx(&types.LegacyTx{To: &to, Data: []byte{0, 1, 2}}) //nolint:exhaustruct
+	require.Equal(t, uint64(21_000+4+2*16), IntrinsicGas(tx))
+
+	// 2,400 per access list address, 1,900 per storage key
+	tx = types.NewTx(&types.DynamicFeeTx{To: &to, AccessList: types.AccessList{{Address: to, StorageKeys: []ethcommon.Hash{key, key}}}}) //nolint:exhaustruct
+	require.Equal(t, uint64(21_000+2_400+2*1_900), IntrinsicGas(tx))
+
+	// contract creation: 53,000 base, 2 per init code word
+	tx = types.NewTx(&types.LegacyTx{Data: bytes.Repeat([]byte{1}, 33)}) //nolint:exhaustruct
+	require.Equal(t, uint64(53_000+33*16+2*2), IntrinsicGas(tx))
+}
+
 func TestParquet(t *testing.T) {
 	summary, _, err := parseTx(int64(1693785600337), test1Rlp)
 	require.NoError(t, err)
@@ -97,3 +133,215 @@ func TestParquet(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, summary.Hash, summary2.Hash)
 }
+
+func TestSourcelogTimestamp(t *testing.T) {
+	ts := time.UnixMicro(1693785600337123).UTC()
+	require.Equal(t, int64(1693785600337), SourcelogTimestamp(ts, SourcelogTimestampMs))
+	require.Equal(t, int64(1693785600337123), SourcelogTimestamp(ts, SourcelogTimestampUs))
+	require.Equal(t, int64(1693785600337123000), SourcelogTimestamp(ts, SourcelogTimestampNs))
+
+	for _, res := range []string{SourcelogTimestampMs, SourcelogTimestampUs, SourcelogTimestampNs} {
+		require.Equal(t, int64(1693785600337), SourcelogTimestampToMs(SourcelogTimestamp(ts, res)), res)
+	}
+	require.Equal(t, int64(1693785600337000), SourcelogTimestampToUs(SourcelogTimestamp(ts, SourcelogTimestampMs)))
+	require.Equal(t, int64(1693785600337123), SourcelogTimestampToUs(SourcelogTimestamp(ts, SourcelogTimestampUs)))
+	require.Equal(t, int64(1693785600337123), SourcelogTimestampToUs(SourcelogTimestamp(ts, SourcelogTimestampNs)))
+
+	require.ErrorIs(t, ValidateSourcelogTimestampResolution("s"), ErrInvalidTimestampResolution)
+}
+
+func TestLoadSourceLogFiles(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "sourcelog.csv")
+	content := strings.Join([]string{
+		"timestamp_ms,hash,source,origin", // header
+		"1693785600340," + test1Hash + ",local,c1@host1",
+		"1693785600337000," + strings.ToUpper(test1Hash[2:]) + ",local",     // missing 0x
+		"1693785600337001,0x" + strings.ToUpper(test1Hash[2:]) + ",local\r", // us, earlier, uppercase, CRLF
+		"1693785600341,\"" + test1Hash + "\",bloxroute",                     // quoted
+		"1693785600342," + test1Hash,                                        // too few columns
+		"1693785600x," + test1Hash + ",infura",                              // invalid timestamp
+		"1693785600343,0x" + strings.Repeat("g", 64) + ",infura",            // invalid hash
+		"",
+	}, "\n")
+	require.NoError(t, os.WriteFile(fn, []byte(content), 0o600))
+
+	sourcelog, cnt := LoadSourceLogFiles(zap.NewNop().Sugar(), []string{fn})
+	require.Equal(t, int64(3), cnt)
+	require.Equal(t, map[string]map[string]int64{
+		test1Hash: {"local": 1693785600337001, "bloxroute": 1693785600341000},
+	}, sourcelog)
+}
+
+// benchmarkSourcelogFile writes a sourcelog file with 100k rows of 10k txs
+func benchmarkSourcelogFile(b *testing.B) string {
+	b.Helper()
+	fn := filepath.Join(b.TempDir(), "sourcelog.csv")
+	var buf bytes.Buffer
+	buf.WriteString("timestamp_ms,hash,source\n")
+	for i := 0; i < 100_000; i++ {
+		fmt.Fprintf(&buf, "%d,0x%064x,source%d\n", 1693785600000+i, i%10_000, i%10)
+	}
+	require.NoError(b, os.WriteFile(fn, buf.Bytes(), 0o600))
+	return fn
+}
+
+func BenchmarkLoadSourceLogFiles(b *testing.B) {
+	fn := benchmarkSourcelogFile(b)
+	log := zap.NewNop().Sugar()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		LoadSourceLogFiles(log, []string{fn})
+	}
+}
+
+// BenchmarkLoadSourceLogFilesNaive is the baseline of BenchmarkLoadSourceLogFiles: all rows read with encoding/csv
+func BenchmarkLoadSourceLogFilesNaive(b *testing.B) {
+	fn := benchmarkSourcelogFile(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rows, err := GetCSV(fn)
+		require.NoError(b, err)
+		txs := make(map[string]map[string]int64)
+		for _, row := range rows[1:] {
+			ts, err := strconv.ParseInt(row[0], 10, 64)
+			require.NoError(b, err)
+			hash := strings.ToLower(row[1])
+			_, err = hexutil.Decode(hash)
+			require.NoError(b, err)
+			if _, ok := txs[hash]; !ok {
+				txs[hash] = make(map[string]int64)
+			}
+			if prev, ok := txs[hash][TxSourcName(row[2])]; !ok || ts < prev {
+				txs[hash][TxSourcName(row[2])] = ts
+			}
+		}
+	}
+}
+
+func TestRepairTransactionsCSV(t *testing.T) {
+	line := "1693785600337," + test1Hash + "," + test1Rlp + "\n"
+	input := line +
+		line + // duplicate
+		"1693785600337,0xbb59e550e4730da43af01b7ae6e1d05b1df501baa4119b8ab6a3427d9b3635b2," + test1Rlp + "\n" + // hash mismatch
+		"abc," + test1Hash + "," + test1Rlp + "\n" + // invalid timestamp
+		"1693785600337," + test1Hash + ",0x02f873\n" + // invalid rlp
+		"1693785600337," + test1Hash + "," + test1Rlp[:50] // truncated
+
+	var out strings.Builder
+	report, err := RepairTransactionsCSV(strings.NewReader(input), &out)
+	require.NoError(t, err)
+	require.Equal(t, line, out.String())
+	require.Equal(t, 6, report.LinesTotal)
+	require.Equal(t, 1, report.LinesKept)
+	require.Equal(t, map[string]int{
+		RepairDuplicate:        1,
+		RepairHashMismatch:     1,
+		RepairInvalidTimestamp: 1,
+		RepairInvalidRLP:       1,
+		RepairMalformed:        1,
+	}, report.Removed)
+	require.Equal(t, RemovedLine{Line: 6, Reason: RepairMalformed}, report.RemovedLines[4])
+}
+
+func TestReconcileSourcelogTxs(t *testing.T) {
+	sourcelog := [][]string{
+		{"1693785600337", "0x01", "local"},
+		{"1693785600340", "0x01", "bloxroute"},
+		{"1693785600337", "0x02", "local"}, // trashed
+		{"1693785600337", "0x03", "local"}, // orphan
+	}
+	txs := [][]string{
+		{"1693785600337", "0x01", "0x00"},
+		{"1693785600337", "0x04", "0x00"}, // orphan
+	}
+	trash := [][]string{{"1693785600337", "0x02", "local", "tx-too-large", ""}}
+
+	report := ReconcileSourcelogTxs(sourcelog, txs, trash)
+	require.Equal(t, 3, report.SourcelogTxs)
+	require.Equal(t, 2, report.TransactionsTxs)
+	require.Equal(t, 1, report.Trashed)
+	require.Equal(t, []string{"0x03"}, report.OnlyInSourcelog)
+	require.Equal(t, []string{"0x04"}, report.OnlyInTransactions)
+}
+
+func TestTrashSummary(t *testing.T) {
+	summary := NewTrashSummary()
+	summary.Add([][]string{
+		{"timestamp_ms", "hash", "source", "reason", "notes"}, // header
+		{"1693785600337", "0x01", "local", TrashTxTooLarge, "size=200000"},
+		{"1693785600340", "0x01", "bloxroute", TrashTxTooLarge, "size=200000"},
+		{"1693785600341", "0x02", "local", "other", ""},
+	})
+	summary.Add([][]string{{"1693785600337", "0x03"}}) // invalid
+
+	require.Equal(t, 3, summary.Rows)
+	require.Equal(t, 2, summary.UniqueTxs)
+	require.Equal(t, 1, summary.Invalid)
+	require.Equal(t, map[string]int{TrashTxTooLarge: 2, "other": 1}, summary.ByReason)
+	require.Equal(t, map[string]int{TrashTxTooLarge: 1, "other": 1}, summary.BySource["local"])
+	require.Equal(t, []string{TrashTxTooLarge, "other"}, sortedByCount(summary.ByReason))
+}
+
+func TestFirstRegions(t *testing.T) {
+	require.Equal(t, "eu-west", RegionOfOrigin("c1@host1/eu-west"))
+	require.Equal(t, "", RegionOfOrigin("c1@host1"))
+
+	firstRegions := NewFirstRegions()
+	firstRegions.Add([][]string{
+		{"timestamp_ms", "hash", "source", "origin"}, // header
+		{"1693785600340", "0x01", "local", "c1@host1/eu-west"},
+		{"1693785600400", "0x02", "local", "c1@host1/eu-west"},
+		{"1693785600400", "0x03", "local", "c1@host1/us-east"},
+		{"1693785600500", "0x04", "local", "c1@host1"}, // no region
+	})
+	firstRegions.Add([][]string{
+		{"1693785600337000", "0x01", "bloxroute", "c2@host2/us-east"}, // us, earlier
+		{"1693785600400", "0x02", "local", "c2@host2/ap-south"},       // equal timestamp, alphabetically first
+		{"1693785600400", "0x03"},                                     // invalid
+	})
+
+	require.Equal(t, map[string]RegionSighting{
+		"0x01": {1693785600337, "us-east"},
+		"0x02": {1693785600400, "ap-south"},
+		"0x03": {1693785600400, "us-east"},
+	}, firstRegions.First)
+	require.Equal(t, 1, firstRegions.NoRegion)
+	require.Equal(t, 1, firstRegions.Invalid)
+	require.Equal(t, map[string]int{"us-east": 2, "ap-south": 1}, firstRegions.CountByRegion())
+	require.Equal(t, []string{"0x01", "0x02", "0x03"}, firstRegions.SortedHashes())
+}
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	dateDir := filepath.Join(dir, "2023-08-07")
+	for subDir, content := range map[string]string{
+		"transactions": "1693785600337," + test1Hash + "," + test1Rlp + "\n",
+		"sourcelog":    "1693785600337," + test1Hash + ",local\n",
+		"trash":        "1693785600337," + test1Hash + ",local,tx-too-large\n",
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dateDir, subDir), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dateDir, subDir, subDir+".csv"), []byte(content), 0o600))
+	}
+
+	fn := filepath.Join(dir, "2023-08-07"+ArchiveExt)
+	f, err := os.Create(fn)
+	require.NoError(t, err)
+	cntFiles, err := WriteArchive(dateDir, f)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Equal(t, 3, cntFiles)
+
+	rows, err := GetCSVFromArchive(fn, "sourcelog")
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"1693785600337", test1Hash, "local"}}, rows)
+
+	log := zap.NewNop().Sugar()
+	sourcelog, cnt := LoadSourceLogFiles(log, []string{fn})
+	require.Equal(t, int64(1), cnt)
+	require.Equal(t, int64(1693785600337000), sourcelog[test1Hash]["local"])
+
+	txs, err := LoadTransactionCSVFiles(log, []string{fn}, nil)
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	require.Contains(t, txs, test1Hash)
+}
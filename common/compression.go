@@ -0,0 +1,82 @@
+package common
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs of the collector output files
+const (
+	CompressionNone   = "none" // default
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy" // framed snappy stream
+)
+
+// compressionExts are the file extensions of the compressed output files, appended to ".csv"
+var compressionExts = map[string]string{
+	CompressionGzip:   ".gz",
+	CompressionZstd:   ".zst",
+	CompressionSnappy: ".sz",
+}
+
+// CompressionExt returns the file extension of the codec (empty for none)
+func CompressionExt(codec string) string {
+	return compressionExts[codec]
+}
+
+// IsCompressedCSV returns whether the file is a CSV file compressed by the collector (.csv.gz, .csv.zst or .csv.sz)
+func IsCompressedCSV(filename string) bool {
+	for _, ext := range compressionExts {
+		if strings.HasSuffix(filename, ".csv"+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// openCompressedCSV returns a decompressing reader of a compressed CSV file. Concatenated streams (i.e. of a file
+// that was appended to after it was closed) are read as one.
+func openCompressedCSV(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	switch {
+	case strings.HasSuffix(filename, compressionExts[CompressionGzip]):
+		r, err = gzip.NewReader(f)
+	case strings.HasSuffix(filename, compressionExts[CompressionZstd]):
+		var zr *zstd.Decoder
+		zr, err = zstd.NewReader(f)
+		if err == nil {
+			r = zr.IOReadCloser()
+		}
+	default:
+		r = s2.NewReader(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return readCloser{r, f}, nil
+}
+
+// readCloser reads from a decompressor and closes the underlying file
+type readCloser struct {
+	io.Reader
+	f *os.File
+}
+
+func (rc readCloser) Close() error {
+	if c, ok := rc.Reader.(io.Closer); ok {
+		_ = c.Close()
+	}
+	return rc.f.Close()
+}
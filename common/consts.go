@@ -7,6 +7,20 @@ const (
 	ChainboundTag = "chainbound"
 )
 
+// Sourcelog timestamp resolutions (the first column of the sourcelog CSV)
+const (
+	SourcelogTimestampMs = "ms" // default, compatible with all tooling
+	SourcelogTimestampUs = "us"
+	SourcelogTimestampNs = "ns"
+)
+
+// Reasons for writing a tx to the trash file instead of the txs file
+const (
+	TrashTxTooLarge       = "tx-too-large"
+	TrashInvalidSignature = "invalid-signature"
+	TrashFiltered         = "filtered"
+)
+
 func TxSourcName(uri string) string {
 	sourceAlias := SourceAliasesFromEnv()
 	if alias, ok := sourceAlias[uri]; ok {
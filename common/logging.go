@@ -8,23 +8,29 @@ import (
 )
 
 func GetLogger(debug, prod bool) *zap.SugaredLogger {
-	var logger *zap.Logger
-	zapLevel := zap.NewAtomicLevel()
+	level := zapcore.InfoLevel
 	if debug {
-		zapLevel.SetLevel(zap.DebugLevel)
+		level = zapcore.DebugLevel
 	}
-	if prod {
+	return NewLogger(prod, level, os.Stdout)
+}
+
+// NewLogger returns a logger writing JSON (i.e. for log pipelines) or console encoded logs of the given level to out
+func NewLogger(json bool, level zapcore.Level, out zapcore.WriteSyncer) *zap.SugaredLogger {
+	var logger *zap.Logger
+	zapLevel := zap.NewAtomicLevelAt(level)
+	if json {
 		encoderCfg := zap.NewProductionEncoderConfig()
 		encoderCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 		logger = zap.New(zapcore.NewCore(
 			zapcore.NewJSONEncoder(encoderCfg),
-			zapcore.Lock(os.Stdout),
+			zapcore.Lock(out),
 			zapLevel,
 		))
 	} else {
 		logger = zap.New(zapcore.NewCore(
 			zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
-			zapcore.Lock(os.Stdout),
+			zapcore.Lock(out),
 			zapLevel,
 		))
 	}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Sides of an orphaned hash in the ReconcileReport
+const (
+	ReconcileOnlySourcelog    = "only-sourcelog"
+	ReconcileOnlyTransactions = "only-transactions"
+)
+
+// ReconcileReport describes the consistency of the sourcelog and the transactions file of a bucket
+type ReconcileReport struct {
+	SourcelogTxs    int // unique hashes in the sourcelog
+	TransactionsTxs int // unique hashes in the transactions file
+	Trashed         int // hashes only in the sourcelog, which are explained by the trash file
+
+	OnlyInSourcelog    []string // sorted, without the trashed txs
+	OnlyInTransactions []string // sorted
+}
+
+// ReconcileSourcelogTxs cross-checks the rows of a sourcelog (timestamp,hash,source) against the rows of the
+// transactions file (timestamp_ms,hash,raw_tx,...) of the same bucket, and reports the orphaned hashes on either
+// side (i.e. after a crash). Hashes in the trash file (timestamp_ms,hash,source,reason,notes) were intentionally not
+// written to the transactions file. trashRows is optional.
+//
+// Note: a tx which is first received at the end of a bucket can have its sourcelog entries from other sources in
+// the next bucket, so a few orphans at the bucket boundaries are expected.
+func ReconcileSourcelogTxs(sourcelogRows, txsRows, trashRows [][]string) *ReconcileReport {
+	sourcelogTxs := hashesOfRows(sourcelogRows)
+	txs := hashesOfRows(txsRows)
+	trashed := hashesOfRows(trashRows)
+
+	report := &ReconcileReport{ //nolint:exhaustruct
+		SourcelogTxs:    len(sourcelogTxs),
+		TransactionsTxs: len(txs),
+	}
+	for hash := range sourcelogTxs {
+		switch {
+		case txs[hash]:
+		case trashed[hash]:
+			report.Trashed++
+		default:
+			report.OnlyInSourcelog = append(report.OnlyInSourcelog, hash)
+		}
+	}
+	for hash := range txs {
+		if !sourcelogTxs[hash] {
+			report.OnlyInTransactions = append(report.OnlyInTransactions, hash)
+		}
+	}
+
+	sort.Strings(report.OnlyInSourcelog)
+	sort.Strings(report.OnlyInTransactions)
+	return report
+}
+
+// hashesOfRows returns the set of (lowercase) hashes in the second column of CSV rows
+func hashesOfRows(rows [][]string) map[string]bool {
+	hashes := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		if len(row) < 2 || IsHeaderRow(row) {
+			continue
+		}
+		hashes[strings.ToLower(row[1])] = true
+	}
+	return hashes
+}
+
+// String returns a summary of the report, with the number of orphaned hashes on either side
+func (r *ReconcileReport) String() string {
+	return fmt.Sprintf("sourcelog: %d, transactions: %d, trashed: %d, %s: %d, %s: %d",
+		r.SourcelogTxs, r.TransactionsTxs, r.Trashed,
+		ReconcileOnlySourcelog, len(r.OnlyInSourcelog),
+		ReconcileOnlyTransactions, len(r.OnlyInTransactions),
+	)
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RegionOfOrigin returns the region of an origin value in the format <uid>@<hostname>/<region> (empty if it has none)
+func RegionOfOrigin(origin string) string {
+	if i := strings.LastIndex(origin, "/"); i >= 0 {
+		return origin[i+1:]
+	}
+	return ""
+}
+
+// RegionSighting is the first sighting of a tx in a region
+type RegionSighting struct {
+	TimestampMs int64
+	Region      string
+}
+
+// FirstRegions determines the region which saw each tx first globally, from the sourcelog rows of collectors in
+// multiple regions (timestamp,hash,source,origin, with the region in the origin, see RegionOfOrigin). Equal
+// timestamps are won by the alphabetically first region, so the result doesn't depend on the order of the files.
+type FirstRegions struct {
+	First    map[string]RegionSighting // [hash] = first sighting
+	NoRegion int                       // rows without an origin with a region (i.e. collectors without -region)
+	Invalid  int                       // rows which are not sourcelog rows (i.e. too few columns)
+}
+
+func NewFirstRegions() *FirstRegions {
+	return &FirstRegions{First: make(map[string]RegionSighting)} //nolint:exhaustruct
+}
+
+// Add processes the rows of a sourcelog file (header rows are skipped), and can be called once per file
+func (f *FirstRegions) Add(rows [][]string) {
+	for _, row := range rows {
+		if IsHeaderRow(row) {
+			continue
+		}
+		if len(row) < 3 {
+			f.Invalid++
+			continue
+		}
+		region := ""
+		if len(row) >= 4 {
+			region = RegionOfOrigin(row[3])
+		}
+		if region == "" {
+			f.NoRegion++
+			continue
+		}
+		ts, err := strconv.ParseInt(row[0], 10, 64)
+		if err != nil {
+			f.Invalid++
+			continue
+		}
+
+		sighting := RegionSighting{SourcelogTimestampToMs(ts), region}
+		hash := strings.ToLower(row[1])
+		prev, ok := f.First[hash]
+		if !ok || sighting.TimestampMs < prev.TimestampMs || (sighting.TimestampMs == prev.TimestampMs && region < prev.Region) {
+			f.First[hash] = sighting
+		}
+	}
+}
+
+// CountByRegion returns the number of txs which each region saw first
+func (f *FirstRegions) CountByRegion() map[string]int {
+	counts := make(map[string]int)
+	for _, sighting := range f.First {
+		counts[sighting.Region]++
+	}
+	return counts
+}
+
+// SortedHashes returns the hashes sorted by the timestamp of their first sighting (and by hash for equal timestamps)
+func (f *FirstRegions) SortedHashes() []string {
+	hashes := make([]string, 0, len(f.First))
+	for hash := range f.First {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		ti, tj := f.First[hashes[i]].TimestampMs, f.First[hashes[j]].TimestampMs
+		if ti != tj {
+			return ti < tj
+		}
+		return hashes[i] < hashes[j]
+	})
+	return hashes
+}
+
+// String returns the report: the number of txs first seen per region (sorted by count)
+func (f *FirstRegions) String() string {
+	out := fmt.Sprintf("Txs first seen per region: %s (rows without region: %s, invalid rows: %s)\n", Printer.Sprint(len(f.First)), Printer.Sprint(f.NoRegion), Printer.Sprint(f.Invalid))
+	counts := f.CountByRegion()
+	for _, region := range sortedByCount(counts) {
+		out += fmt.Sprintf("- %-20s %10s   (%7s)\n", region, Printer.Sprint(counts[region]), Int64DiffPercentFmt(int64(counts[region]), int64(len(f.First))))
+	}
+	return out
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Reasons for removing a line in RepairTransactionsCSV
+const (
+	RepairMalformed        = "malformed"
+	RepairInvalidTimestamp = "invalid-timestamp"
+	RepairInvalidRLP       = "invalid-rlp"
+	RepairHashMismatch     = "hash-mismatch"
+	RepairDuplicate        = "duplicate"
+)
+
+// RepairReport describes what RepairTransactionsCSV removed
+type RepairReport struct {
+	LinesTotal   int
+	LinesKept    int
+	Removed      map[string]int // [reason] = count
+	RemovedLines []RemovedLine
+}
+
+type RemovedLine struct {
+	Line   int // 1-based line number in the input
+	Reason string
+}
+
+// RepairTransactionsCSV copies a transactions CSV (timestamp_ms,hash,raw_tx[,...]) from rd to w, dropping lines which
+// are malformed (i.e. truncated by a power outage), have a raw tx which doesn't decode to the stated hash, or repeat
+// an already written hash. Kept lines are written unchanged.
+func RepairTransactionsCSV(rd io.Reader, w io.Writer) (*RepairReport, error) {
+	report := &RepairReport{Removed: make(map[string]int)} //nolint:exhaustruct
+	remove := func(reason string) {
+		report.Removed[reason]++
+		report.RemovedLines = append(report.RemovedLines, RemovedLine{Line: report.LinesTotal, Reason: reason})
+	}
+
+	seen := make(map[string]bool)
+	fileReader := bufio.NewReader(rd)
+	for {
+		l, err := fileReader.ReadString('\n')
+		if len(l) == 0 && err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return report, err
+		}
+		report.LinesTotal++
+
+		// a truncated last line has no newline
+		if !strings.HasSuffix(l, "\n") {
+			remove(RepairMalformed)
+			continue
+		}
+
+		items := strings.Split(strings.TrimSuffix(l, "\n"), ",")
+
+		// keep the header row of the first line
+		if report.LinesTotal == 1 && IsHeaderRow(items) {
+			if _, err := io.WriteString(w, l); err != nil {
+				return report, err
+			}
+			report.LinesKept++
+			continue
+		}
+
+		if len(items) < 3 || len(items[1]) != 66 {
+			remove(RepairMalformed)
+			continue
+		}
+
+		if _, err := strconv.ParseInt(items[0], 10, 64); err != nil {
+			remove(RepairInvalidTimestamp)
+			continue
+		}
+
+		tx, err := RLPStringToTx(items[2])
+		if err != nil {
+			remove(RepairInvalidRLP)
+			continue
+		}
+
+		txHash := strings.ToLower(items[1])
+		if tx.Hash().Hex() != txHash {
+			remove(RepairHashMismatch)
+			continue
+		}
+
+		if seen[txHash] {
+			remove(RepairDuplicate)
+			continue
+		}
+		seen[txHash] = true
+
+		if _, err := io.WriteString(w, l); err != nil {
+			return report, err
+		}
+		report.LinesKept++
+	}
+
+	return report, nil
+}
+
+// String returns a summary of the report, with the number of removed lines per reason
+func (r *RepairReport) String() string {
+	reasons := []string{RepairMalformed, RepairInvalidTimestamp, RepairInvalidRLP, RepairHashMismatch, RepairDuplicate}
+	out := fmt.Sprintf("lines: %d, kept: %d, removed: %d", r.LinesTotal, r.LinesKept, r.LinesTotal-r.LinesKept)
+	for _, reason := range reasons {
+		if r.Removed[reason] > 0 {
+			out += fmt.Sprintf(", %s: %d", reason, r.Removed[reason])
+		}
+	}
+	return out
+}
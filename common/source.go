@@ -0,0 +1,33 @@
+package common
+
+// SourceKind classifies where a source gets its transactions from
+type SourceKind string
+
+const (
+	SourceKindPublic  SourceKind = "public"  // a node of the public p2p network (own or hosted)
+	SourceKindPrivate SourceKind = "private" // a private transaction distribution network (i.e. bloxroute, eden, chainbound)
+)
+
+// Transports of the source connections
+const (
+	SourceTransportWebsocket = "websocket"
+	SourceTransportGRPC      = "grpc"
+	SourceTransportFile      = "file" // replay of recorded files
+)
+
+// Source identifies a mempool data source. The name is what's recorded in the output files (i.e. in the sourcelog).
+type Source struct {
+	Name      string
+	Kind      SourceKind
+	Transport string
+}
+
+// String returns the source name
+func (s Source) String() string {
+	return s.Name
+}
+
+// IsPrivate returns whether the source is a private transaction distribution network
+func (s Source) IsPrivate() bool {
+	return s.Kind == SourceKindPrivate
+}
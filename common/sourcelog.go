@@ -1,89 +1,253 @@
 package common
 
 import (
-	"strconv"
-	"strings"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
 
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"go.uber.org/zap"
 )
 
-// LoadSourceLogFiles loads sourcelog .csv (or .csv.zip) files (format: <timestamp_ms>,<tx_hash>,<source>) and returns a map[hash][source] = timestampMs
-func LoadSourceLogFiles(log *zap.SugaredLogger, files []string) (txs map[string]map[string]int64, cntProcessedRecords int64) { //nolint:gocognit
-	txs = make(map[string]map[string]int64)
+var ErrInvalidTimestampResolution = errors.New("invalid sourcelog timestamp resolution")
 
-	timestampFirst, timestampLast := int64(0), int64(0)
-	cntProcessedFiles := 0
+// sourcelogReadBufferSize is the read buffer of the sourcelog loader (sourcelog lines are ~100 bytes)
+const sourcelogReadBufferSize = 1 << 20
 
-	// Collect transactions from all input files to memory
+// SourcelogTimestamp returns the sourcelog timestamp of t in the given resolution (SourcelogTimestampMs/Us/Ns)
+func SourcelogTimestamp(t time.Time, resolution string) int64 {
+	switch resolution {
+	case SourcelogTimestampUs:
+		return t.UnixMicro()
+	case SourcelogTimestampNs:
+		return t.UnixNano()
+	default:
+		return t.UnixMilli()
+	}
+}
+
+// ValidateSourcelogTimestampResolution returns an error if the resolution is not one of SourcelogTimestampMs/Us/Ns
+func ValidateSourcelogTimestampResolution(resolution string) error {
+	switch resolution {
+	case SourcelogTimestampMs, SourcelogTimestampUs, SourcelogTimestampNs:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s (must be %s, %s or %s)", ErrInvalidTimestampResolution, resolution, SourcelogTimestampMs, SourcelogTimestampUs, SourcelogTimestampNs)
+	}
+}
+
+// SourcelogTimestampToMs converts a sourcelog timestamp of any resolution to milliseconds. The resolution is detected
+// by the magnitude: ms timestamps have 13 digits, us 16 and ns 19 (valid until the year 2286).
+func SourcelogTimestampToMs(ts int64) int64 {
+	switch {
+	case ts >= 1e17:
+		return ts / 1e6
+	case ts >= 1e14:
+		return ts / 1e3
+	default:
+		return ts
+	}
+}
+
+// SourcelogTimestampToUs converts a sourcelog timestamp of any resolution to microseconds (see SourcelogTimestampToMs
+// for the detection of the resolution). Millisecond timestamps are converted upward, so files of all resolutions can
+// be compared without truncating the finer ones.
+func SourcelogTimestampToUs(ts int64) int64 {
+	switch {
+	case ts >= 1e17:
+		return ts / 1e3
+	case ts >= 1e14:
+		return ts
+	default:
+		return ts * 1e3
+	}
+}
+
+// LoadSourceLogFiles loads sourcelog .csv (or .csv.zip, compressed .csv.gz/.zst/.sz, or the sourcelog of day archives) files (format: <timestamp>,<tx_hash>,<source>) and returns a map[hash][source] = timestampUs.
+// Timestamps of all resolutions are converted to microseconds, so sub-millisecond differences are kept. The files are streamed through
+// sourcelogLoader, so a day of sourcelog (many GB) is never held in memory as CSV rows.
+func LoadSourceLogFiles(log *zap.SugaredLogger, files []string) (txs map[string]map[string]int64, cntProcessedRecords int64) {
+	loader := newSourcelogLoader(log)
 	for _, filename := range files {
 		log.Infof("Loading %s ...", filename)
-		cntProcessedFiles += 1
-		cntTxInFileTotal := 0
+		cntRowsBefore := loader.cntRows
 
-		rows, err := GetCSV(filename)
+		err := ReadCSVFile(filename, "sourcelog", func(name string, r io.Reader) error {
+			return loader.read(r)
+		})
 		if err != nil {
-			log.Errorw("GetCSV", "error", err)
-			return
+			log.Errorw("ReadCSVFile", "error", err, "file", filename)
+			return loader.txs, loader.cntRecords
 		}
 
-		for _, items := range rows {
-			if len(items) != 3 {
-				log.Errorw("invalid line", "line", items)
-				continue
-			}
+		log.Infow("Processed file",
+			"records", Printer.Sprintf("%d", loader.cntRows-cntRowsBefore),
+			"txTotal", Printer.Sprintf("%d", len(loader.txs)),
+			"memUsedMiB", Printer.Sprintf("%d", GetMemUsageMb()),
+		)
+	}
 
-			cntTxInFileTotal += 1
+	return loader.txs, loader.cntRecords
+}
 
-			if len(items[1]) < 66 {
-				continue
-			}
+// sourcelogLoader parses sourcelog lines into the map[hash][source] = timestampUs of LoadSourceLogFiles. Lines are
+// parsed as bytes from the read buffer, so only new hashes and new sources allocate a string.
+type sourcelogLoader struct {
+	log     *zap.SugaredLogger
+	txs     map[string]map[string]int64
+	sources map[string]string // [source column] = TxSourcName(source column), which is too slow to call for every row
 
-			ts, err := strconv.Atoi(items[0])
-			if err != nil {
-				log.Errorw("strconv.Atoi", "error", err, "line", items)
-				continue
-			}
-			txTimestamp := int64(ts)
-			txHash := strings.ToLower(items[1])
-			txSource := TxSourcName(items[2])
-
-			// that it's a valid hash
-			if len(txHash) != 66 {
-				log.Errorw("invalid hash length", "hash", txHash)
-				continue
-			}
-			if _, err = hexutil.Decode(txHash); err != nil {
-				log.Errorw("hexutil.Decode", "error", err, "line", items)
-				continue
-			}
+	fields [][]byte // columns of the current line (reused)
+	hash   [66]byte // lowercased hash of the current line
 
-			cntProcessedRecords += 1
+	cntRows    int64 // rows with at least 3 columns (excluding headers)
+	cntRecords int64 // valid rows
+}
 
-			if timestampFirst == 0 || txTimestamp < timestampFirst {
-				timestampFirst = txTimestamp
-			}
-			if txTimestamp > timestampLast {
-				timestampLast = txTimestamp
-			}
+func newSourcelogLoader(log *zap.SugaredLogger) *sourcelogLoader {
+	return &sourcelogLoader{ //nolint:exhaustruct
+		log:     log,
+		txs:     make(map[string]map[string]int64),
+		sources: make(map[string]string),
+		fields:  make([][]byte, 0, 8),
+	}
+}
 
-			// Add entry to txs map
-			if _, ok := txs[txHash]; !ok {
-				txs[txHash] = make(map[string]int64)
-				txs[txHash][txSource] = txTimestamp
+// read processes all lines of a sourcelog CSV file
+func (l *sourcelogLoader) read(r io.Reader) error {
+	br := bufio.NewReaderSize(r, sourcelogReadBufferSize)
+	for {
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// not a sourcelog line, skip the rest of it
+			l.log.Errorw("invalid line, too long", "prefix", string(line[:100]))
+			for errors.Is(err, bufio.ErrBufferFull) {
+				_, err = br.ReadSlice('\n')
 			}
+		} else if len(line) > 0 {
+			l.addLine(line)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
 
-			// Update timestamp if it's earlier (i.e. alchemy often sending duplicate entries, this makes sure we record the earliest timestamp)
-			if txs[txHash][txSource] == 0 || txTimestamp < txs[txHash][txSource] {
-				txs[txHash][txSource] = txTimestamp
+// addLine processes a line (only valid until the next read from the buffer)
+func (l *sourcelogLoader) addLine(line []byte) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return
+	}
+
+	l.fields = l.fields[:0]
+	if bytes.IndexByte(line, '"') >= 0 {
+		// quoted columns are never written by the collector, but are valid CSV
+		row, err := csv.NewReader(bytes.NewReader(line)).Read()
+		if err != nil {
+			l.log.Errorw("invalid line", "error", err, "line", string(line))
+			return
+		}
+		for _, col := range row {
+			l.fields = append(l.fields, []byte(col))
+		}
+	} else {
+		for {
+			i := bytes.IndexByte(line, ',')
+			if i < 0 {
+				l.fields = append(l.fields, line)
+				break
 			}
+			l.fields = append(l.fields, line[:i])
+			line = line[i+1:]
 		}
-		log.Infow("Processed file",
-			"records", Printer.Sprintf("%d", cntTxInFileTotal),
-			"txTotal", Printer.Sprintf("%d", len(txs)),
-			"memUsedMiB", Printer.Sprintf("%d", GetMemUsageMb()),
-		)
 	}
 
-	return txs, cntProcessedRecords
+	if bytes.HasPrefix(l.fields[0], []byte("timestamp")) { // header row
+		return
+	}
+	if len(l.fields) < 3 { // timestamp,hash,source (optionally followed by the origin)
+		l.log.Errorw("invalid line", "line", string(bytes.Join(l.fields, []byte(","))))
+		return
+	}
+	l.cntRows += 1
+
+	if len(l.fields[1]) < 66 {
+		return
+	}
+
+	ts, ok := parseSourcelogTimestamp(l.fields[0])
+	if !ok {
+		l.log.Errorw("invalid timestamp", "line", string(bytes.Join(l.fields, []byte(","))))
+		return
+	}
+	txTimestamp := SourcelogTimestampToUs(ts)
+
+	// that it's a valid hash: 0x followed by 32 hex bytes
+	if !l.setHash(l.fields[1]) {
+		l.log.Errorw("invalid hash", "hash", string(l.fields[1]))
+		return
+	}
+
+	txSource, ok := l.sources[string(l.fields[2])]
+	if !ok {
+		col := string(l.fields[2])
+		txSource = TxSourcName(col)
+		l.sources[col] = txSource
+	}
+
+	l.cntRecords += 1
+
+	// Add entry to txs map (the map lookup with the converted byte slice doesn't allocate)
+	sources, ok := l.txs[string(l.hash[:])]
+	if !ok {
+		sources = make(map[string]int64, 1)
+		l.txs[string(l.hash[:])] = sources
+	}
+
+	// Update timestamp if it's earlier (i.e. alchemy often sending duplicate entries, this makes sure we record the earliest timestamp)
+	if prev, ok := sources[txSource]; !ok || prev == 0 || txTimestamp < prev {
+		sources[txSource] = txTimestamp
+	}
+}
+
+// setHash validates the hash column, and stores it lowercased in l.hash
+func (l *sourcelogLoader) setHash(col []byte) bool {
+	if len(col) != len(l.hash) || col[0] != '0' || (col[1] != 'x' && col[1] != 'X') {
+		return false
+	}
+	l.hash[0], l.hash[1] = '0', 'x'
+	for i := 2; i < len(col); i++ {
+		c := col[i]
+		switch {
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'f':
+			l.hash[i] = c
+		case c >= 'A' && c <= 'F':
+			l.hash[i] = c + ('a' - 'A')
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseSourcelogTimestamp parses a positive decimal timestamp, without the allocations of strconv for byte slices
+func parseSourcelogTimestamp(col []byte) (ts int64, ok bool) {
+	if len(col) == 0 || len(col) > 19 {
+		return 0, false
+	}
+	for _, c := range col {
+		if c < '0' || c > '9' || ts > (math.MaxInt64-9)/10 {
+			return 0, false
+		}
+		ts = ts*10 + int64(c-'0')
+	}
+	return ts, true
 }
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TrashSummary tallies the rows of trash files (timestamp_ms,hash,source,reason,notes) by reason and by source
+type TrashSummary struct {
+	Rows      int                       // all trashed rows (a tx can be trashed once per source)
+	UniqueTxs int                       // unique hashes
+	ByReason  map[string]int            // [reason] = rows
+	BySource  map[string]map[string]int // [source][reason] = rows
+	Invalid   int                       // rows which are not trash rows (i.e. too few columns)
+
+	hashes map[string]bool
+}
+
+func NewTrashSummary() *TrashSummary {
+	return &TrashSummary{ //nolint:exhaustruct
+		ByReason: make(map[string]int),
+		BySource: make(map[string]map[string]int),
+		hashes:   make(map[string]bool),
+	}
+}
+
+// Add tallies the rows of a trash file (header rows are skipped), and can be called once per file
+func (s *TrashSummary) Add(rows [][]string) {
+	for _, row := range rows {
+		if IsHeaderRow(row) {
+			continue
+		}
+		if len(row) < 4 {
+			s.Invalid++
+			continue
+		}
+
+		hash, source, reason := strings.ToLower(row[1]), row[2], row[3]
+		s.Rows++
+		s.ByReason[reason]++
+		if s.BySource[source] == nil {
+			s.BySource[source] = make(map[string]int)
+		}
+		s.BySource[source][reason]++
+		if !s.hashes[hash] {
+			s.hashes[hash] = true
+			s.UniqueTxs++
+		}
+	}
+}
+
+// String returns the report: the number of rows per reason, and per source and reason (sorted by count)
+func (s *TrashSummary) String() string {
+	out := fmt.Sprintf("Trashed rows: %s (unique txs: %s, invalid rows: %s)\n", Printer.Sprint(s.Rows), Printer.Sprint(s.UniqueTxs), Printer.Sprint(s.Invalid))
+	for _, reason := range sortedByCount(s.ByReason) {
+		out += fmt.Sprintf("- %-20s %10s   (%7s)\n", reason, Printer.Sprint(s.ByReason[reason]), Int64DiffPercentFmt(int64(s.ByReason[reason]), int64(s.Rows)))
+	}
+
+	sources := make([]string, 0, len(s.BySource))
+	for source := range s.BySource {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		out += fmt.Sprintf("\n%s:\n", source)
+		for _, reason := range sortedByCount(s.BySource[source]) {
+			out += fmt.Sprintf("- %-20s %10s\n", reason, Printer.Sprint(s.BySource[source][reason]))
+		}
+	}
+	return out
+}
+
+// sortedByCount returns the keys sorted by descending count, and alphabetically for equal counts
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -15,7 +15,7 @@ import (
 	"go.uber.org/zap"
 )
 
-// LoadTransactionCSVFiles loads transaction CSV files into a map[txHash]*TxEnvelope
+// LoadTransactionCSVFiles loads transaction CSV files (.csv, .csv.zip, compressed .csv.gz/.zst/.sz or day archives) into a map[txHash]*TxEnvelope
 // All transactions occurring in []knownTxsFiles are skipped
 func LoadTransactionCSVFiles(log *zap.SugaredLogger, files, knownTxsFiles []string) (txs map[string]*TxSummaryEntry, err error) {
 	// load previously known transaction hashes
@@ -32,7 +32,19 @@ func LoadTransactionCSVFiles(log *zap.SugaredLogger, files, knownTxsFiles []stri
 		log.Infof("Loading %s ...", filename)
 		cntProcessedFiles += 1
 
-		if strings.HasSuffix(filename, ".csv") {
+		if IsCompressedCSV(filename) {
+			r, err := openCompressedCSV(filename)
+			if err != nil {
+				log.Errorw("openCompressedCSV", "error", err, "file", filename)
+				return nil, err
+			}
+			defer r.Close()
+			err = readTxFile(log, r, prevKnownTxs, &txs)
+			if err != nil {
+				log.Errorw("readTxFile", "error", err, "file", filename)
+				return nil, err
+			}
+		} else if strings.HasSuffix(filename, ".csv") {
 			readFile, err := os.Open(filename)
 			if err != nil {
 				log.Errorw("os.Open", "error", err, "file", filename)
@@ -67,6 +79,14 @@ func LoadTransactionCSVFiles(log *zap.SugaredLogger, files, knownTxsFiles []stri
 					return nil, err
 				}
 			}
+		} else if IsArchive(filename) {
+			err = ReadArchive(filename, "transactions", func(name string, r io.Reader) error {
+				return readTxFile(log, r, prevKnownTxs, &txs)
+			})
+			if err != nil {
+				log.Errorw("readTxFile", "error", err, "file", filename)
+				return nil, err
+			}
 		} else {
 			log.Errorf("Unknown file type: %s", filename)
 			return nil, ErrUnsupportedFileFormat
@@ -98,8 +118,11 @@ func readTxFile(log *zap.SugaredLogger, rd io.Reader, prevKnownTxs map[string]bo
 		}
 
 		l = strings.Trim(l, "\n")
-		items := strings.Split(l, ",") // timestamp,hash,rlp
-		if len(items) != 3 {
+		items := strings.Split(l, ",") // timestamp,hash,rlp (optionally followed by y_parity,r,s and the origin)
+		if IsHeaderRow(items) {
+			continue
+		}
+		if len(items) < 3 {
 			log.Warnw("invalid line", "line", l)
 			continue
 		}
@@ -217,3 +240,58 @@ func LoadTxHashesFromMetadataCSVFiles(log *zap.SugaredLogger, files []string) (t
 
 	return txs, nil
 }
+
+// LoadInclusionTimesFromCSVFiles loads the inclusion block timestamps of txs from CSV files with the columns
+// hash,block_timestamp (unix seconds) into a map[txHash]timestampMs. Rows with an invalid timestamp, i.e. a header, are skipped.
+func LoadInclusionTimesFromCSVFiles(log *zap.SugaredLogger, files []string) (txs map[string]int64, err error) {
+	txs = make(map[string]int64)
+
+	for _, filename := range files {
+		log.Infof("Loading inclusion times from %s ...", filename)
+
+		rows, err := GetCSV(filename)
+		if err != nil {
+			log.Errorw("GetCSV", "error", err)
+			return nil, err
+		}
+
+		for _, record := range rows {
+			if len(record) < 2 {
+				log.Errorw("invalid line", "line", record)
+				continue
+			}
+
+			ts, err := strconv.ParseInt(strings.TrimSpace(record[1]), 10, 64)
+			if err != nil {
+				continue
+			}
+			txs[strings.ToLower(strings.TrimSpace(record[0]))] = ts * 1000
+		}
+	}
+
+	return txs, nil
+}
+
+// LoadTxHashesFromListFiles loads tx hashes from CSV files with one hash per line (only the first column is used)
+func LoadTxHashesFromListFiles(log *zap.SugaredLogger, files []string) (txs map[string]bool, err error) {
+	txs = make(map[string]bool)
+
+	for _, filename := range files {
+		log.Infof("Loading tx hashes from %s ...", filename)
+
+		rows, err := GetCSV(filename)
+		if err != nil {
+			log.Errorw("GetCSV", "error", err)
+			return nil, err
+		}
+
+		for _, record := range rows {
+			if len(record) == 0 || record[0] == "" {
+				continue
+			}
+			txs[strings.ToLower(strings.TrimSpace(record[0]))] = true
+		}
+	}
+
+	return txs, nil
+}
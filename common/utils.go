@@ -5,6 +5,8 @@ import (
 	"archive/zip"
 	"encoding/csv"
 	"errors"
+	"io"
+	"math/big"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,6 +15,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 	"go.uber.org/zap"
 	"golang.org/x/text/language"
@@ -69,6 +72,52 @@ func TxToRLPString(tx *types.Transaction) (string, error) {
 	return hexutil.Encode(b), nil
 }
 
+// TxSignature returns the signature values of a tx, with v normalized to the y-parity (0 or 1) for all tx types.
+// Legacy txs encode it as v = 27/28, or chainID*2 + 35/36 with EIP-155 replay protection, typed txs as y-parity.
+func TxSignature(tx *types.Transaction) (yParity uint64, r, s *big.Int) {
+	var v *big.Int
+	v, r, s = tx.RawSignatureValues()
+	if tx.Type() != types.LegacyTxType {
+		return v.Uint64(), r, s
+	}
+
+	if tx.Protected() {
+		offset := new(big.Int).Add(new(big.Int).Mul(tx.ChainId(), big.NewInt(2)), big.NewInt(35))
+		return new(big.Int).Sub(v, offset).Uint64(), r, s
+	}
+
+	if v.Uint64() >= 27 {
+		return v.Uint64() - 27, r, s
+	}
+	return v.Uint64(), r, s
+}
+
+// IntrinsicGas returns the gas charged for a tx before execution, with the current (Shanghai) rules: the base cost of
+// a call or contract creation, the calldata (zero and non-zero bytes, EIP-2028), the access list (EIP-2930) and the
+// init code words of contract creations (EIP-3860).
+func IntrinsicGas(tx *types.Transaction) uint64 {
+	data := tx.Data()
+	gas := params.TxGas
+	if tx.To() == nil {
+		gas = params.TxGasContractCreation
+		gas += (uint64(len(data)) + 31) / 32 * params.InitCodeWordGas
+	}
+
+	for _, b := range data {
+		if b == 0 {
+			gas += params.TxDataZeroGas
+		} else {
+			gas += params.TxDataNonZeroGasEIP2028
+		}
+	}
+
+	for _, tuple := range tx.AccessList() {
+		gas += params.TxAccessListAddressGas
+		gas += uint64(len(tuple.StorageKeys)) * params.TxAccessListStorageKeyGas
+	}
+	return gas
+}
+
 func IntDiffPercentFmt(a, b int) string {
 	diff := float64(a) / float64(b)
 	return Printer.Sprintf("%.2f%%", diff*100)
@@ -110,16 +159,74 @@ func MustBeFile(log *zap.SugaredLogger, fn string) {
 	}
 	if s.IsDir() {
 		log.Fatalf("Input file is a directory: %s", fn)
-	} else if filepath.Ext(fn) != ".csv" && !strings.HasSuffix(fn, ".csv.zip") {
-		log.Fatalf("Input file is not a .csv or .csv.zip file: %s", fn)
+	} else if filepath.Ext(fn) != ".csv" && !strings.HasSuffix(fn, ".csv.zip") && !IsCompressedCSV(fn) && !IsArchive(fn) {
+		log.Fatalf("Input file is not a .csv, .csv.zip, .csv.gz/.zst/.sz or %s file: %s", ArchiveExt, fn)
+	}
+}
+
+// IsHeaderRow returns whether a CSV row is the header row of a collector output file (see the -csv-header flag)
+func IsHeaderRow(row []string) bool {
+	return len(row) > 0 && strings.HasPrefix(row[0], "timestamp")
+}
+
+// ReadCSVFile calls fn for every CSV file in a file: the file itself (.csv, or compressed by the collector: .csv.gz,
+// .csv.zst or .csv.sz), the CSV files of a .zip file, or of the given subdirectory of a day archive (i.e. "sourcelog").
+// Unlike GetCSV, the content is streamed, for loaders that parse large files without holding all rows in memory.
+func ReadCSVFile(filename, subDir string, fn func(name string, r io.Reader) error) error {
+	if IsArchive(filename) {
+		return ReadArchive(filename, subDir, fn)
+	} else if IsCompressedCSV(filename) {
+		r, err := openCompressedCSV(filename)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return fn(filename, r)
+	} else if strings.HasSuffix(filename, ".csv") {
+		r, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return fn(filename, r)
+	} else if strings.HasSuffix(filename, ".zip") {
+		zipReader, err := zip.OpenReader(filename)
+		if err != nil {
+			return err
+		}
+		defer zipReader.Close()
+
+		for _, f := range zipReader.File {
+			if !strings.HasSuffix(f.Name, ".csv") {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = fn(f.Name, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+	return ErrUnsupportedFileFormat
 }
 
-// GetCSV returns a CSV content from a file (.csv or .csv.zip)
+// GetCSV returns a CSV content from a file (.csv, .csv.zip, or compressed by the collector: .csv.gz, .csv.zst or .csv.sz)
 func GetCSV(filename string) (rows [][]string, err error) {
 	rows = make([][]string, 0)
 
-	if strings.HasSuffix(filename, ".csv") {
+	if IsCompressedCSV(filename) {
+		r, err := openCompressedCSV(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return csv.NewReader(r).ReadAll()
+	} else if strings.HasSuffix(filename, ".csv") {
 		r, err := os.Open(filename)
 		if err != nil {
 			return nil, err
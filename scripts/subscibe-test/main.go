@@ -31,7 +31,7 @@ func main() {
 func MainGeneric() {
 	txC := make(chan collector.TxIn)
 	log := common.GetLogger(true, false)
-	nc := collector.NewNodeConnection(log, url, txC)
+	nc := collector.NewNodeConnection(collector.NodeOpts{Log: log, URI: url}, txC) //nolint:exhaustruct
 	go nc.Start()
 	for tx := range txC {
 		log.Infow("received tx", "tx", tx.Tx.Hash())
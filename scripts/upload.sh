@@ -56,6 +56,9 @@ echo "Merging transactions..."
 echo "Merging sourcelog..."
 /root/mempool-dumpster/build/merge sourcelog --out $1 --fn-prefix $date $1/sourcelog/*.csv
 
+echo "Archiving raw files..."
+/root/mempool-dumpster/build/merge archive --out "$1/${date}_raw.tar.zst" $1
+
 # compress
 cd $1
 echo "Compressing transaction files..."